@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/auth"
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// maxValidity bounds how long a grant may be valid for, so a forgotten grant
+// doesn't approve requests indefinitely
+const maxValidity = 7 * 24 * time.Hour
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "grant:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run mints a grant with the tenant key and writes the token to stdout
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("grant", flag.ContinueOnError)
+	keyFile := fs.String("key-file", os.Getenv("AUTHZ_KEY_FILE"), "tenant key file written by cmd/pair")
+	scope := fs.String("scope", "", `actions the grant approves, as "METHOD PATH" globs, e.g. "GET /reports/*"`)
+	validFor := fs.Duration("valid-for", time.Hour, "how long the grant is valid, at most a week")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return errors.New("--key-file is required")
+	}
+	if _, err := auth.ParseGrantScope(*scope); err != nil {
+		return err
+	}
+	if *validFor <= 0 || *validFor > maxValidity {
+		return fmt.Errorf("--valid-for must be positive and at most %s", maxValidity)
+	}
+
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := crypto.DecodeKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+
+	token, err := crypto.MintGrant(key, *scope, *validFor)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, token)
+	return nil
+}
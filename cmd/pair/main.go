@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/qrcode"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "pair:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run generates a key and writes the pairing details for it to stdout
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("pair", flag.ContinueOnError)
+	relayURL := fs.String("relay-url", os.Getenv("BROWSER_BASE_URL"), "base URL the relay serves the approval page on, e.g. https://relay.example.com")
+	keyFile := fs.String("key-file", "", "write the encoded key to this file for the authz server; an existing file is never overwritten")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *relayURL == "" {
+		return errors.New("--relay-url is required")
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	tenantID := crypto.DeriveTenantID(key)
+
+	pairingURL, err := qrcode.PairingURL(*relayURL, key)
+	if err != nil {
+		return err
+	}
+	qr, err := qrcode.GeneratePairingQR(*relayURL, key)
+	if err != nil {
+		return fmt.Errorf("failed to render QR code: %w", err)
+	}
+
+	if *keyFile != "" {
+		if err := writeKeyFile(*keyFile, key); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stdout, "Tenant ID:   %s\n", tenantID)
+	fmt.Fprintf(stdout, "Pairing URL: %s\n", pairingURL)
+	if *keyFile != "" {
+		fmt.Fprintf(stdout, "Key file:    %s\n", *keyFile)
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprint(stdout, qr)
+	return nil
+}
+
+// writeKeyFile saves the encoded key readable only by its owner
+func writeKeyFile(filename string, key []byte) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, crypto.EncodeKey(key)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/qrcode"
+)
+
+// field returns the value printed after label in out
+func field(t *testing.T, out, label string) string {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if value, ok := strings.CutPrefix(line, label+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	t.Fatalf("no %s in output:\n%s", label, out)
+	return ""
+}
+
+func TestRun(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "pairing.key")
+	var out bytes.Buffer
+	if err := run([]string{"--relay-url", "https://relay.example.com", "--key-file", keyFile}, &out); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	encoded, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.DecodeKey(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		t.Fatalf("key file: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	tenantID := field(t, out.String(), "Tenant ID")
+	if tenantID != crypto.DeriveTenantID(key) {
+		t.Errorf("tenant ID = %s, want %s for the written key", tenantID, crypto.DeriveTenantID(key))
+	}
+	pairingURL := field(t, out.String(), "Pairing URL")
+	if want, _ := qrcode.PairingURL("https://relay.example.com", key); pairingURL != want {
+		t.Errorf("pairing URL = %s, want %s", pairingURL, want)
+	}
+	if field(t, out.String(), "Key file") != keyFile {
+		t.Errorf("output doesn't name the key file")
+	}
+	if qr, _ := qrcode.GenerateQR(pairingURL, qrcode.DefaultECLevel); !strings.HasSuffix(out.String(), qr) {
+		t.Error("output doesn't end with the pairing QR code")
+	}
+}
+
+func TestRunKeepsExistingKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "pairing.key")
+	if err := os.WriteFile(keyFile, []byte("existing\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := run([]string{"--relay-url", "https://relay.example.com", "--key-file", keyFile}, &out); err == nil {
+		t.Fatal("run overwrote an existing key file")
+	}
+	if content, _ := os.ReadFile(keyFile); string(content) != "existing\n" {
+		t.Errorf("key file = %q, want it untouched", content)
+	}
+}
+
+func TestRunRequiresRelayURL(t *testing.T) {
+	t.Setenv("BROWSER_BASE_URL", "")
+	if err := run(nil, &bytes.Buffer{}); err == nil {
+		t.Error("run succeeded without a relay URL")
+	}
+	t.Setenv("BROWSER_BASE_URL", "https://relay.example.com")
+	if err := run(nil, &bytes.Buffer{}); err != nil {
+		t.Errorf("run with BROWSER_BASE_URL: %v", err)
+	}
+}
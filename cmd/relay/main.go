@@ -2,273 +2,115 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	applog "github.com/yuval/extauth-match/internal/log"
+	"github.com/yuval/extauth-match/internal/relay/server"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for demo
-	},
-}
-
-type Tenant struct {
-	tenantID string
-	server   *websocket.Conn
-	client   *websocket.Conn
-	mu       sync.RWMutex
-	writeMu  sync.Mutex // Protects writes to client
-}
-
-type Relay struct {
-	tenants map[string]*Tenant
-	mu      sync.RWMutex
-}
-
-func NewRelay() *Relay {
-	return &Relay{
-		tenants: make(map[string]*Tenant),
-	}
-}
-
-func (r *Relay) getTenant(tenantID string) *Tenant {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// recentLogLines is how many log lines the relay keeps for /admin/logs
+const recentLogLines = 1000
 
-	if tenant, exists := r.tenants[tenantID]; exists {
-		return tenant
-	}
-
-	tenant := &Tenant{tenantID: tenantID}
-	r.tenants[tenantID] = tenant
-	return tenant
-}
-
-func (r *Relay) handleServerConnect(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	tenantID := vars["tenantID"]
+func main() {
+	recentLogs := applog.NewRingBuffer(recentLogLines)
+	applog.SetupLogging(recentLogs)
 
-	conn, err := upgrader.Upgrade(w, req, nil)
+	cfg, err := server.ParseConfig(os.Args[1:])
 	if err != nil {
-		slog.Error("Server upgrade failed", "tenantID", tenantID, "error", err)
-		return
+		slog.Error("Invalid relay configuration", "error", err)
+		os.Exit(2)
 	}
-
-	tenant := r.getTenant(tenantID)
-	tenant.mu.Lock()
-	tenant.server = conn
-	tenant.mu.Unlock()
-
-	slog.Info("Authz server connected", "tenantID", tenantID)
-
-	// Read from server and forward to client
-	go r.forwardServerToClient(tenant)
-}
-
-func (r *Relay) handleClientConnect(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	tenantID := vars["tenantID"]
-
-	conn, err := upgrader.Upgrade(w, req, nil)
-	if err != nil {
-		slog.Error("Client upgrade failed", "tenantID", tenantID, "error", err)
-		return
+	if cfg.LogLevelSet() {
+		applog.SetLevel(cfg.LogLevel)
 	}
 
-	// Set up ping/pong handlers for keepalive
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
-
-	tenant := r.getTenant(tenantID)
-	tenant.mu.Lock()
-
-	// Disconnect existing client if any
-	if tenant.client != nil {
-		slog.Info("Existing browser client found, disconnecting", "tenantID", tenantID)
-		tenant.client.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg, os.Args[1:], recentLogs, nil); err != nil {
+		slog.Error("Relay server failed", "error", err)
+		os.Exit(1)
 	}
-	tenant.client = conn
-	tenant.mu.Unlock()
-
-	slog.Info("Browser client connected", "tenantID", tenantID)
-
-	// Start ping ticker
-	go r.pingClient(tenant)
-
-	// Read from client and forward to server
-	go r.forwardClientToServer(tenant)
 }
 
-func (r *Relay) forwardServerToClient(tenant *Tenant) {
-	defer func() {
-		tenant.mu.Lock()
-		if tenant.server != nil {
-			tenant.server.Close()
-			tenant.server = nil
-		}
-		tenant.mu.Unlock()
-		slog.Info("Authz server disconnected", "tenantID", tenant.tenantID)
-	}()
-
-	for {
-		tenant.mu.RLock()
-		server := tenant.server
-		tenant.mu.RUnlock()
-
-		if server == nil {
-			return
-		}
-
-		messageType, message, err := server.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				slog.Error("Server read error", "tenantID", tenant.tenantID, "error", err)
-			}
-			return
-		}
-
-		// Forward to client
-		tenant.mu.RLock()
-		client := tenant.client
-		tenant.mu.RUnlock()
-
-		if client != nil {
-			tenant.writeMu.Lock()
-			err := client.WriteMessage(messageType, message)
-			tenant.writeMu.Unlock()
-
-			if err != nil {
-				slog.Error("Failed to forward to client", "tenantID", tenant.tenantID, "error", err)
-			} else {
-				slog.Info("Forwarded bytes from server to client", "bytes", len(message), "tenantID", tenant.tenantID)
-			}
-		}
+// run serves the relay configured by cfg until ctx is cancelled and then shuts
+// it down gracefully. args are parsed again on SIGHUP to reload the config.
+// listening, if not nil, is called with the bound address once the relay
+// accepts connections.
+func run(ctx context.Context, cfg server.Config, args []string, logs *applog.RingBuffer, listening func(net.Addr)) error {
+	if len(cfg.AllowedOrigins) == 0 {
+		slog.Warn("No allowed origins configured, accepting WebSocket upgrades from any origin")
 	}
-}
-
-func (r *Relay) forwardClientToServer(tenant *Tenant) {
-	defer func() {
-		tenant.mu.Lock()
-		if tenant.client != nil {
-			tenant.client.Close()
-			tenant.client = nil
-		}
-		tenant.mu.Unlock()
-		slog.Info("Browser client disconnected", "tenantID", tenant.tenantID)
-	}()
-
-	for {
-		tenant.mu.RLock()
-		client := tenant.client
-		tenant.mu.RUnlock()
-
-		if client == nil {
-			return
-		}
 
-		messageType, message, err := client.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				slog.Error("Client read error", "tenantID", tenant.tenantID, "error", err)
-			}
-			return
-		}
-
-		// Forward to server
-		tenant.mu.RLock()
-		server := tenant.server
-		tenant.mu.RUnlock()
-
-		if server != nil {
-			if err := server.WriteMessage(messageType, message); err != nil {
-				slog.Error("Failed to forward to server", "tenantID", tenant.tenantID, "error", err)
-			} else {
-				slog.Info("Forwarded bytes from client to server", "bytes", len(message), "tenantID", tenant.tenantID)
-			}
-		}
+	relay, err := server.NewRelay(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create relay: %w", err)
 	}
-}
-
-// pingClient sends periodic pings to keep the WebSocket connection alive
-func (r *Relay) pingClient(tenant *Tenant) {
-	ticker := time.NewTicker(25 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		tenant.mu.RLock()
-		client := tenant.client
-		tenant.mu.RUnlock()
+	relay.SetLogs(logs)
 
-		if client == nil {
-			return
-		}
-
-		tenant.writeMu.Lock()
-		err := client.WriteMessage(websocket.PingMessage, nil)
-		tenant.writeMu.Unlock()
-
-		if err != nil {
-			slog.Warn("Failed to send ping to client", "tenantID", tenant.tenantID, "error", err)
-			return
-		}
+	router := mux.NewRouter()
+	if err := relay.RegisterRoutes(router); err != nil {
+		return fmt.Errorf("failed to register relay routes: %w", err)
 	}
-}
-
-func main() {
-	applog.SetupLogging()
 
-	relay := NewRelay()
+	go relay.ReloadOnSIGHUP(args)
 
-	router := mux.NewRouter()
-	router.HandleFunc("/ws/server/{tenantID}", relay.handleServerConnect)
-	router.HandleFunc("/ws/client/{tenantID}", relay.handleClientConnect)
+	runCtx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+	go relay.Run(runCtx)
 
-	// Serve static HTML for client
-	router.HandleFunc("/s/{tenantID}", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./web/static/index.html")
-	})
-
-	bindAddr := ":9090"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		bindAddr = ":" + envPort
+	var handler http.Handler = router
+	if cfg.AccessLog {
+		handler = server.AccessLog(router)
 	}
 
-	server := &http.Server{
+	bindAddr := cfg.Addr
+	httpServer := &http.Server{
 		Addr:    bindAddr,
-		Handler: router,
+		Handler: handler,
+	}
+
+	lis, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", bindAddr, err)
 	}
+	relay.SetReady(true)
 
+	served := make(chan error, 1)
 	go func() {
-		slog.Info("Relay server listening", "address", ":9090")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("Failed to start relay server", "error", err)
-			os.Exit(1)
+		if cfg.TLSEnabled() {
+			slog.Info("Relay server listening with TLS", "address", lis.Addr().String(), "scheme", "wss")
+			served <- httpServer.ServeTLS(lis, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			slog.Info("Relay server listening", "address", lis.Addr().String(), "scheme", "ws")
+			served <- httpServer.Serve(lis)
 		}
 	}()
+	if listening != nil {
+		listening(lis.Addr())
+	}
 
-	// Graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	select {
+	case err := <-served:
+		return fmt.Errorf("failed to start relay server: %w", err)
+	case <-ctx.Done():
+	}
 
+	// Graceful shutdown
 	slog.Info("Shutting down relay server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	relay.SetReady(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	server.Shutdown(ctx)
+	httpServer.Shutdown(shutdownCtx)
+	relay.Shutdown(shutdownCtx)
 	slog.Info("Relay server shutdown complete")
+	return nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	applog "github.com/yuval/extauth-match/internal/log"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+)
+
+const testTenant = "0123456789abcdef01234567"
+
+// startRelay runs the relay with args until the test ends and returns the
+// address it listens on
+func startRelay(t *testing.T, args ...string) net.Addr {
+	t.Helper()
+	cfg, err := server.ParseConfig(args)
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, cfg, args, applog.NewRingBuffer(10), func(addr net.Addr) { addrs <- addr })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run: %v", err)
+		}
+	})
+
+	select {
+	case addr := <-addrs:
+		return addr
+	case err := <-done:
+		t.Fatalf("run: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay didn't start listening")
+	}
+	return nil
+}
+
+// selfSignedCert writes a certificate for 127.0.0.1 and its key to dir,
+// returning their paths and a pool trusting the certificate
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestRelayServesTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t, t.TempDir())
+	addr := startRelay(t, "--addr", "127.0.0.1:0", "--tls-cert", certFile, "--tls-key", keyFile)
+
+	dialer := websocket.Dialer{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+		Subprotocols:    relayproto.Subprotocols,
+	}
+	conn, _, err := dialer.Dial("wss://"+addr.String()+"/ws/server/"+testTenant, nil)
+	if err != nil {
+		t.Fatalf("dial wss: %v", err)
+	}
+	conn.Close()
+
+	// Plain ws isn't served on the TLS port
+	if conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr.String()+"/ws/server/"+testTenant, nil); err == nil {
+		conn.Close()
+		t.Error("plain ws accepted on the TLS listener")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + addr.String() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz over TLS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestParseConfigTLSNeedsCertAndKey(t *testing.T) {
+	for _, args := range [][]string{{"--tls-cert", "cert.pem"}, {"--tls-key", "key.pem"}} {
+		if _, err := server.ParseConfig(args); err == nil {
+			t.Errorf("ParseConfig(%q) accepted half a TLS configuration", args)
+		}
+	}
+}
+
+func TestRelayServesFromFlags(t *testing.T) {
+	dir := t.TempDir()
+	page := `<html><body data-tenant="{{.TenantID}}">custom page</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(page), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addr := startRelay(t, "--addr", "127.0.0.1:0", "--static-dir", dir)
+	if port := addr.(*net.TCPAddr).Port; port == 0 {
+		t.Fatal("relay didn't bind an ephemeral port")
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/s/" + testTenant)
+	if err != nil {
+		t.Fatalf("GET client page: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `data-tenant="`+testTenant+`">custom page`) {
+		t.Errorf("client page = %d %q, want the page from --static-dir", resp.StatusCode, body)
+	}
+}
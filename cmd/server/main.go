@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/yuval/extauth-match/internal/auth"
 	"github.com/yuval/extauth-match/internal/crypto"
 	applog "github.com/yuval/extauth-match/internal/log"
+	"github.com/yuval/extauth-match/internal/notify"
 	"github.com/yuval/extauth-match/internal/qrcode"
 	"github.com/yuval/extauth-match/internal/relay"
 	"google.golang.org/grpc"
@@ -21,11 +27,21 @@ import (
 
 func main() {
 	applog.SetupLogging()
-	// Generate encryption key and tenant ID
-	encryptionKey, err := crypto.GenerateKey()
-	if err != nil {
-		slog.Error("Failed to generate encryption key", "error", err)
-		panic(err)
+	// Load the key saved by cmd/pair, or generate a fresh one and tenant ID
+	var encryptionKey []byte
+	var err error
+	if path := os.Getenv("AUTHZ_KEY_FILE"); path != "" {
+		encryptionKey, err = loadKey(path)
+		if err != nil {
+			slog.Error("Failed to load encryption key", "path", path, "error", err)
+			os.Exit(1)
+		}
+	} else {
+		encryptionKey, err = crypto.GenerateKey()
+		if err != nil {
+			slog.Error("Failed to generate encryption key", "error", err)
+			panic(err)
+		}
 	}
 	if os.Getenv("DANGEROUS_STATIC_ENCRYPTION_KEY") == "true" {
 		slog.Warn("Using static encryption key for testing purposes! DO NOT USE IN PRODUCTION!")
@@ -35,29 +51,85 @@ func main() {
 	}
 
 	tenantID := crypto.DeriveTenantID(encryptionKey)
-	encodedKey := crypto.EncodeKey(encryptionKey)
 
 	// Get browser base URL from environment or use default
 	browserBaseURL := os.Getenv("BROWSER_BASE_URL")
 	if browserBaseURL == "" {
 		browserBaseURL = "http://localhost:9090"
 	}
+	// Relay auth token: either given directly or derived from the relay's HMAC secret
+	relayAuthToken := os.Getenv("RELAY_AUTH_TOKEN")
+	if relayAuthToken == "" {
+		if secret := os.Getenv("RELAY_AUTH_SECRET"); secret != "" {
+			relayAuthToken = crypto.TenantToken([]byte(secret), tenantID)
+		}
+	}
+
 	// Generate and display QR code. do this first, so it doesn't mix with log lines
-	browserURL := fmt.Sprintf("%s/s/%s#key=%s", browserBaseURL, tenantID, encodedKey)
+	browserURL, err := qrcode.PairingURL(browserBaseURL, encryptionKey)
+	if err != nil {
+		slog.Error("Invalid BROWSER_BASE_URL", "error", err)
+		os.Exit(1)
+	}
+	// Browsers get their own token, which doesn't authenticate as the authz server
+	if relayAuthToken != "" {
+		browserURL += "&token=" + url.QueryEscape(crypto.ClientToken(relayAuthToken, tenantID))
+	}
 	fmt.Println("QR code", "ascii", qrcode.Generate(browserURL))
 
-	// Get relay URL from environment or use default
+	// Get relay URLs from environment or use default; several, comma-separated,
+	// are used for redundancy according to AUTHZ_RELAY_MODE
 	relayURL := os.Getenv("RELAY_URL")
 	if relayURL == "" {
 		relayURL = "ws://localhost:9090"
 	}
+	var relayURLs []string
+	for _, u := range strings.Split(relayURL, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			relayURLs = append(relayURLs, u)
+		}
+	}
+	relayMode := relay.RelayFailover
+	if v := os.Getenv("AUTHZ_RELAY_MODE"); v != "" {
+		relayMode, err = relay.ParseRelayMode(v)
+		if err != nil {
+			slog.Error("Invalid relay mode", "error", err)
+			os.Exit(1)
+		}
+	}
 
-	// Create relay client
-	relayClient, err := relay.NewClient(relayURL, tenantID, encryptionKey)
+	// Create relay client, optionally requiring several approvers to agree
+	var clientOpts []relay.Option
+	if quorum := envInt("AUTHZ_QUORUM", 1); quorum > 1 {
+		approvers := envInt("AUTHZ_APPROVERS", 0)
+		clientOpts = append(clientOpts, relay.WithQuorum(quorum, approvers))
+		slog.Info("Quorum approval enabled", "required", quorum, "approvers", approvers)
+	}
+	if os.Getenv("AUTHZ_COMPRESSION") == "true" {
+		clientOpts = append(clientOpts, relay.WithCompression(true))
+	}
+	if os.Getenv("AUTHZ_PAYLOAD_COMPRESSION") == "true" {
+		clientOpts = append(clientOpts, relay.WithPayloadCompression(true))
+	}
+	if v := os.Getenv("AUTHZ_RECONNECT_POLICY"); v != "" {
+		policy, err := relay.ParseReconnectPolicy(v)
+		if err != nil {
+			slog.Error("Invalid reconnect policy", "error", err)
+			os.Exit(1)
+		}
+		clientOpts = append(clientOpts, relay.WithReconnectPolicy(policy))
+	}
+	relayClient, err := relay.NewMultiClient(relayURLs, relayMode, tenantID, encryptionKey, clientOpts...)
 	if err != nil {
 		slog.Error("Failed to create relay client", "error", err)
 		os.Exit(1)
 	}
+	if relayAuthToken != "" {
+		relayClient.SetAuthToken(relayAuthToken)
+	}
+	if webhookURL := os.Getenv("AUTHZ_WEBHOOK_URL"); webhookURL != "" {
+		relayClient.SetWebhook(relay.NewWebhook(webhookURL))
+	}
 
 	// Connect to relay
 	if err := relayClient.Connect(); err != nil {
@@ -65,11 +137,123 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Requests the approver doesn't decide within the timeout are denied
+	authTimeout := envDuration("AUTHZ_TIMEOUT", auth.DefaultTimeout)
+
 	// Create auth service with relay client
-	authService := auth.NewService(relayClient)
+	authService := auth.NewService(relayClient, authTimeout)
+
+	// Fallback decisions when the approver can't answer; deny unless configured
+	var policy auth.DecisionPolicy
+	for name, target := range map[string]*auth.Decision{
+		"AUTHZ_ON_TIMEOUT":     &policy.OnTimeout,
+		"AUTHZ_ON_NO_APPROVER": &policy.OnNoApprover,
+	} {
+		if v := os.Getenv(name); v != "" {
+			decision, err := auth.ParseDecision(v)
+			if err != nil {
+				slog.Error("Invalid fallback policy", "name", name, "error", err)
+				os.Exit(1)
+			}
+			*target = decision
+		}
+	}
+	authService.SetPolicy(policy)
+	slog.Info("Fallback policy", "onTimeout", policy.OnTimeout, "onNoApprover", policy.OnNoApprover)
+
+	// Rules decide low-risk requests without prompting the approver
+	if path := os.Getenv("AUTHZ_POLICY_FILE"); path != "" {
+		rules, err := auth.LoadRulePolicy(path)
+		if err != nil {
+			slog.Error("Failed to load policy rules", "error", err)
+			os.Exit(1)
+		}
+		authService.SetRules(rules)
+		slog.Info("Policy rules loaded", "path", path, "rules", len(rules.Rules))
+	}
+
+	// Offline grants minted with the tenant key approve what they cover
+	if os.Getenv("AUTHZ_ALLOW_GRANTS") == "true" {
+		authService.SetGrantKey(encryptionKey)
+		slog.Info("Offline grants enabled", "header", auth.GrantHeader)
+	}
+
+	// Append a JSON line per decision to the audit log when configured
+	var auditor *auth.JSONAuditor
+	if path := os.Getenv("AUTHZ_AUDIT_LOG"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			slog.Error("Failed to open audit log", "path", path, "error", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		auditor = auth.NewJSONAuditor(f, 1024)
+		authService.SetAuditor(auditor, tenantID)
+		slog.Info("Audit log enabled", "path", path)
+	}
+
+	// Requests carrying a cache key reuse recent decisions when a TTL is set
+	cacheAllowTTL := envDuration("AUTHZ_CACHE_ALLOW_TTL", 0)
+	cacheDenyTTL := envDuration("AUTHZ_CACHE_DENY_TTL", 0)
+	if cacheAllowTTL > 0 || cacheDenyTTL > 0 {
+		cacheSize := envInt("AUTHZ_CACHE_SIZE", 1024)
+		authService.SetCache(auth.NewLRUCache(cacheSize, cacheAllowTTL, cacheDenyTTL))
+		slog.Info("Decision cache enabled", "size", cacheSize, "allowTTL", cacheAllowTTL, "denyTTL", cacheDenyTTL)
+	}
+
+	// Cap prompts per minute so a flood of requests can't bury the approver
+	if perMinute := envInt("AUTHZ_PROMPTS_PER_MINUTE", 0); perMinute > 0 {
+		mode := auth.PromptLimitDeny
+		if v := os.Getenv("AUTHZ_PROMPT_LIMIT_MODE"); v != "" {
+			mode, err = auth.ParsePromptLimitMode(v)
+			if err != nil {
+				slog.Error("Invalid prompt limit mode", "error", err)
+				os.Exit(1)
+			}
+		}
+		authService.SetPromptLimiter(auth.NewPromptLimiter(perMinute, mode))
+		slog.Info("Prompt limit enabled", "perMinute", perMinute, "mode", mode)
+	}
 
-	// Set decision handler
-	relayClient.SetDecisionHandler(authService.HandleDecision)
+	// Remember decisions by Envoy request ID so retried checks get the same answer
+	var fileStore *auth.FileStore
+	if ttl := envDuration("AUTHZ_DECISION_TTL", 0); ttl > 0 {
+		var store auth.DecisionStore
+		var sweep func()
+		if path := os.Getenv("AUTHZ_DECISION_STORE"); path != "" {
+			fileStore, err = auth.OpenFileStore(path, ttl)
+			if err != nil {
+				slog.Error("Failed to open decision store", "path", path, "error", err)
+				os.Exit(1)
+			}
+			store, sweep = fileStore, fileStore.Sweep
+		} else {
+			memoryStore := auth.NewMemoryStore(ttl)
+			store, sweep = memoryStore, memoryStore.Sweep
+		}
+		authService.SetStore(store)
+		go auth.SweepEvery(context.Background(), ttl, sweep)
+		slog.Info("Decision store enabled", "ttl", ttl, "path", os.Getenv("AUTHZ_DECISION_STORE"))
+	}
+
+	// Push a notification to the approver's phone when a request arrives while
+	// no approval page is open
+	if credentials := os.Getenv("AUTHZ_FCM_CREDENTIALS"); credentials != "" {
+		tokens := notify.NewTokenStore()
+		for _, token := range strings.Split(os.Getenv("AUTHZ_FCM_DEVICE_TOKENS"), ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens.Register(tenantID, token)
+			}
+		}
+		fcm, err := notify.NewFCM(credentials, os.Getenv("AUTHZ_FCM_PROJECT_ID"), browserBaseURL, tokens)
+		if err != nil {
+			slog.Error("Failed to set up push notifications", "error", err)
+			os.Exit(1)
+		}
+		authService.SetNotifier(fcm, tenantID)
+		relayClient.SetDeliveryHandler(authService.HandleDelivery)
+		slog.Info("Push notifications enabled", "project", fcm.ProjectID, "devices", len(tokens.Tokens(tenantID)))
+	}
 
 	slog.Info("Tenant ID", "tenantID", tenantID)
 	slog.Info("Browser URL", "url", browserURL)
@@ -91,6 +275,26 @@ func main() {
 		}
 	}()
 
+	// Optionally serve Envoy's HTTP ext_authz protocol as well
+	var httpAuthzServer *http.Server
+	if addr := os.Getenv("AUTHZ_HTTP_ADDR"); addr != "" {
+		pathPrefix := os.Getenv("AUTHZ_HTTP_PATH")
+		if pathPrefix == "" {
+			pathPrefix = "/"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(pathPrefix, auth.NewHTTPHandler(authService, pathPrefix))
+		httpAuthzServer = &http.Server{Addr: addr, Handler: mux}
+
+		go func() {
+			slog.Info("HTTP ext_authz server listening", "address", addr, "path", pathPrefix)
+			if err := httpAuthzServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Failed to serve HTTP ext_authz", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Start gRPC server for ext_authz
 	grpcServer := grpc.NewServer()
 	authv3.RegisterAuthorizationServer(grpcServer, authService)
@@ -117,8 +321,58 @@ func main() {
 	slog.Info("Shutting down...")
 
 	grpcServer.GracefulStop()
+	if httpAuthzServer != nil {
+		httpAuthzServer.Close()
+	}
 	relayClient.Close()
+	if auditor != nil {
+		auditor.Close()
+	}
+	if fileStore != nil {
+		fileStore.Close()
+	}
 	slog.Info("Shutdown complete")
 }
 
+// loadKey reads an encoded key written by cmd/pair
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.DecodeKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	return key, crypto.ValidateKey(key)
+}
+
+// envDuration reads a duration from the environment, exiting on invalid values
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Error("Invalid duration", "name", name, "value", v)
+		os.Exit(1)
+	}
+	return d
+}
+
+// envInt reads an integer from the environment, exiting on invalid values
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("Invalid integer", "name", name, "value", v)
+		os.Exit(1)
+	}
+	return n
+}
+
 //ss
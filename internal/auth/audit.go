@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// AuditEvent is the record of one authorization decision
+type AuditEvent struct {
+	RequestID string `json:"requestId"`
+	TenantID  string `json:"tenantId"`
+	Summary   string `json:"summary"`
+	Approved  bool   `json:"approved"`
+	// Source is what produced the decision: approver, rule, grant, cache, store,
+	// limit, fallback or error
+	Source string `json:"source"`
+	Reason string `json:"reason,omitempty"`
+	// Approver identifies who decided, when known
+	Approver    string    `json:"approver,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+	DecidedAt   time.Time `json:"decidedAt"`
+}
+
+// Auditor records authorization decisions. Record must not block the decision path.
+type Auditor interface {
+	Record(event AuditEvent)
+}
+
+// JSONAuditor writes audit events as JSON lines from a background goroutine.
+// Events are dropped, with a warning, if the writer falls too far behind.
+type JSONAuditor struct {
+	events chan AuditEvent
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewJSONAuditor starts an auditor writing to w, queueing up to queueSize events
+func NewJSONAuditor(w io.Writer, queueSize int) *JSONAuditor {
+	a := &JSONAuditor{
+		events: make(chan AuditEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+	go a.run(w)
+	return a
+}
+
+// Record queues event for writing without blocking
+func (a *JSONAuditor) Record(event AuditEvent) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+
+	select {
+	case a.events <- event:
+	default:
+		slog.Warn("Audit queue full, dropping event", "requestID", event.RequestID)
+	}
+}
+
+// Close writes any queued events and stops the auditor
+func (a *JSONAuditor) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+func (a *JSONAuditor) run(w io.Writer) {
+	defer close(a.done)
+	encoder := json.NewEncoder(w)
+	for event := range a.events {
+		if err := encoder.Encode(event); err != nil {
+			slog.Error("Failed to write audit event", "requestID", event.RequestID, "error", err)
+		}
+	}
+}
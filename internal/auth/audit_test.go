@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditRecordsDecisions(t *testing.T) {
+	var buf bytes.Buffer
+	auditor := NewJSONAuditor(&buf, 10)
+	for _, approved := range []bool{true, false} {
+		s := NewService(&fakeRelay{approved: approved}, time.Second)
+		s.SetAuditor(auditor, "tenant-1")
+		allowed(t, s, checkRequest("PUT", "example.com", "/config", map[string]string{":authority": "example.com"}, nil))
+	}
+	auditor.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit records, want 2:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []AuditEvent{
+		{Approved: true, Source: SourceApprover},
+		{Approved: false, Source: SourceApprover, Reason: "Access denied by user"},
+	} {
+		var event AuditEvent
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatalf("audit record %q isn't JSON: %v", lines[i], err)
+		}
+		if event.Approved != want.Approved || event.Source != want.Source || event.Reason != want.Reason {
+			t.Errorf("record %d = %+v, want %+v", i, event, want)
+		}
+		if event.RequestID == "" || event.TenantID != "tenant-1" || event.Summary != "PUT example.com/config from 10.0.0.1" {
+			t.Errorf("record %d = %+v, want the request's ID, tenant and summary", i, event)
+		}
+		if event.RequestedAt.IsZero() || event.DecidedAt.Before(event.RequestedAt) {
+			t.Errorf("record %d timestamps: requested %v, decided %v", i, event.RequestedAt, event.DecidedAt)
+		}
+	}
+}
+
+// stalledWriter blocks every write until release is closed
+type stalledWriter struct {
+	release chan struct{}
+}
+
+func (w stalledWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestAuditDoesNotBlock(t *testing.T) {
+	w := stalledWriter{release: make(chan struct{})}
+	auditor := NewJSONAuditor(w, 1)
+
+	// With the writer stuck, events past the queue are dropped, not waited on
+	done := make(chan struct{})
+	go func() {
+		for range 10 {
+			auditor.Record(AuditEvent{RequestID: "req"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record blocked on a stalled writer")
+	}
+
+	close(w.release)
+	auditor.Close()
+	auditor.Record(AuditEvent{RequestID: "after close"})
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DecisionCache remembers recent approver decisions so repeated checks for the
+// same cache key don't prompt again
+type DecisionCache interface {
+	// Get returns the cached decision for key, if one hasn't expired
+	Get(key string) (approved bool, ok bool)
+	// Put records the approver's decision for key
+	Put(key string, approved bool)
+}
+
+// LRUCache is an in-memory DecisionCache that evicts the least recently used
+// entry once full. Approvals and denials expire after separate TTLs; a zero
+// TTL disables caching for that outcome.
+type LRUCache struct {
+	capacity int
+	allowTTL time.Duration
+	denyTTL  time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key      string
+	approved bool
+	expires  time.Time
+}
+
+// NewLRUCache creates a cache holding at most capacity decisions
+func NewLRUCache(capacity int, allowTTL, denyTTL time.Duration) *LRUCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRUCache{
+		capacity: capacity,
+		allowTTL: allowTTL,
+		denyTTL:  denyTTL,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached decision for key, if one hasn't expired
+func (c *LRUCache) Get(key string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return false, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.approved, true
+}
+
+// Put records the approver's decision for key
+func (c *LRUCache) Put(key string, approved bool) {
+	ttl := c.denyTTL
+	if approved {
+		ttl = c.allowTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.approved = approved
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, approved: approved, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestCache returns an LRUCache whose clock only moves when advanced
+func newTestCache(capacity int, allowTTL, denyTTL time.Duration) (*LRUCache, func(time.Duration)) {
+	c := NewLRUCache(capacity, allowTTL, denyTTL)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLRUCacheHit(t *testing.T) {
+	c, _ := newTestCache(8, time.Minute, time.Minute)
+	if _, ok := c.Get("alice"); ok {
+		t.Fatal("hit on an empty cache")
+	}
+	c.Put("alice", true)
+	c.Put("bob", false)
+	if approved, ok := c.Get("alice"); !ok || !approved {
+		t.Errorf("alice = %v, %v, want approved", approved, ok)
+	}
+	if approved, ok := c.Get("bob"); !ok || approved {
+		t.Errorf("bob = %v, %v, want denied", approved, ok)
+	}
+}
+
+func TestLRUCacheExpiry(t *testing.T) {
+	c, advance := newTestCache(8, time.Minute, time.Minute)
+	c.Put("alice", true)
+	advance(59 * time.Second)
+	if _, ok := c.Get("alice"); !ok {
+		t.Fatal("expired before its TTL")
+	}
+	advance(time.Second)
+	if _, ok := c.Get("alice"); ok {
+		t.Error("still cached after its TTL")
+	}
+}
+
+func TestLRUCacheSeparateTTLs(t *testing.T) {
+	c, advance := newTestCache(8, time.Minute, 10*time.Second)
+	c.Put("allowed", true)
+	c.Put("denied", false)
+	advance(10 * time.Second)
+	if _, ok := c.Get("denied"); ok {
+		t.Error("denial outlived the deny TTL")
+	}
+	if _, ok := c.Get("allowed"); !ok {
+		t.Error("approval expired with the deny TTL")
+	}
+
+	// A zero TTL disables caching for that outcome
+	c, _ = newTestCache(8, 0, time.Minute)
+	c.Put("allowed", true)
+	if _, ok := c.Get("allowed"); ok {
+		t.Error("approval cached with a zero allow TTL")
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(2, time.Minute, time.Minute)
+	c.Put("a", true)
+	c.Put("b", true)
+	c.Get("a")
+	c.Put("c", true)
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("recently used entry evicted")
+	}
+}
+
+func TestCacheKeyBoundToRequest(t *testing.T) {
+	fake := &fakeRelay{approved: true}
+	s := NewService(fake, time.Second)
+	s.SetCache(NewLRUCache(8, time.Minute, time.Minute))
+	key := map[string]string{CacheKeyExtension: "alice"}
+
+	for range 2 {
+		if !allowed(t, s, checkRequest("GET", "example.com", "/reports", map[string]string{"host": "example.com"}, key)) {
+			t.Fatal("request denied")
+		}
+	}
+	if fake.prompts() != 1 {
+		t.Fatalf("prompts = %d, want the repeat served from the cache", fake.prompts())
+	}
+
+	// The same key on another method, host or path must ask the approver
+	fake.approved = false
+	for _, req := range [][3]string{
+		{"DELETE", "example.com", "/reports"},
+		{"GET", "other.example.com", "/reports"},
+		{"GET", "example.com", "/admin"},
+	} {
+		if allowed(t, s, checkRequest(req[0], req[1], req[2], map[string]string{"host": req[1]}, key)) {
+			t.Errorf("%v reused the cached approval", req)
+		}
+	}
+	if fake.prompts() != 4 {
+		t.Errorf("prompts = %d, want 4", fake.prompts())
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// GrantHeader carries an offline grant minted by the approver; a request it
+// covers is approved without prompting
+const GrantHeader = "x-authz-grant"
+
+// ParseGrantScope parses a grant scope of the form "METHOD PATH", e.g.
+// "GET /reports/*". Both are globs as in a Rule; "*" matches any method.
+func ParseGrantScope(scope string) (Rule, error) {
+	method, pattern, ok := strings.Cut(strings.TrimSpace(scope), " ")
+	if !ok {
+		return Rule{}, fmt.Errorf("invalid grant scope %q: must be METHOD PATH", scope)
+	}
+	rule := Rule{Method: method, Path: strings.TrimSpace(pattern), Action: ActionAllow}
+	if _, err := NewRulePolicy([]Rule{rule}); err != nil {
+		return Rule{}, fmt.Errorf("invalid grant scope %q: %w", scope, err)
+	}
+	return rule, nil
+}
+
+// checkGrant reports whether the request carries a valid grant covering it,
+// with the reason an offered grant doesn't
+func checkGrant(key []byte, req *PendingRequest) (bool, error) {
+	token := req.Headers[GrantHeader]
+	if token == "" {
+		return false, nil
+	}
+	scope, err := crypto.VerifyGrant(key, token)
+	if err != nil {
+		return false, err
+	}
+	rule, err := ParseGrantScope(scope)
+	if err != nil {
+		return false, err
+	}
+	if !rule.matches(req) {
+		return false, fmt.Errorf("request is outside the grant scope %q", scope)
+	}
+	return true, nil
+}
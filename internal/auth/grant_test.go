@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+func TestParseGrantScope(t *testing.T) {
+	rule, err := ParseGrantScope(" GET /reports/* ")
+	if err != nil || rule.Method != "GET" || rule.Path != "/reports/*" || rule.Action != ActionAllow {
+		t.Errorf("ParseGrantScope = %+v, %v", rule, err)
+	}
+	for _, scope := range []string{"", "/reports", "GET [", "GET "} {
+		if _, err := ParseGrantScope(scope); err == nil {
+			t.Errorf("ParseGrantScope(%q) succeeded", scope)
+		}
+	}
+}
+
+func TestCheckHonoursGrants(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mint := func(scope string, validFor time.Duration) string {
+		t.Helper()
+		token, err := crypto.MintGrant(key, scope, validFor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := mint("GET /reports/*", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := crypto.MintGrant(otherKey, "GET /reports/*", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, path, grant string
+		prompted                  bool
+	}{
+		{"valid", "GET", "/reports/q3", mint("GET /reports/*", time.Minute), false},
+		{"any method", "DELETE", "/reports/q3", mint("* /reports/*", time.Minute), false},
+		{"expired", "GET", "/reports/q3", expired, true},
+		{"method outside scope", "DELETE", "/reports/q3", mint("GET /reports/*", time.Minute), true},
+		{"path outside scope", "GET", "/admin", mint("GET /reports/*", time.Minute), true},
+		{"other tenant", "GET", "/reports/q3", foreign, true},
+	}
+	for _, tt := range tests {
+		// The approver denies, so only a grant lets the request through
+		fake := &fakeRelay{approved: false}
+		s := NewService(fake, time.Second)
+		s.SetGrantKey(key)
+		got := allowed(t, s, checkRequest(tt.method, "example.com", tt.path, map[string]string{GrantHeader: tt.grant}, nil))
+		if got == tt.prompted {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, !tt.prompted)
+		}
+		if prompted := fake.prompts() == 1; prompted != tt.prompted {
+			t.Errorf("%s: prompted = %v, want %v", tt.name, prompted, tt.prompted)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpCacheKey caches every HTTP check by its method, host and path
+const httpCacheKey = "http"
+
+// HTTPHandler adapts the Service to Envoy's HTTP ext_authz protocol: Envoy
+// forwards the original method, path and headers under pathPrefix and expects
+// 200 to allow or 403 to deny. Unlike gRPC context extensions, every forwarded
+// header comes from the client, so the cache key is derived here and the
+// x-authz-cache-key and x-authz-reason headers are ignored.
+type HTTPHandler struct {
+	service    *Service
+	pathPrefix string
+}
+
+// NewHTTPHandler returns an HTTP ext_authz handler serving under pathPrefix
+func NewHTTPHandler(service *Service, pathPrefix string) *HTTPHandler {
+	return &HTTPHandler{
+		service:    service,
+		pathPrefix: strings.TrimSuffix(pathPrefix, "/"),
+	}
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.RequestURI(), h.pathPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	headers := make(map[string]string, len(r.Header)+1)
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	delete(headers, CacheKeyExtension)
+	delete(headers, ReasonExtension)
+	if r.Host != "" {
+		headers["host"] = r.Host
+	}
+
+	pendingReq := &PendingRequest{
+		ID:        newRequestID(),
+		Method:    r.Method,
+		Path:      path,
+		Headers:   headers,
+		SourceIP:  sourceIP(r),
+		Timestamp: time.Now(),
+		// cacheKey binds this to the method, host and path
+		CacheKey: httpCacheKey,
+	}
+
+	out := h.service.decide(r.Context(), pendingReq)
+	if !out.approved {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(denyBody(out.reason)))
+		return
+	}
+
+	for name, value := range out.headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("x-authz-result", "approved")
+	w.WriteHeader(http.StatusOK)
+}
+
+// sourceIP returns the downstream client address. The client controls every
+// X-Forwarded-For entry but the last, which Envoy appends with the peer it
+// saw, so that one is used, after Envoy's x-envoy-external-address.
+func sourceIP(r *http.Request) string {
+	if addr := strings.TrimSpace(r.Header.Get("X-Envoy-External-Address")); addr != "" {
+		return addr
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		entries := strings.Split(xff[len(xff)-1], ",")
+		if last := strings.TrimSpace(entries[len(entries)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	for _, approved := range []bool{true, false} {
+		fake := &fakeRelay{approved: approved}
+		handler := NewHTTPHandler(NewService(fake, time.Second), "/authz/")
+
+		req := httptest.NewRequest(http.MethodPost, "/authz/admin/users?page=2", nil)
+		req.Host = "api.example.com"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if approved {
+			if rec.Code != http.StatusOK || rec.Header().Get("x-authz-result") != "approved" {
+				t.Errorf("approved: got %d with headers %v, want 200 approved", rec.Code, rec.Header())
+			}
+		} else if rec.Code != http.StatusForbidden || rec.Body.String() != `{"error":"Access denied by user"}` {
+			t.Errorf("denied: got %d %q, want 403 with the reason", rec.Code, rec.Body)
+		}
+
+		if fake.prompts() != 1 {
+			t.Fatalf("prompts = %d, want 1", fake.prompts())
+		}
+		// The summary matches what the gRPC service shows
+		if sent := fake.requests[0]; sent.Method != http.MethodPost || sent.Path != "/admin/users?page=2" || sent.Summary != "POST api.example.com/admin/users?page=2 from 10.0.0.1" {
+			t.Errorf("request sent = %+v", sent)
+		}
+	}
+}
+
+func TestHTTPHandlerIgnoresClientExtensions(t *testing.T) {
+	fake := &fakeRelay{approved: true}
+	service := NewService(fake, time.Second)
+	service.SetCache(NewLRUCache(10, time.Minute, time.Minute))
+	handler := NewHTTPHandler(service, "/")
+
+	check := func(path, cacheKey string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "api.example.com"
+		req.Header.Set(CacheKeyExtension, cacheKey)
+		req.Header.Set(ReasonExtension, "trust me")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// A client-chosen key neither opts out of caching nor carries a decision
+	// to another path
+	check("/admin", "one")
+	check("/admin", "two")
+	check("/other", "one")
+	if fake.prompts() != 2 {
+		t.Errorf("prompts = %d, want one per method, host and path", fake.prompts())
+	}
+	sent := fake.requests[0]
+	if sent.Reason != "" || sent.Headers[CacheKeyExtension] != "" || sent.Headers[ReasonExtension] != "" {
+		t.Errorf("client extension headers reached the approver: %+v", sent)
+	}
+}
+
+func TestHTTPHandlerEchoesApproverHeaders(t *testing.T) {
+	fake := &fakeRelay{approved: true, headers: map[string]string{
+		"X-Approved-By":  "alice",
+		"content-length": "0",
+		"x-authz-result": "denied",
+		"x-split":        "a\r\nx-injected: b",
+	}}
+	handler := NewHTTPHandler(NewService(fake, time.Second), "/")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("x-approved-by") != "alice" {
+		t.Errorf("got %d with headers %v, want 200 with the approver's header", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("content-length") != "" || rec.Header().Get("x-authz-result") != "approved" || rec.Header().Get("x-split") != "" {
+		t.Errorf("headers = %v, want disallowed approver headers dropped", rec.Header())
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.4:51234"
+	if ip := sourceIP(req); ip != "192.0.2.4" {
+		t.Errorf("sourceIP = %q, want the remote address without its port", ip)
+	}
+	// The client can prepend any entries; the last is the one Envoy appended
+	req.Header.Set("X-Forwarded-For", " 203.0.113.9 ,10.0.0.1 ")
+	if ip := sourceIP(req); ip != "10.0.0.1" {
+		t.Errorf("sourceIP = %q, want the last X-Forwarded-For entry", ip)
+	}
+	req.Header.Add("X-Forwarded-For", "198.51.100.7")
+	if ip := sourceIP(req); ip != "198.51.100.7" {
+		t.Errorf("sourceIP = %q, want the last entry of the last X-Forwarded-For header", ip)
+	}
+	req.Header.Set("X-Envoy-External-Address", "198.51.100.20")
+	if ip := sourceIP(req); ip != "198.51.100.20" {
+		t.Errorf("sourceIP = %q, want x-envoy-external-address", ip)
+	}
+}
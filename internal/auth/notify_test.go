@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestNotifiedOnlyWithoutApprovalPage(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantID := crypto.DeriveTenantID(key)
+	client, err := relay.NewClient(srv.WSURL(), tenantID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(client, 300*time.Millisecond)
+	notifier := &recordingNotifier{notified: make(chan string, 4)}
+	s.SetNotifier(notifier, tenantID)
+	client.SetDeliveryHandler(s.HandleDelivery)
+
+	// No page is open, so the relay buffers the request and the approver is notified
+	allowed(t, s, checkRequest("GET", "example.com", "/orders", nil, nil))
+	select {
+	case <-notifier.notified:
+	case <-time.After(time.Second):
+		t.Fatal("approver not notified with no approval page open")
+	}
+
+	browser, err := srv.DialBrowser(tenantID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	deadline := time.Now().Add(time.Second)
+	for srv.Clients(tenantID) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("browser never attached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The buffered request is flushed to the page first; skip it
+	go func() {
+		for {
+			req, err := browser.Next(context.Background(), nil)
+			if err != nil {
+				return
+			}
+			if req.Path == "/invoices" {
+				browser.Decide(req, true)
+			}
+		}
+	}()
+
+	if !allowed(t, s, checkRequest("GET", "example.com", "/invoices", nil, nil)) {
+		t.Fatal("request not approved by the open page")
+	}
+	select {
+	case id := <-notifier.notified:
+		t.Errorf("notified for %s with an approval page open", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import "fmt"
+
+// Decision is the outcome of an authorization check
+type Decision int
+
+const (
+	// Deny rejects the request; it is the zero value so policies fail closed
+	Deny Decision = iota
+	// Allow lets the request through
+	Allow
+)
+
+func (d Decision) String() string {
+	if d == Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// ParseDecision parses "allow" or "deny"
+func ParseDecision(value string) (Decision, error) {
+	switch value {
+	case "allow":
+		return Allow, nil
+	case "deny":
+		return Deny, nil
+	default:
+		return Deny, fmt.Errorf("invalid decision %q: must be allow or deny", value)
+	}
+}
+
+// DecisionPolicy decides what happens when the approver can't answer. The zero
+// value denies in both cases (fail-closed).
+type DecisionPolicy struct {
+	// OnTimeout applies when the approver doesn't decide in time
+	OnTimeout Decision
+	// OnNoApprover applies when the relay reports no browser is connected
+	OnNoApprover Decision
+}
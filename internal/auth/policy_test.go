@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestFallbackPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		relay  RelayClient
+		policy DecisionPolicy
+		want   bool
+	}{
+		{"timeout, fail closed", silentRelay{}, DecisionPolicy{OnNoApprover: Allow}, false},
+		{"timeout, fail open", silentRelay{}, DecisionPolicy{OnTimeout: Allow}, true},
+		{"no approver, fail closed", &fakeRelay{err: relay.ErrNoApprover}, DecisionPolicy{OnTimeout: Allow}, false},
+		{"no approver, fail open", &fakeRelay{err: relay.ErrNoApprover}, DecisionPolicy{OnNoApprover: Allow}, true},
+	} {
+		s := NewService(tc.relay, 20*time.Millisecond)
+		s.SetPolicy(tc.policy)
+		if got := allowed(t, s, checkRequest("GET", "example.com", "/", nil, nil)); got != tc.want {
+			t.Errorf("%s: allowed = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseDecision(t *testing.T) {
+	for value, want := range map[string]Decision{"allow": Allow, "deny": Deny} {
+		if got, err := ParseDecision(value); err != nil || got != want || got.String() != value {
+			t.Errorf("ParseDecision(%q) = %v, %v", value, got, err)
+		}
+	}
+	if got, err := ParseDecision("Allow"); err == nil || got != Deny {
+		t.Errorf("ParseDecision(\"Allow\") = %v, %v, want an error and deny", got, err)
+	}
+}
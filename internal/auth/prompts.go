@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPromptLimited is returned when an approver has been prompted too often
+// in the last minute and the limiter denies further prompts
+var ErrPromptLimited = errors.New("approver prompt limit reached")
+
+// PromptLimitMode is what happens to a request beyond the prompt limit
+type PromptLimitMode string
+
+const (
+	// PromptLimitDeny denies requests beyond the limit without prompting
+	PromptLimitDeny PromptLimitMode = "deny"
+	// PromptLimitQueue holds requests beyond the limit until the approver can
+	// be prompted again, or the check times out
+	PromptLimitQueue PromptLimitMode = "queue"
+)
+
+// ParsePromptLimitMode parses "deny" or "queue"
+func ParsePromptLimitMode(value string) (PromptLimitMode, error) {
+	switch mode := PromptLimitMode(value); mode {
+	case PromptLimitDeny, PromptLimitQueue:
+		return mode, nil
+	default:
+		return PromptLimitDeny, fmt.Errorf("invalid prompt limit mode %q: must be deny or queue", value)
+	}
+}
+
+// promptWindow is the period the prompt limit applies to
+const promptWindow = time.Minute
+
+// PromptLimiter caps how many prompts each approver is shown per minute, so a
+// flood of requests can't bury the approver in prompts until one is approved
+// by mistake
+type PromptLimiter struct {
+	perMinute int
+	mode      PromptLimitMode
+	now       func() time.Time
+
+	mu sync.Mutex
+	// prompts holds the times of each approver's prompts in the last minute, oldest first
+	prompts map[string][]time.Time
+}
+
+// NewPromptLimiter allows perMinute prompts per approver, handling requests
+// beyond that according to mode
+func NewPromptLimiter(perMinute int, mode PromptLimitMode) *PromptLimiter {
+	return &PromptLimiter{
+		perMinute: max(perMinute, 1),
+		mode:      mode,
+		now:       time.Now,
+		prompts:   make(map[string][]time.Time),
+	}
+}
+
+// Acquire reserves a prompt for approver. Beyond the limit it returns
+// ErrPromptLimited in deny mode, and in queue mode waits for a prompt to fall
+// out of the window, returning ctx's error if it's done first.
+func (l *PromptLimiter) Acquire(ctx context.Context, approver string) error {
+	for {
+		wait, ok := l.tryAcquire(approver)
+		if ok {
+			return nil
+		}
+		if l.mode != PromptLimitQueue {
+			return ErrPromptLimited
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryAcquire reserves a prompt if the approver is under the limit, otherwise
+// returning how long until the oldest prompt leaves the window
+func (l *PromptLimiter) tryAcquire(approver string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	prompts := l.prompts[approver]
+	for len(prompts) > 0 && !now.Before(prompts[0].Add(promptWindow)) {
+		prompts = prompts[1:]
+	}
+	if len(prompts) >= l.perMinute {
+		l.prompts[approver] = prompts
+		return prompts[0].Add(promptWindow).Sub(now), false
+	}
+	l.prompts[approver] = append(prompts, now)
+	return 0, true
+}
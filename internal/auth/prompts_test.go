@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shiftedClock returns a clock running offset ahead of real time, so tests
+// can age prompts out of the window while Acquire still waits in real time
+func shiftedClock(offset *atomic.Int64) func() time.Time {
+	return func() time.Time {
+		return time.Now().Add(time.Duration(offset.Load()))
+	}
+}
+
+func TestPromptLimitDenies(t *testing.T) {
+	var offset atomic.Int64
+	l := NewPromptLimiter(2, PromptLimitDeny)
+	l.now = shiftedClock(&offset)
+
+	for i := range 2 {
+		if err := l.Acquire(context.Background(), "alice"); err != nil {
+			t.Fatalf("prompt %d: %v", i+1, err)
+		}
+	}
+	if err := l.Acquire(context.Background(), "alice"); !errors.Is(err, ErrPromptLimited) {
+		t.Fatalf("third prompt: %v, want ErrPromptLimited", err)
+	}
+	if err := l.Acquire(context.Background(), "bob"); err != nil {
+		t.Errorf("another approver limited: %v", err)
+	}
+
+	offset.Store(int64(promptWindow))
+	if err := l.Acquire(context.Background(), "alice"); err != nil {
+		t.Errorf("prompt after the window: %v", err)
+	}
+}
+
+func TestPromptLimitQueues(t *testing.T) {
+	var offset atomic.Int64
+	l := NewPromptLimiter(1, PromptLimitQueue)
+	l.now = shiftedClock(&offset)
+	first := time.Now()
+	if err := l.Acquire(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, "alice"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("queued prompt: %v, want the context's deadline", err)
+	}
+
+	// Shifted, the first prompt leaves the window 50ms after it was made
+	offset.Store(int64(promptWindow - 50*time.Millisecond))
+	if err := l.Acquire(context.Background(), "alice"); err != nil {
+		t.Fatalf("queued prompt: %v", err)
+	}
+	if elapsed := time.Since(first); elapsed < 50*time.Millisecond {
+		t.Errorf("queued prompt granted %v after the first, want it held until the first left the window", elapsed)
+	}
+}
+
+func TestCheckPromptLimit(t *testing.T) {
+	fake := &fakeRelay{approved: true}
+	s := NewService(fake, time.Second)
+	s.SetPromptLimiter(NewPromptLimiter(2, PromptLimitDeny))
+	for i := range 3 {
+		got := allowed(t, s, checkRequest("GET", "example.com", "/orders", nil, nil))
+		if want := i < 2; got != want {
+			t.Errorf("request %d: allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if fake.prompts() != 2 {
+		t.Errorf("prompts = %d, want the limited request not shown", fake.prompts())
+	}
+
+	// Queued past the check timeout, the timeout fallback applies
+	s = NewService(fake, 50*time.Millisecond)
+	s.SetPromptLimiter(NewPromptLimiter(1, PromptLimitQueue))
+	s.SetPolicy(DecisionPolicy{OnTimeout: Allow})
+	allowed(t, s, checkRequest("GET", "example.com", "/orders", nil, nil))
+	if !allowed(t, s, checkRequest("GET", "example.com", "/orders", nil, nil)) {
+		t.Error("queued request didn't get the timeout fallback")
+	}
+	if fake.prompts() != 3 {
+		t.Errorf("prompts = %d, want the queued request not shown", fake.prompts())
+	}
+}
+
+func TestParsePromptLimitMode(t *testing.T) {
+	for _, value := range []string{"deny", "queue"} {
+		if mode, err := ParsePromptLimitMode(value); err != nil || string(mode) != value {
+			t.Errorf("ParsePromptLimitMode(%q) = %q, %v", value, mode, err)
+		}
+	}
+	if _, err := ParsePromptLimitMode("drop"); err == nil {
+		t.Error("ParsePromptLimitMode accepted drop")
+	}
+}
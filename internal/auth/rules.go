@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Policy makes machine decisions on requests that don't need a human. When
+// Evaluate reports a definitive decision it is returned without prompting the
+// approver; otherwise the request goes to the approver as usual.
+type Policy interface {
+	Evaluate(req *PendingRequest) (decision Decision, definitive bool)
+}
+
+// RuleAction is what a Rule does with the requests it matches
+type RuleAction string
+
+const (
+	ActionAllow RuleAction = "allow"
+	ActionDeny  RuleAction = "deny"
+	// ActionAsk sends the request to the approver, e.g. to carve an exception
+	// out of a broader allow rule further down
+	ActionAsk RuleAction = "ask"
+)
+
+// Rule matches requests by method and path. Both are path.Match globs, so "*"
+// doesn't cross "/"; an empty method matches any method.
+type Rule struct {
+	Method string     `json:"method,omitempty"`
+	Path   string     `json:"path"`
+	Action RuleAction `json:"action"`
+}
+
+// matches reports whether the rule applies to req
+func (r Rule) matches(req *PendingRequest) bool {
+	if r.Method != "" {
+		if ok, _ := path.Match(strings.ToUpper(r.Method), strings.ToUpper(req.Method)); !ok {
+			return false
+		}
+	}
+	// Query strings aren't part of the matched path
+	reqPath, _, _ := strings.Cut(req.Path, "?")
+	ok, _ := path.Match(r.Path, reqPath)
+	return ok
+}
+
+// RulePolicy is a Policy applying the first rule that matches a request.
+// Requests no rule matches go to the approver.
+type RulePolicy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// NewRulePolicy validates rules and returns a policy applying them in order
+func NewRulePolicy(rules []Rule) (*RulePolicy, error) {
+	for i, rule := range rules {
+		switch rule.Action {
+		case ActionAllow, ActionDeny, ActionAsk:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid action %q: must be allow, deny or ask", i, rule.Action)
+		}
+		if _, err := path.Match(rule.Path, ""); err != nil || rule.Path == "" {
+			return nil, fmt.Errorf("rule %d: invalid path pattern %q", i, rule.Path)
+		}
+		if _, err := path.Match(rule.Method, ""); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid method pattern %q", i, rule.Method)
+		}
+	}
+	return &RulePolicy{Rules: rules}, nil
+}
+
+// LoadRulePolicy reads a RulePolicy from a JSON file of the form
+// {"rules": [{"method": "GET", "path": "/healthz", "action": "allow"}]}
+func LoadRulePolicy(filename string) (*RulePolicy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var policy RulePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %q: %w", filename, err)
+	}
+	return NewRulePolicy(policy.Rules)
+}
+
+// Evaluate applies the first matching rule; ask and no match aren't definitive
+func (p *RulePolicy) Evaluate(req *PendingRequest) (Decision, bool) {
+	for _, rule := range p.Rules {
+		if !rule.matches(req) {
+			continue
+		}
+		switch rule.Action {
+		case ActionAllow:
+			return Allow, true
+		case ActionDeny:
+			return Deny, true
+		default:
+			return Deny, false
+		}
+	}
+	return Deny, false
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testRules(t *testing.T) *RulePolicy {
+	t.Helper()
+	policy, err := NewRulePolicy([]Rule{
+		{Method: "GET", Path: "/healthz", Action: ActionAllow},
+		{Path: "/public/secret", Action: ActionAsk},
+		{Method: "get", Path: "/public/*", Action: ActionAllow},
+		{Path: "/admin/*", Action: ActionDeny},
+	})
+	if err != nil {
+		t.Fatalf("NewRulePolicy: %v", err)
+	}
+	return policy
+}
+
+func TestRulePolicyEvaluate(t *testing.T) {
+	policy := testRules(t)
+	tests := []struct {
+		method, path string
+		decision     Decision
+		definitive   bool
+	}{
+		{"GET", "/healthz", Allow, true},
+		{"GET", "/healthz?verbose=1", Allow, true},
+		{"POST", "/healthz", Deny, false},
+		{"GET", "/public/logo.png", Allow, true},
+		{"GET", "/public/nested/logo.png", Deny, false},
+		{"GET", "/public/secret", Deny, false},
+		{"DELETE", "/admin/users", Deny, true},
+		{"GET", "/other", Deny, false},
+	}
+	for _, tt := range tests {
+		decision, definitive := policy.Evaluate(&PendingRequest{Method: tt.method, Path: tt.path})
+		if definitive != tt.definitive || (definitive && decision != tt.decision) {
+			t.Errorf("%s %s = %v, %v, want %v, %v", tt.method, tt.path, decision, definitive, tt.decision, tt.definitive)
+		}
+	}
+}
+
+func TestNewRulePolicyInvalid(t *testing.T) {
+	for name, rule := range map[string]Rule{
+		"action":  {Path: "/", Action: "maybe"},
+		"no path": {Action: ActionAllow},
+		"path":    {Path: "[", Action: ActionAllow},
+		"method":  {Method: "[", Path: "/", Action: ActionAllow},
+	} {
+		if _, err := NewRulePolicy([]Rule{rule}); err == nil {
+			t.Errorf("%s: NewRulePolicy accepted %+v", name, rule)
+		}
+	}
+}
+
+func TestLoadRulePolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"method": "GET", "path": "/healthz", "action": "allow"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadRulePolicy(path)
+	if err != nil {
+		t.Fatalf("LoadRulePolicy: %v", err)
+	}
+	if decision, ok := policy.Evaluate(&PendingRequest{Method: "GET", Path: "/healthz"}); !ok || decision != Allow {
+		t.Errorf("loaded policy = %v, %v, want a definitive allow", decision, ok)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": [{"path": "/", "action": "sometimes"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRulePolicy(path); err == nil {
+		t.Error("LoadRulePolicy accepted an invalid action")
+	}
+}
+
+func TestCheckAppliesRules(t *testing.T) {
+	tests := []struct {
+		name, method, path string
+		allowed            bool
+		prompts            int
+	}{
+		{"auto-allow", "GET", "/healthz", true, 0},
+		{"auto-deny", "GET", "/admin/users", false, 0},
+		{"ask", "GET", "/public/secret", true, 1},
+		{"no match", "POST", "/orders", true, 1},
+	}
+	for _, tt := range tests {
+		fake := &fakeRelay{approved: true}
+		s := NewService(fake, time.Second)
+		s.SetRules(testRules(t))
+		if got := allowed(t, s, checkRequest(tt.method, "example.com", tt.path, nil, nil)); got != tt.allowed {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.allowed)
+		}
+		if fake.prompts() != tt.prompts {
+			t.Errorf("%s: prompts = %d, want %d", tt.name, fake.prompts(), tt.prompts)
+		}
+	}
+}
@@ -2,50 +2,141 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	applog "github.com/yuval/extauth-match/internal/log"
+	"github.com/yuval/extauth-match/internal/notify"
+	"github.com/yuval/extauth-match/internal/relay"
 	"google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 )
 
+// DefaultTimeout is how long Check waits for the approver before denying
+const DefaultTimeout = 30 * time.Second
+
+// CacheKeyExtension is the ext_authz context extension naming the cache key for
+// a gRPC check; checks without one are never cached. A cached decision only
+// applies to the same method, host and path. The HTTP adapter ignores a header
+// of this name, since the client controls it.
+const CacheKeyExtension = "x-authz-cache-key"
+
+// ReasonExtension is the ext_authz context extension explaining to the approver
+// why a gRPC check needs approval
+const ReasonExtension = "x-authz-reason"
+
 // RelayClient interface for dependency injection
 type RelayClient interface {
-	SendRequest(data interface{}) error
+	SendRequestAndWait(ctx context.Context, requestID string, data interface{}) (bool, error)
+}
+
+// DecisionClient is a RelayClient that also returns the headers an approver
+// added to a decision, which the HTTP adapter echoes on approved responses
+type DecisionClient interface {
+	SendRequestAndWaitDecision(ctx context.Context, requestID string, data interface{}) (relay.Decision, error)
 }
 
 type Service struct {
 	authv3.UnimplementedAuthorizationServer
-	relayClient  RelayClient
-	pendingReqs  map[string]*PendingRequest
-	mu           sync.RWMutex
-	requestQueue chan *PendingRequest
+	relayClient RelayClient
+	timeout     time.Duration
+	cache       DecisionCache
+	store       DecisionStore
+	prompts     *PromptLimiter
+	grantKey    []byte
+	policy      DecisionPolicy
+	rules       Policy
+	auditor     Auditor
+	tenantID    string
+	notifier    notify.Notifier
+	// inflight holds the requests waiting on the approver, by ID
+	inflight sync.Map
+}
+
+// NewService creates an ext_authz service that asks the approver through the
+// relay, denying requests that aren't decided within timeout
+func NewService(relayClient RelayClient, timeout time.Duration) *Service {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Service{
+		relayClient: relayClient,
+		timeout:     timeout,
+		notifier:    notify.Nop{},
+	}
+}
+
+// SetCache enables caching of approver decisions for requests carrying a cache key
+func (s *Service) SetCache(cache DecisionCache) {
+	s.cache = cache
+}
+
+// SetStore records every decision for requests carrying RequestIDHeader in
+// store, and answers retried checks for them from it
+func (s *Service) SetStore(store DecisionStore) {
+	s.store = store
+}
+
+// SetPromptLimiter caps how often the approver is prompted
+func (s *Service) SetPromptLimiter(limiter *PromptLimiter) {
+	s.prompts = limiter
+}
+
+// SetGrantKey approves requests carrying a valid offline grant minted with key
+// (see crypto.MintGrant) without prompting
+func (s *Service) SetGrantKey(key []byte) {
+	s.grantKey = key
+}
+
+// SetPolicy sets the fallback decisions used when the approver can't answer
+func (s *Service) SetPolicy(policy DecisionPolicy) {
+	s.policy = policy
 }
 
-type PendingRequest struct {
-	ID        string
-	Method    string
-	Path      string
-	Headers   map[string]string
-	SourceIP  string
-	Timestamp time.Time
-	Response  chan bool
-	ctx       context.Context
+// SetRules lets rules decide requests that don't need the approver
+func (s *Service) SetRules(rules Policy) {
+	s.rules = rules
 }
 
-func NewService(relayClient RelayClient) *Service {
-	s := &Service{
-		relayClient:  relayClient,
-		pendingReqs:  make(map[string]*PendingRequest),
-		requestQueue: make(chan *PendingRequest, 100),
+// SetNotifier alerts tenantID's approver through notifier when a request is
+// sent while no approval page is connected
+func (s *Service) SetNotifier(notifier notify.Notifier, tenantID string) {
+	s.notifier = notifier
+	s.tenantID = tenantID
+}
+
+// HandleDelivery is a relay.DeliveryHandler notifying the approver when the
+// relay reports no approval page received a request
+func (s *Service) HandleDelivery(status relay.DeliveryStatus) {
+	// A rate-limited request was dropped whether or not a page is connected
+	if status.Clients > 0 || status.RateLimited {
+		return
+	}
+	value, ok := s.inflight.Load(status.RequestID)
+	if !ok {
+		return
 	}
-	go s.processQueue()
-	return s
+	pendingReq := value.(*PendingRequest)
+
+	// Delivery acks arrive on the relay read loop, which mustn't block
+	go func() {
+		if err := s.notifier.Notify(s.tenantID, pendingReq.Summary(), pendingReq.ID); err != nil {
+			slog.Error("Failed to notify approver", "requestID", pendingReq.ID, "error", err)
+		}
+	}()
+}
+
+// SetAuditor records every decision for tenantID with auditor
+func (s *Service) SetAuditor(auditor Auditor, tenantID string) {
+	s.auditor = auditor
+	s.tenantID = tenantID
 }
 
 func (s *Service) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
@@ -60,95 +151,247 @@ func (s *Service) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.
 		return s.denyResponse("No HTTP request"), nil
 	}
 
-	// Create pending request
-	reqID := fmt.Sprintf("%d", time.Now().UnixNano())
 	pendingReq := &PendingRequest{
-		ID:        reqID,
+		ID:        newRequestID(),
 		Method:    httpReq.GetMethod(),
 		Path:      httpReq.GetPath(),
 		Headers:   httpReq.GetHeaders(),
 		SourceIP:  attrs.GetSource().GetAddress().GetSocketAddress().GetAddress(),
 		Timestamp: time.Now(),
-		Response:  make(chan bool, 1),
-		ctx:       ctx,
+		CacheKey:  attrs.GetContextExtensions()[CacheKeyExtension],
+		Reason:    attrs.GetContextExtensions()[ReasonExtension],
+	}
+
+	if out := s.decide(ctx, pendingReq); !out.approved {
+		return s.denyResponse(out.reason), nil
+	}
+	return s.okResponse(), nil
+}
+
+// Decision sources recorded in audit events
+const (
+	SourceApprover = "approver"
+	SourceRule     = "rule"
+	SourceGrant    = "grant"
+	SourceCache    = "cache"
+	SourceStore    = "store"
+	SourceLimit    = "limit"
+	SourceFallback = "fallback"
+	SourceError    = "error"
+)
+
+// outcome is the result of deciding a request
+type outcome struct {
+	approved bool
+	// reason explains a denial to the caller
+	reason string
+	// source records what produced the decision
+	source string
+	// headers were added by the approver to an approved request's response
+	headers map[string]string
+}
+
+// decide resolves pendingReq and records the outcome with the auditor
+func (s *Service) decide(ctx context.Context, pendingReq *PendingRequest) outcome {
+	if traceID := traceIDOf(pendingReq.Headers); traceID != "" {
+		ctx = applog.WithTraceID(ctx, traceID)
+	}
+	out := s.resolveOnce(ctx, pendingReq)
+	if s.auditor != nil {
+		s.auditor.Record(AuditEvent{
+			RequestID:   pendingReq.ID,
+			TenantID:    s.tenantID,
+			Summary:     pendingReq.Summary(),
+			Approved:    out.approved,
+			Source:      out.source,
+			Reason:      out.reason,
+			RequestedAt: pendingReq.Timestamp,
+			DecidedAt:   time.Now(),
+		})
 	}
+	return out
+}
 
-	// Store pending request
-	s.mu.Lock()
-	s.pendingReqs[reqID] = pendingReq
-	s.mu.Unlock()
+// resolveOnce answers a retried check with the decision already made for it,
+// and otherwise resolves pendingReq and stores the decision. A stored request
+// ID on another method, host or path is denied rather than answered.
+func (s *Service) resolveOnce(ctx context.Context, pendingReq *PendingRequest) outcome {
+	requestID := pendingReq.Headers[RequestIDHeader]
+	if s.store == nil || requestID == "" {
+		return s.resolve(ctx, pendingReq)
+	}
 
-	// Add to queue for processing
-	s.requestQueue <- pendingReq
+	if record, ok := s.store.Get(requestID); ok {
+		if !record.matches(pendingReq) {
+			slog.WarnContext(ctx, "Request ID reused for a different request, denying", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path, "storedMethod", record.Method, "storedPath", record.Path)
+			return outcome{reason: "Request ID reused", source: SourceStore}
+		}
+		slog.InfoContext(ctx, "Using stored decision", "requestID", pendingReq.ID, "decision", record.Decision)
+		if record.Decision == Deny {
+			return outcome{reason: "Access denied", source: SourceStore}
+		}
+		return outcome{approved: true, source: SourceStore}
+	}
 
-	// Wait for response with 30s timeout
-	timeout := time.NewTimer(30 * time.Second)
-	defer timeout.Stop()
+	out := s.resolve(ctx, pendingReq)
+	// Errors such as a cancelled check and prompt limit denials are worth
+	// retrying, so aren't stored
+	if out.source != SourceError && out.source != SourceLimit {
+		decision := Deny
+		if out.approved {
+			decision = Allow
+		}
+		s.store.Put(requestID, DecisionRecord{Decision: decision, Method: pendingReq.Method, Host: pendingReq.host(), Path: pendingReq.Path})
+	}
+	return out
+}
 
-	select {
-	case approved := <-pendingReq.Response:
-		s.cleanup(reqID)
-		if approved {
-			slog.Info("Request approved", "requestID", reqID, "method", pendingReq.Method, "path", pendingReq.Path)
-			return s.okResponse(), nil
+// traceIDOf returns the trace ID of a request from its headers: Envoy's
+// x-request-id, else the trace-id field of a W3C traceparent header
+func traceIDOf(headers map[string]string) string {
+	if id := headers[RequestIDHeader]; id != "" {
+		return id
+	}
+	// traceparent is version-traceid-parentid-flags
+	if parts := strings.Split(headers["traceparent"], "-"); len(parts) == 4 {
+		return parts[1]
+	}
+	return ""
+}
+
+// resolve asks the approver about pendingReq, waiting at most s.timeout
+func (s *Service) resolve(ctx context.Context, pendingReq *PendingRequest) outcome {
+	if s.rules != nil {
+		if decision, ok := s.rules.Evaluate(pendingReq); ok {
+			slog.InfoContext(ctx, "Decided by rule", "requestID", pendingReq.ID, "decision", decision, "method", pendingReq.Method, "path", pendingReq.Path)
+			if decision == Deny {
+				return outcome{reason: "Access denied by policy", source: SourceRule}
+			}
+			return outcome{approved: true, source: SourceRule}
+		}
+	}
+
+	if s.grantKey != nil {
+		granted, err := checkGrant(s.grantKey, pendingReq)
+		if err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid grant", "requestID", pendingReq.ID, "error", err)
 		}
-		slog.Info("Request denied", "requestID", reqID, "method", pendingReq.Method, "path", pendingReq.Path)
-		return s.denyResponse("Access denied by user"), nil
-	case <-timeout.C:
-		s.cleanup(reqID)
-		slog.Info("Request timed out", "requestID", reqID, "method", pendingReq.Method, "path", pendingReq.Path)
-		return s.denyResponse("Authorization timeout"), nil
-	case <-ctx.Done():
-		s.cleanup(reqID)
-		slog.Info("Request cancelled", "requestID", reqID, "method", pendingReq.Method, "path", pendingReq.Path)
-		return s.denyResponse("Request cancelled"), nil
-	}
-}
-
-func (s *Service) processQueue() {
-	for pendingReq := range s.requestQueue {
-		// Send encrypted request via relay
-		msg := map[string]interface{}{
-			"id":        pendingReq.ID,
-			"method":    pendingReq.Method,
-			"path":      pendingReq.Path,
-			"headers":   pendingReq.Headers,
-			"sourceIP":  pendingReq.SourceIP,
-			"timestamp": pendingReq.Timestamp.Format(time.RFC3339),
+		if granted {
+			slog.InfoContext(ctx, "Approved by grant", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+			return outcome{approved: true, source: SourceGrant}
 		}
+	}
 
-		if err := s.relayClient.SendRequest(msg); err != nil {
-			slog.Error("Failed to send request to relay", "error", err)
-			pendingReq.Response <- false
-			continue
+	if key := pendingReq.cacheKey(); s.cache != nil && key != "" {
+		if approved, ok := s.cache.Get(key); ok {
+			slog.InfoContext(ctx, "Using cached decision", "requestID", pendingReq.ID, "cacheKey", pendingReq.CacheKey, "approved", approved)
+			if !approved {
+				return outcome{reason: "Access denied by user", source: SourceCache}
+			}
+			return outcome{approved: true, source: SourceCache}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if s.prompts != nil {
+		switch err := s.prompts.Acquire(ctx, s.tenantID); {
+		case errors.Is(err, ErrPromptLimited):
+			slog.WarnContext(ctx, "Approver prompt limit reached, denying", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+			return outcome{reason: "Too many approval requests", source: SourceLimit}
+		case errors.Is(err, context.DeadlineExceeded):
+			return s.fallback(ctx, pendingReq, "timeout", s.policy.OnTimeout, "Authorization timeout")
+		case err != nil:
+			slog.InfoContext(ctx, "Request cancelled", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+			return outcome{reason: "Request cancelled", source: SourceError}
 		}
+	}
+
+	slog.InfoContext(ctx, "Sending request for approval", "requestID", pendingReq.ID, "summary", pendingReq.Summary())
+
+	s.inflight.Store(pendingReq.ID, pendingReq)
+	approved, headers, err := s.askApprover(ctx, pendingReq)
+	s.inflight.Delete(pendingReq.ID)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return s.fallback(ctx, pendingReq, "timeout", s.policy.OnTimeout, "Authorization timeout")
+	case errors.Is(err, relay.ErrNoApprover):
+		return s.fallback(ctx, pendingReq, "no approver", s.policy.OnNoApprover, "No approver available")
+	case errors.Is(err, relay.ErrRateLimited):
+		// Never the no-approver fallback: flooding the relay mustn't be a way
+		// to reach an allow policy
+		slog.WarnContext(ctx, "Relay rate limit dropped request, denying", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+		return outcome{reason: "Too many approval requests", source: SourceLimit}
+	case errors.Is(err, context.Canceled):
+		slog.InfoContext(ctx, "Request cancelled", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+		return outcome{reason: "Request cancelled", source: SourceError}
+	case err != nil:
+		slog.ErrorContext(ctx, "Failed to send request to relay", "requestID", pendingReq.ID, "error", err)
+		return outcome{reason: "Approval unavailable", source: SourceError}
+	}
+
+	if key := pendingReq.cacheKey(); s.cache != nil && key != "" {
+		s.cache.Put(key, approved)
+	}
 
-		slog.Info("Sent encrypted request to relay", "requestID", pendingReq.ID)
+	if !approved {
+		slog.InfoContext(ctx, "Request denied", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+		return outcome{reason: "Access denied by user", source: SourceApprover}
 	}
+	slog.InfoContext(ctx, "Request approved", "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+	return outcome{approved: true, source: SourceApprover, headers: approverHeaders(headers)}
 }
 
-func (s *Service) HandleDecision(reqID string, approved bool) {
-	s.mu.RLock()
-	pendingReq, exists := s.pendingReqs[reqID]
-	s.mu.RUnlock()
+// askApprover sends pendingReq to the approver and waits for the decision,
+// with the headers the approver added if the relay client reports them
+func (s *Service) askApprover(ctx context.Context, pendingReq *PendingRequest) (bool, map[string]string, error) {
+	if client, ok := s.relayClient.(DecisionClient); ok {
+		decision, err := client.SendRequestAndWaitDecision(ctx, pendingReq.ID, pendingReq.authRequest())
+		return decision.Approved, decision.Headers, err
+	}
+	approved, err := s.relayClient.SendRequestAndWait(ctx, pendingReq.ID, pendingReq.authRequest())
+	return approved, nil, err
+}
 
-	if !exists {
-		slog.Info("Request not found or already processed", "requestID", reqID)
-		return
+// approverHeaders returns the headers an approver may add to a response:
+// x- prefixed names other than this service's own x-authz- ones, with values
+// that can't split the header. Anything else is dropped, so a decision can't
+// change how the response is framed.
+func approverHeaders(headers map[string]string) map[string]string {
+	var allowed map[string]string
+	for name, value := range headers {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "x-") || strings.HasPrefix(name, "x-authz-") || !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			slog.Warn("Ignoring header added by the approver", "header", name)
+			continue
+		}
+		if allowed == nil {
+			allowed = make(map[string]string)
+		}
+		allowed[name] = value
 	}
+	return allowed
+}
 
-	select {
-	case pendingReq.Response <- approved:
-		slog.Info("Decision sent for request", "requestID", reqID, "approved", approved)
-	default:
-		slog.Error("Failed to send decision for request (channel full or closed)", "requestID", reqID)
+// validHeaderName reports whether name is made of lowercase letters, digits
+// and hyphens
+func validHeaderName(name string) bool {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
 	}
+	return true
 }
 
-func (s *Service) cleanup(reqID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.pendingReqs, reqID)
+// fallback applies a policy decision for a request the approver couldn't answer
+func (s *Service) fallback(ctx context.Context, pendingReq *PendingRequest, condition string, decision Decision, reason string) outcome {
+	slog.WarnContext(ctx, "Applying fallback policy", "condition", condition, "decision", decision, "requestID", pendingReq.ID, "method", pendingReq.Method, "path", pendingReq.Path)
+	if decision == Allow {
+		return outcome{approved: true, source: SourceFallback}
+	}
+	return outcome{reason: reason, source: SourceFallback}
 }
 
 func (s *Service) okResponse() *authv3.CheckResponse {
@@ -183,8 +426,13 @@ func (s *Service) denyResponse(reason string) *authv3.CheckResponse {
 						},
 					},
 				},
-				Body: fmt.Sprintf(`{"error":"%s"}`, reason),
+				Body: denyBody(reason),
 			},
 		},
 	}
 }
+
+// denyBody returns the JSON body sent with a denial
+func denyBody(reason string) string {
+	return fmt.Sprintf(`{"error":"%s"}`, reason)
+}
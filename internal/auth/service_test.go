@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/yuval/extauth-match/internal/relay"
+	"google.golang.org/grpc/codes"
+)
+
+// fakeRelay answers every request with approved, headers and err, recording
+// the requests
+type fakeRelay struct {
+	mu       sync.Mutex
+	approved bool
+	headers  map[string]string
+	err      error
+	requests []relay.AuthRequest
+}
+
+func (f *fakeRelay) SendRequestAndWait(ctx context.Context, requestID string, data interface{}) (bool, error) {
+	decision, err := f.SendRequestAndWaitDecision(ctx, requestID, data)
+	return decision.Approved, err
+}
+
+func (f *fakeRelay) SendRequestAndWaitDecision(ctx context.Context, requestID string, data interface{}) (relay.Decision, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req, ok := data.(relay.AuthRequest); ok {
+		f.requests = append(f.requests, req)
+	}
+	return relay.Decision{RequestID: requestID, Approved: f.approved, Headers: f.headers}, f.err
+}
+
+// prompts returns how many requests reached the approver
+func (f *fakeRelay) prompts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// silentRelay never answers, returning once the request's context ends
+type silentRelay struct{}
+
+func (silentRelay) SendRequestAndWait(ctx context.Context, requestID string, data interface{}) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+// checkRequest builds an ext_authz CheckRequest for method and path
+func checkRequest(method, host, path string, headers, extensions map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+					SocketAddress: &corev3.SocketAddress{Address: "10.0.0.1"},
+				}},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  method,
+					Host:    host,
+					Path:    path,
+					Headers: headers,
+				},
+			},
+			ContextExtensions: extensions,
+		},
+	}
+}
+
+// allowed runs Check and reports whether the request was let through
+func allowed(t *testing.T, s *Service, req *authv3.CheckRequest) bool {
+	t.Helper()
+	resp, err := s.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	return resp.GetStatus().GetCode() == int32(codes.OK)
+}
+
+func TestCheckAsksApprover(t *testing.T) {
+	for _, approved := range []bool{true, false} {
+		fake := &fakeRelay{approved: approved}
+		s := NewService(fake, time.Second)
+		if got := allowed(t, s, checkRequest("GET", "example.com", "/admin", nil, nil)); got != approved {
+			t.Errorf("approver approved=%v: allowed = %v", approved, got)
+		}
+		if fake.prompts() != 1 {
+			t.Fatalf("prompts = %d, want 1", fake.prompts())
+		}
+		if req := fake.requests[0]; req.Method != "GET" || req.Path != "/admin" {
+			t.Errorf("request sent = %+v", req)
+		}
+	}
+}
+
+func TestCheckRateLimitedIsNotNoApprover(t *testing.T) {
+	// Even with an allow policy for a missing approver, a request the relay
+	// dropped for its rate limit is denied
+	s := NewService(&fakeRelay{err: relay.ErrRateLimited}, time.Second)
+	s.SetPolicy(DecisionPolicy{OnNoApprover: Allow})
+	if allowed(t, s, checkRequest("GET", "example.com", "/", nil, nil)) {
+		t.Error("rate-limited request was allowed by the no-approver policy")
+	}
+
+	s = NewService(&fakeRelay{err: relay.ErrNoApprover}, time.Second)
+	s.SetPolicy(DecisionPolicy{OnNoApprover: Allow})
+	if !allowed(t, s, checkRequest("GET", "example.com", "/", nil, nil)) {
+		t.Error("no-approver policy not applied")
+	}
+}
+
+// recordingNotifier records the requests it was asked to notify about
+type recordingNotifier struct {
+	notified chan string
+}
+
+func (n *recordingNotifier) Notify(tenantID, summary, requestID string) error {
+	n.notified <- requestID
+	return nil
+}
+
+func TestHandleDeliveryIgnoresRateLimited(t *testing.T) {
+	s := NewService(&fakeRelay{}, time.Second)
+	notifier := &recordingNotifier{notified: make(chan string, 2)}
+	s.SetNotifier(notifier, "tenant")
+	s.inflight.Store("req-1", &PendingRequest{ID: "req-1", Method: "GET", Path: "/"})
+
+	s.HandleDelivery(relay.DeliveryStatus{RequestID: "req-1", RateLimited: true})
+	s.HandleDelivery(relay.DeliveryStatus{RequestID: "req-1", Clients: 1})
+	select {
+	case id := <-notifier.notified:
+		t.Fatalf("notified for %s, which was rate limited or delivered", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.HandleDelivery(relay.DeliveryStatus{RequestID: "req-1"})
+	select {
+	case id := <-notifier.notified:
+		if id != "req-1" {
+			t.Errorf("notified for %s, want req-1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification for an undelivered request")
+	}
+}
+
+func TestCheckResponses(t *testing.T) {
+	resp, err := NewService(&fakeRelay{approved: true}, time.Second).Check(context.Background(), checkRequest("GET", "example.com", "/", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := resp.GetOkResponse().GetHeaders()[0].GetHeader(); header.GetKey() != "x-authz-result" || header.GetValue() != "approved" {
+		t.Errorf("OK response header = %v", header)
+	}
+
+	resp, err = NewService(&fakeRelay{}, time.Second).Check(context.Background(), checkRequest("GET", "example.com", "/", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := resp.GetDeniedResponse()
+	if resp.GetStatus().GetCode() != int32(codes.PermissionDenied) || denied.GetStatus().GetCode() != typev3.StatusCode_Forbidden || denied.GetBody() != `{"error":"Access denied by user"}` {
+		t.Errorf("denied response = %v", resp)
+	}
+
+	// A request without HTTP attributes is denied without asking
+	fake := &fakeRelay{approved: true}
+	if allowed(t, NewService(fake, time.Second), &authv3.CheckRequest{}) || fake.prompts() != 0 {
+		t.Error("request without attributes reached the approver")
+	}
+}
+
+func TestCheckSummary(t *testing.T) {
+	fake := &fakeRelay{approved: true}
+	allowed(t, NewService(fake, time.Second), checkRequest("DELETE", "api.example.com", "/users/7", map[string]string{":authority": "api.example.com", "authorization": "Bearer secret"}, nil))
+
+	req := fake.requests[0]
+	if req.Summary != "DELETE api.example.com/users/7 from 10.0.0.1" {
+		t.Errorf("summary = %q", req.Summary)
+	}
+	if req.ID == "" || req.Risk != relay.RiskHigh {
+		t.Errorf("request = %+v, want an ID and high risk", req)
+	}
+	if _, ok := req.Headers["authorization"]; ok {
+		t.Error("credentials shown to the approver")
+	}
+}
+
+func TestCheckTimeoutDenies(t *testing.T) {
+	s := NewService(silentRelay{}, 20*time.Millisecond)
+	start := time.Now()
+	resp, err := s.Check(context.Background(), checkRequest("GET", "example.com", "/", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus().GetCode() != int32(codes.PermissionDenied) || resp.GetDeniedResponse().GetBody() != `{"error":"Authorization timeout"}` {
+		t.Errorf("response = %v, want a timeout denial", resp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Check took %v with a 20ms timeout", elapsed)
+	}
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader is the header identifying a request across ext_authz
+// retries; Envoy sets it on every request it proxies
+const RequestIDHeader = "x-request-id"
+
+// DecisionStore records what was decided for each request so a retried Check
+// gets the same answer and decisions can be looked up after the fact
+type DecisionStore interface {
+	// Put records the decision for requestID
+	Put(requestID string, record DecisionRecord)
+	// Get returns the decision recorded for requestID, if it hasn't expired
+	Get(requestID string) (DecisionRecord, bool)
+}
+
+// DecisionRecord is a stored decision and the request it was made for. The
+// request ID is set by the caller, so a stored decision only answers a check
+// for the same method, host and path.
+type DecisionRecord struct {
+	Decision Decision
+	Method   string
+	Host     string
+	Path     string
+}
+
+// matches reports whether the record was made for pendingReq
+func (r DecisionRecord) matches(pendingReq *PendingRequest) bool {
+	return r.Method == pendingReq.Method && r.Host == pendingReq.host() && r.Path == pendingReq.Path
+}
+
+type storedDecision struct {
+	record  DecisionRecord
+	expires time.Time
+}
+
+// MemoryStore is an in-memory DecisionStore whose entries expire after a TTL.
+// Expired entries are never returned and are dropped by Sweep.
+type MemoryStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]storedDecision
+}
+
+// NewMemoryStore creates a store keeping decisions for ttl
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]storedDecision),
+	}
+}
+
+// Put records the decision for requestID
+func (s *MemoryStore) Put(requestID string, record DecisionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[requestID] = storedDecision{record: record, expires: s.now().Add(s.ttl)}
+}
+
+// Get returns the decision recorded for requestID, if it hasn't expired
+func (s *MemoryStore) Get(requestID string) (DecisionRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[requestID]
+	if !ok || !s.now().Before(entry.expires) {
+		return DecisionRecord{}, false
+	}
+	return entry.record, true
+}
+
+// Sweep drops expired decisions
+func (s *MemoryStore) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+}
+
+// sweepLocked drops expired decisions; s.mu must be held
+func (s *MemoryStore) sweepLocked() {
+	now := s.now()
+	for id, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// fileRecord is a line of a FileStore
+type fileRecord struct {
+	RequestID string    `json:"requestId"`
+	Decision  string    `json:"decision"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Expires   time.Time `json:"expires"`
+}
+
+func newFileRecord(requestID string, entry storedDecision) fileRecord {
+	return fileRecord{
+		RequestID: requestID,
+		Decision:  entry.record.Decision.String(),
+		Method:    entry.record.Method,
+		Host:      entry.record.Host,
+		Path:      entry.record.Path,
+		Expires:   entry.expires,
+	}
+}
+
+// FileStore is a DecisionStore that survives restarts. Decisions are kept in
+// memory and appended to a file as JSON lines; Sweep drops expired decisions
+// and rewrites the file without them.
+type FileStore struct {
+	*MemoryStore
+	path string
+	file *os.File
+}
+
+// OpenFileStore loads the unexpired decisions in path, creating it if needed,
+// and appends new decisions to it
+func OpenFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	store := &FileStore{MemoryStore: NewMemoryStore(ttl), path: path}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.rewrite(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// load reads the decisions in the store's file, skipping expired and corrupt lines
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open decision store: %w", err)
+	}
+	defer f.Close()
+
+	now := s.now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("Skipping corrupt decision store line", "path", s.path, "error", err)
+			continue
+		}
+		decision, err := ParseDecision(record.Decision)
+		if err != nil || !now.Before(record.Expires) {
+			continue
+		}
+		s.entries[record.RequestID] = storedDecision{
+			record:  DecisionRecord{Decision: decision, Method: record.Method, Host: record.Host, Path: record.Path},
+			expires: record.Expires,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read decision store: %w", err)
+	}
+	return nil
+}
+
+// rewrite replaces the store's file with its unexpired decisions and reopens
+// it for appending
+func (s *FileStore) rewrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked()
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write decision store: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	for id, entry := range s.entries {
+		if err := enc.Encode(newFileRecord(id, entry)); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write decision store: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write decision store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace decision store: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open decision store: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	return nil
+}
+
+// Put records the decision for requestID and appends it to the file
+func (s *FileStore) Put(requestID string, record DecisionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := storedDecision{record: record, expires: s.now().Add(s.ttl)}
+	s.entries[requestID] = entry
+	line, _ := json.Marshal(newFileRecord(requestID, entry))
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to persist decision", "requestID", requestID, "error", err)
+	}
+}
+
+// Sweep drops expired decisions and compacts the file
+func (s *FileStore) Sweep() {
+	if err := s.rewrite(); err != nil {
+		slog.Error("Failed to compact decision store", "path", s.path, "error", err)
+	}
+}
+
+// Close closes the store's file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// SweepEvery calls sweep at interval until ctx is done
+func SweepEvery(ctx context.Context, interval time.Duration, sweep func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweep()
+		}
+	}
+}
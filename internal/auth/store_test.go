@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var reportsRecord = DecisionRecord{Decision: Allow, Method: "GET", Host: "example.com", Path: "/reports"}
+
+func TestMemoryStorePutGet(t *testing.T) {
+	s := NewMemoryStore(time.Minute)
+	s.Put("req-1", reportsRecord)
+	if got, ok := s.Get("req-1"); !ok || got != reportsRecord {
+		t.Errorf("Get = %+v, %v, want %+v", got, ok, reportsRecord)
+	}
+	if _, ok := s.Get("req-2"); ok {
+		t.Error("hit for a request ID never stored")
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	s := NewMemoryStore(time.Minute)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	s.Put("req-1", reportsRecord)
+
+	now = now.Add(time.Minute)
+	if _, ok := s.Get("req-1"); ok {
+		t.Error("expired decision returned")
+	}
+	s.Sweep()
+	if len(s.entries) != 0 {
+		t.Errorf("%d entries left after Sweep, want 0", len(s.entries))
+	}
+}
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	s, err := OpenFileStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("req-1", reportsRecord)
+	s.Close()
+
+	s, err = OpenFileStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, ok := s.Get("req-1"); !ok || got != reportsRecord {
+		t.Errorf("after reopen Get = %+v, %v, want %+v", got, ok, reportsRecord)
+	}
+}
+
+func TestStoredDecisionBoundToRequest(t *testing.T) {
+	fake := &fakeRelay{approved: true}
+	s := NewService(fake, time.Second)
+	s.SetStore(NewMemoryStore(time.Minute))
+	headers := map[string]string{RequestIDHeader: "envoy-1", "host": "example.com"}
+
+	for range 2 {
+		if !allowed(t, s, checkRequest("GET", "example.com", "/reports", headers, nil)) {
+			t.Fatal("request denied")
+		}
+	}
+	if fake.prompts() != 1 {
+		t.Fatalf("prompts = %d, want the retry answered from the store", fake.prompts())
+	}
+
+	// Replaying the request ID on another request must not get the approval
+	if allowed(t, s, checkRequest("DELETE", "example.com", "/reports", headers, nil)) {
+		t.Error("replayed request ID on another method was allowed")
+	}
+	if allowed(t, s, checkRequest("GET", "example.com", "/admin", headers, nil)) {
+		t.Error("replayed request ID on another path was allowed")
+	}
+	if fake.prompts() != 1 {
+		t.Errorf("prompts = %d, want replays denied without prompting", fake.prompts())
+	}
+}
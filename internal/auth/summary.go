@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+// PendingRequest describes a request awaiting a decision from the approver
+type PendingRequest struct {
+	ID        string
+	Method    string
+	Path      string
+	Headers   map[string]string
+	SourceIP  string
+	Timestamp time.Time
+	// CacheKey opts the request into the decision cache; decisions are cached
+	// per key, method, host and path (see cacheKey)
+	CacheKey string
+	// Reason explains to the approver why the request needs approval
+	Reason string
+}
+
+// host returns the request's :authority, or its Host header for HTTP/1
+func (p *PendingRequest) host() string {
+	if host := p.Headers[":authority"]; host != "" {
+		return host
+	}
+	return p.Headers["host"]
+}
+
+// cacheKey returns the decision cache key for the request: the caller's
+// CacheKey bound to the method, host and path, so a key reused on another
+// request can't pick up its decision. It's empty if the request didn't opt in.
+func (p *PendingRequest) cacheKey() string {
+	if p.CacheKey == "" {
+		return ""
+	}
+	return strings.Join([]string{p.CacheKey, p.Method, p.host(), p.Path}, "\x00")
+}
+
+// Summary returns a one-line human-readable description shown to the approver
+func (p *PendingRequest) Summary() string {
+	summary := fmt.Sprintf("%s %s%s", p.Method, p.host(), p.Path)
+	if p.SourceIP != "" {
+		summary += " from " + p.SourceIP
+	}
+	return summary
+}
+
+// sensitiveHeaders are left out of what the approver is shown
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+	GrantHeader:           true,
+}
+
+// authRequest returns the payload sent to the browser through the relay
+func (p *PendingRequest) authRequest() relay.AuthRequest {
+	headers := make(map[string]string, len(p.Headers))
+	for name, value := range p.Headers {
+		if !sensitiveHeaders[name] {
+			headers[name] = value
+		}
+	}
+	return relay.AuthRequest{
+		ID:        p.ID,
+		Method:    p.Method,
+		Path:      p.Path,
+		SourceIP:  p.SourceIP,
+		Headers:   headers,
+		Summary:   p.Summary(),
+		Reason:    p.Reason,
+		Risk:      riskOf(p.Method),
+		Timestamp: p.Timestamp,
+	}
+}
+
+// riskOf rates a request by its method: reads are low risk, deletes high
+func riskOf(method string) relay.RiskLevel {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS":
+		return relay.RiskLow
+	case "DELETE":
+		return relay.RiskHigh
+	default:
+		return relay.RiskMedium
+	}
+}
+
+// newRequestID returns a random identifier used to match the browser's decision
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
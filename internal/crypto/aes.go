@@ -3,18 +3,30 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
+// KeySize is the length in bytes of a tenant's AES-256 key
+const KeySize = 32
+
 // GenerateKey generates a random 32-byte AES-256 key
 func GenerateKey() ([]byte, error) {
-	key := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+	return GenerateKeyFrom(rand.Reader)
+}
+
+// GenerateKeyFrom reads a 32-byte AES-256 key from r; tests can pass a
+// deterministic reader to get reproducible keys
+func GenerateKeyFrom(r io.Reader) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(r, key); err != nil {
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 	return key, nil
@@ -27,7 +39,9 @@ func DeriveTenantID(key []byte) string {
 	return hex.EncodeToString(hash[:12])
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM with the provided key
+// Encrypt encrypts plaintext using AES-256-GCM with the provided key, returning
+// nonce | ciphertext. Relay payloads and grants use SealEnvelope's
+// algorithm-tagged format instead.
 func Encrypt(key []byte, plaintext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -104,16 +118,113 @@ func EncodeKey(key []byte) string {
 	return base64.URLEncoding.EncodeToString(key)
 }
 
-// DecodeKey decodes a base64-encoded key from URL
+// EncodeKeyRaw encodes a key as unpadded base64 for URL embedding, one
+// character shorter than EncodeKey and free of '=', which some URL contexts
+// mangle
+func EncodeKeyRaw(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// DecodeKey decodes a base64-encoded key from URL, with or without padding
 func DecodeKey(encoded string) ([]byte, error) {
-	key, err := base64.URLEncoding.DecodeString(encoded)
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode key: %w", err)
 	}
 
-	if len(key) != 32 {
-		return nil, fmt.Errorf("invalid key length: expected 32 bytes, got %d", len(key))
+	if err := CheckKeyLength(key); err != nil {
+		return nil, err
 	}
 
 	return key, nil
 }
+
+// CheckKeyLength rejects a key that isn't KeySize bytes, which AES-256 and the
+// browser client require
+func CheckKeyLength(key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("invalid key length: expected %d bytes, got %d", KeySize, len(key))
+	}
+	return nil
+}
+
+// ValidateKey rejects keys with trivially low entropy: all bytes identical or a
+// short repeating pattern. It's a guard for imported keys, not a substitute for
+// GenerateKey.
+func ValidateKey(key []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("weak key: empty")
+	}
+	// A period of 1 covers keys made of a single repeated byte
+	for period := 1; period <= len(key)/2; period++ {
+		if repeats(key, period) {
+			return fmt.Errorf("weak key: repeats a %d-byte pattern", period)
+		}
+	}
+	return nil
+}
+
+// repeats reports whether key is made of its first period bytes repeated
+func repeats(key []byte, period int) bool {
+	for i := period; i < len(key); i++ {
+		if key[i] != key[i-period] {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeKeyWithID encodes a key for URL embedding prefixed with its generation,
+// e.g. "v2.<base64>", so a stale pairing URL can be recognised after rotation
+func EncodeKeyWithID(key []byte, version int) string {
+	return fmt.Sprintf("v%d.%s", version, EncodeKey(key))
+}
+
+// DecodeKeyWithID decodes a key encoded by EncodeKeyWithID, returning the key and
+// its generation. Untagged keys from EncodeKey decode as version 0.
+func DecodeKeyWithID(encoded string) ([]byte, int, error) {
+	version := 0
+	// The URL-safe base64 alphabet has no '.', so a dot always ends the tag
+	if tag, rest, found := strings.Cut(encoded, "."); found {
+		if !strings.HasPrefix(tag, "v") {
+			return nil, 0, fmt.Errorf("invalid key version tag %q", tag)
+		}
+		v, err := strconv.Atoi(tag[1:])
+		if err != nil || v < 0 {
+			return nil, 0, fmt.Errorf("invalid key version tag %q", tag)
+		}
+		version, encoded = v, rest
+	}
+
+	key, err := DecodeKey(encoded)
+	if err != nil {
+		return nil, 0, err
+	}
+	return key, version, nil
+}
+
+// TenantToken derives a relay auth token for a tenant as hex(HMAC-SHA256(secret, tenantID))
+func TenantToken(secret []byte, tenantID string) string {
+	return hex.EncodeToString(SignHMAC(secret, []byte(tenantID)))
+}
+
+// ClientToken derives the relay auth token browsers present for a tenant from
+// the authz server's token, as hex(HMAC-SHA256(serverToken, "client:"+tenantID)).
+// Holding it doesn't reveal the server token, so a paired browser can't
+// connect as the tenant's authz server.
+func ClientToken(serverToken, tenantID string) string {
+	return hex.EncodeToString(SignHMAC([]byte(serverToken), []byte("client:"+tenantID)))
+}
+
+// DeriveSubkey derives a length-byte key for purpose (e.g. "enc" or "mac") from
+// master using HKDF-SHA256, so one tenant key never serves two purposes directly
+func DeriveSubkey(master []byte, purpose string, length int) ([]byte, error) {
+	if len(master) == 0 {
+		return nil, fmt.Errorf("master key is empty")
+	}
+	key, err := hkdf.Key(sha256.New, master, nil, purpose, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive %q subkey: %w", purpose, err)
+	}
+	return key, nil
+}
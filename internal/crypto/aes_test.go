@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDeriveSubkey(t *testing.T) {
+	master := bytes.Repeat([]byte{0x0b}, 22)
+
+	// RFC 5869 test case 3: no salt and no info
+	want, _ := hex.DecodeString("8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8")
+	if got, err := DeriveSubkey(master, "", 42); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("DeriveSubkey = %x, %v, want the RFC 5869 output %x", got, err, want)
+	}
+
+	enc, err := DeriveSubkey(master, "enc", KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := DeriveSubkey(master, "enc", KeySize)
+	mac, _ := DeriveSubkey(master, "mac", KeySize)
+	if len(enc) != KeySize || !bytes.Equal(enc, again) {
+		t.Error("derivation isn't deterministic")
+	}
+	if bytes.Equal(enc, mac) {
+		t.Error("different purposes derived the same key")
+	}
+
+	if _, err := DeriveSubkey(nil, "enc", KeySize); err == nil {
+		t.Error("empty master key accepted")
+	}
+}
+
+func TestKeyWithID(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := EncodeKeyWithID(key, 2)
+	if !strings.HasPrefix(encoded, "v2.") {
+		t.Errorf("encoded key %q lacks the version tag", encoded)
+	}
+	decoded, version, err := DecodeKeyWithID(encoded)
+	if err != nil || version != 2 || !bytes.Equal(decoded, key) {
+		t.Errorf("DecodeKeyWithID(tagged) = %x, %d, %v", decoded, version, err)
+	}
+
+	// Legacy URLs carry the key alone, padded or not
+	for _, legacy := range []string{EncodeKey(key), EncodeKeyRaw(key)} {
+		decoded, version, err := DecodeKeyWithID(legacy)
+		if err != nil || version != 0 || !bytes.Equal(decoded, key) {
+			t.Errorf("DecodeKeyWithID(%q) = %x, %d, %v, want the key as version 0", legacy, decoded, version, err)
+		}
+	}
+
+	for _, bad := range []string{"2." + EncodeKey(key), "v-1." + EncodeKey(key), "vx." + EncodeKey(key), "v1.short"} {
+		if _, _, err := DecodeKeyWithID(bad); err == nil {
+			t.Errorf("DecodeKeyWithID(%q) accepted", bad)
+		}
+	}
+}
+
+// sequence returns the bytes 0, 1, 2, ... n-1
+func sequence(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestGenerateKeyFrom(t *testing.T) {
+	key, err := GenerateKeyFrom(bytes.NewReader(sequence(64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, sequence(KeySize)) {
+		t.Errorf("key = %x, want the reader's first %d bytes", key, KeySize)
+	}
+	if id := DeriveTenantID(key); id != "630dcd2966c4336691125448" {
+		t.Errorf("tenant ID = %s for a known key", id)
+	}
+
+	if _, err := GenerateKeyFrom(bytes.NewReader(sequence(KeySize - 1))); err == nil {
+		t.Error("short reader produced a key")
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, good := range map[string][]byte{"random": key, "sequence": sequence(KeySize)} {
+		if err := ValidateKey(good); err != nil {
+			t.Errorf("%s key rejected: %v", name, err)
+		}
+	}
+
+	for name, weak := range map[string][]byte{
+		"all zero":         make([]byte, KeySize),
+		"repeated byte":    bytes.Repeat([]byte{0xa5}, KeySize),
+		"repeated pattern": bytes.Repeat([]byte("abcd"), KeySize/4),
+		"half repeated":    append(sequence(KeySize/2), sequence(KeySize/2)...),
+		"empty":            nil,
+	} {
+		if err := ValidateKey(weak); err == nil || !strings.HasPrefix(err.Error(), "weak key") {
+			t.Errorf("%s key: err = %v, want a weak key error", name, err)
+		}
+	}
+}
+
+func TestEncodeKeyRaw(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, padded := EncodeKeyRaw(key), EncodeKey(key)
+	if strings.Contains(raw, "=") || len(raw) >= len(padded) {
+		t.Errorf("EncodeKeyRaw = %q, want %q without its padding", raw, padded)
+	}
+	for _, encoded := range []string{raw, padded} {
+		if got, err := DecodeKey(encoded); err != nil || !bytes.Equal(got, key) || len(got) != KeySize {
+			t.Errorf("DecodeKey(%q) = %x, %v, want %x", encoded, got, err, key)
+		}
+	}
+	if _, err := DecodeKey(EncodeKeyRaw(key[:16])); err == nil {
+		t.Error("DecodeKey accepted a 16-byte key")
+	}
+}
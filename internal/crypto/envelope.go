@@ -0,0 +1,279 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Algorithm identifies the AEAD used to seal an envelope
+type Algorithm byte
+
+const (
+	// AlgAES256GCM is AES-256-GCM with a 96-bit random nonce
+	AlgAES256GCM Algorithm = 1
+	// AlgXChaCha20Poly1305 is XChaCha20-Poly1305 with a 192-bit random nonce
+	AlgXChaCha20Poly1305 Algorithm = 2
+)
+
+// ErrExpired is returned by OpenEnvelope when an envelope's expiry has passed
+var ErrExpired = errors.New("envelope expired")
+
+// ErrUnsupportedAlgorithm is returned for algorithm IDs this build can't handle
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// ErrTooLarge is returned by OpenEnvelope when the plaintext would exceed the
+// maximum size, checked while decompressing so it is never fully inflated
+var ErrTooLarge = errors.New("plaintext too large")
+
+// envelopeHeaderSize is the algorithm byte plus a flags byte
+const envelopeHeaderSize = 2
+
+// Envelope flags
+const (
+	// flagCompressed marks a plaintext deflated before sealing
+	flagCompressed byte = 1 << iota
+	// flagExpiry marks a plaintext prefixed with an 8-byte Unix-millisecond expiry
+	flagExpiry
+
+	knownFlags = flagCompressed | flagExpiry
+)
+
+// expirySize is the length of the expiry prefix
+const expirySize = 8
+
+// DefaultMaxPlaintextSize bounds an opened envelope's plaintext so a small
+// compressed envelope can't expand without limit
+const DefaultMaxPlaintextSize = 16 << 20
+
+// SealOption configures SealEnvelope
+type SealOption func(*sealOptions)
+
+type sealOptions struct {
+	compress bool
+	validFor time.Duration
+}
+
+// WithCompression deflates the plaintext before sealing when that makes it
+// smaller; incompressible data is sealed as is
+func WithCompression() SealOption {
+	return func(o *sealOptions) {
+		o.compress = true
+	}
+}
+
+// Cipher is an AEAD keyed for one algorithm. Nonce sizes differ between
+// algorithms, so callers should use SealEnvelope and OpenEnvelope, which store
+// the algorithm and nonce alongside the ciphertext.
+type Cipher interface {
+	Algorithm() Algorithm
+	NonceSize() int
+	Seal(nonce, plaintext, additionalData []byte) []byte
+	Open(nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// aeadCipher adapts a cipher.AEAD to Cipher
+type aeadCipher struct {
+	cipher.AEAD
+	alg Algorithm
+}
+
+func (c aeadCipher) Algorithm() Algorithm {
+	return c.alg
+}
+
+func (c aeadCipher) Seal(nonce, plaintext, additionalData []byte) []byte {
+	return c.AEAD.Seal(nil, nonce, plaintext, additionalData)
+}
+
+func (c aeadCipher) Open(nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return c.AEAD.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// NewCipher returns a Cipher for alg keyed with key
+func NewCipher(alg Algorithm, key []byte) (Cipher, error) {
+	switch alg {
+	case AlgAES256GCM:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key length: expected 32 bytes, got %d", len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return aeadCipher{AEAD: gcm, alg: alg}, nil
+	case AlgXChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create XChaCha20-Poly1305: %w", err)
+		}
+		return aeadCipher{AEAD: aead, alg: alg}, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// WithExpiry makes the envelope unopenable once d has passed. The expiry is
+// sealed with the plaintext, so it can't be altered without failing decryption.
+func WithExpiry(d time.Duration) SealOption {
+	return func(o *sealOptions) {
+		o.validFor = d
+	}
+}
+
+// SealEnvelope encrypts plaintext with alg, producing
+// algorithm(1) | flags(1) | nonce | ciphertext. The header is authenticated as
+// additional data so it can't be altered to confuse the decrypting side.
+//
+// Offline grants and relay DATA payloads are sealed this way; the approval
+// page parses the same header before decrypting.
+func SealEnvelope(alg Algorithm, key, plaintext []byte, opts ...SealOption) ([]byte, error) {
+	var o sealOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c, err := NewCipher(alg, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags byte
+	if o.compress {
+		if deflated, err := deflate(plaintext); err == nil && len(deflated) < len(plaintext) {
+			plaintext = deflated
+			flags |= flagCompressed
+		}
+	}
+	if o.validFor > 0 {
+		expiry := make([]byte, expirySize, expirySize+len(plaintext))
+		binary.BigEndian.PutUint64(expiry, uint64(time.Now().Add(o.validFor).UnixMilli()))
+		plaintext = append(expiry, plaintext...)
+		flags |= flagExpiry
+	}
+
+	header := []byte{byte(alg), flags}
+	nonce := make([]byte, c.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	envelope := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+16)
+	envelope = append(envelope, header...)
+	envelope = append(envelope, nonce...)
+	return append(envelope, c.Seal(nonce, plaintext, header)...), nil
+}
+
+// OpenOption configures OpenEnvelope
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	maxPlaintextSize int
+}
+
+// WithMaxPlaintextSize fails OpenEnvelope with ErrTooLarge once the plaintext
+// exceeds n bytes, instead of DefaultMaxPlaintextSize
+func WithMaxPlaintextSize(n int) OpenOption {
+	return func(o *openOptions) {
+		o.maxPlaintextSize = n
+	}
+}
+
+// OpenEnvelope decrypts an envelope produced by SealEnvelope, reading the
+// algorithm and nonce size from its header
+func OpenEnvelope(key, envelope []byte, opts ...OpenOption) ([]byte, error) {
+	o := openOptions{maxPlaintextSize: DefaultMaxPlaintextSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxPlaintextSize < 0 {
+		return nil, fmt.Errorf("max plaintext size must not be negative")
+	}
+
+	if len(envelope) < envelopeHeaderSize {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	header := envelope[:envelopeHeaderSize]
+	flags := header[1]
+	if flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("unknown envelope flags %#x", flags)
+	}
+
+	c, err := NewCipher(Algorithm(header[0]), key)
+	if err != nil {
+		return nil, err
+	}
+
+	body := envelope[envelopeHeaderSize:]
+	if len(body) < c.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := body[:c.NonceSize()], body[c.NonceSize():]
+	plaintext, err := c.Open(nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	if flags&flagExpiry != 0 {
+		if len(plaintext) < expirySize {
+			return nil, fmt.Errorf("envelope expiry missing")
+		}
+		expiry := time.UnixMilli(int64(binary.BigEndian.Uint64(plaintext)))
+		if !time.Now().Before(expiry) {
+			return nil, ErrExpired
+		}
+		plaintext = plaintext[expirySize:]
+	}
+	if flags&flagCompressed != 0 {
+		if plaintext, err = inflate(plaintext, o.maxPlaintextSize); err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+	}
+	if len(plaintext) > o.maxPlaintextSize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, o.maxPlaintextSize)
+	}
+	return plaintext, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate decompresses data, reading at most one byte past limit so an
+// oversized plaintext fails with ErrTooLarge without being inflated in full
+func inflate(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: decompressed size exceeds %d bytes", ErrTooLarge, limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	plaintext := []byte("approve GET /admin")
+	for _, tc := range []struct {
+		alg       Algorithm
+		nonceSize int
+	}{
+		{AlgAES256GCM, 12},
+		{AlgXChaCha20Poly1305, 24},
+	} {
+		envelope, err := SealEnvelope(tc.alg, key, plaintext)
+		if err != nil {
+			t.Fatalf("alg %d: seal: %v", tc.alg, err)
+		}
+		if Algorithm(envelope[0]) != tc.alg {
+			t.Errorf("alg %d: header algorithm = %d", tc.alg, envelope[0])
+		}
+		// header, nonce, then the plaintext and a 16-byte tag
+		if got := len(envelope) - envelopeHeaderSize - len(plaintext) - 16; got != tc.nonceSize {
+			t.Errorf("alg %d: stored nonce is %d bytes, want %d", tc.alg, got, tc.nonceSize)
+		}
+		opened, err := OpenEnvelope(key, envelope)
+		if err != nil {
+			t.Fatalf("alg %d: open: %v", tc.alg, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("alg %d: opened %q, want %q", tc.alg, opened, plaintext)
+		}
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	envelope, err := SealEnvelope(AlgXChaCha20Poly1305, key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Switching the algorithm byte must not yield a different decryption
+	swapped := bytes.Clone(envelope)
+	swapped[0] = byte(AlgAES256GCM)
+	if _, err := OpenEnvelope(key, swapped); err == nil {
+		t.Error("opened an envelope with an altered algorithm")
+	}
+	flipped := bytes.Clone(envelope)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := OpenEnvelope(key, flipped); err == nil {
+		t.Error("opened an envelope with an altered ciphertext")
+	}
+	if _, err := OpenEnvelope(bytes.Repeat([]byte{8}, KeySize), envelope); err == nil {
+		t.Error("opened an envelope with the wrong key")
+	}
+}
+
+func TestEnvelopeUnsupportedAlgorithm(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	if _, err := SealEnvelope(Algorithm(99), key, []byte("x")); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("err = %v, want ErrUnsupportedAlgorithm", err)
+	}
+}
+
+func TestEnvelopeExpiry(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	envelope, err := SealEnvelope(AlgAES256GCM, key, []byte("x"), WithExpiry(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenEnvelope(key, envelope); err != nil {
+		t.Errorf("open before expiry: %v", err)
+	}
+	envelope, err = SealEnvelope(AlgAES256GCM, key, []byte("x"), WithExpiry(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := OpenEnvelope(key, envelope); !errors.Is(err, ErrExpired) {
+		t.Errorf("err = %v, want ErrExpired", err)
+	}
+}
+
+func TestEnvelopeMaxPlaintextSize(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	plaintext := bytes.Repeat([]byte("a"), 4096)
+	for _, opts := range [][]SealOption{nil, {WithCompression()}} {
+		envelope, err := SealEnvelope(AlgAES256GCM, key, plaintext, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenEnvelope(key, envelope, WithMaxPlaintextSize(len(plaintext)-1)); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("compressed=%v: err = %v, want ErrTooLarge", opts != nil, err)
+		}
+		opened, err := OpenEnvelope(key, envelope, WithMaxPlaintextSize(len(plaintext)))
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("compressed=%v: open at the limit: %v", opts != nil, err)
+		}
+	}
+}
+
+func TestEnvelopeCompression(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	compressible := bytes.Repeat([]byte(`{"accept":"*/*"}`), 64)
+	incompressible := make([]byte, 256)
+	if _, err := rand.Read(incompressible); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name       string
+		plaintext  []byte
+		opts       []SealOption
+		compressed bool
+	}{
+		{"off", compressible, nil, false},
+		{"on", compressible, []SealOption{WithCompression()}, true},
+		{"incompressible", incompressible, []SealOption{WithCompression()}, false},
+	} {
+		envelope, err := SealEnvelope(AlgAES256GCM, key, tc.plaintext, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: seal: %v", tc.name, err)
+		}
+		if got := envelope[1]&flagCompressed != 0; got != tc.compressed {
+			t.Errorf("%s: compressed flag = %v, want %v", tc.name, got, tc.compressed)
+		}
+		opened, err := OpenEnvelope(key, envelope)
+		if err != nil || !bytes.Equal(opened, tc.plaintext) {
+			t.Errorf("%s: round trip failed: %v", tc.name, err)
+		}
+	}
+}
+
+func TestEnvelopeDecompressionBomb(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	// Zeros deflate about a thousandfold, so the envelope is far below the cap
+	bomb := make([]byte, 4*DefaultMaxPlaintextSize)
+	envelope, err := SealEnvelope(AlgAES256GCM, key, bomb, WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope) >= DefaultMaxPlaintextSize/100 {
+		t.Fatalf("envelope is %d bytes, want a small bomb", len(envelope))
+	}
+	if _, err := OpenEnvelope(key, envelope); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("default limit: err = %v, want ErrTooLarge", err)
+	}
+	if _, err := OpenEnvelope(key, envelope, WithMaxPlaintextSize(1<<10)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("1KiB limit: err = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// GrantSubkeyPurpose derives the key grants are sealed with, so a grant can't
+// be confused with any other ciphertext under the tenant key
+const GrantSubkeyPurpose = "grant"
+
+// grantPayload is the sealed content of a grant
+type grantPayload struct {
+	Scope string `json:"scope"`
+}
+
+// MintGrant returns a token pre-authorizing the actions in scope for validFor.
+// Only holders of key can mint or read it; the expiry is sealed with it.
+func MintGrant(key []byte, scope string, validFor time.Duration) (string, error) {
+	if validFor <= 0 {
+		return "", fmt.Errorf("grant validity must be positive")
+	}
+	grantKey, err := DeriveSubkey(key, GrantSubkeyPurpose, 32)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(grantPayload{Scope: scope})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal grant: %w", err)
+	}
+	envelope, err := SealEnvelope(AlgAES256GCM, grantKey, plaintext, WithExpiry(validFor))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(envelope), nil
+}
+
+// VerifyGrant checks a token minted with key and returns its scope. It returns
+// ErrExpired once the grant has expired.
+func VerifyGrant(key []byte, token string) (string, error) {
+	envelope, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode grant: %w", err)
+	}
+	grantKey, err := DeriveSubkey(key, GrantSubkeyPurpose, 32)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := OpenEnvelope(grantKey, envelope)
+	if err != nil {
+		return "", err
+	}
+	var grant grantPayload
+	if err := json.Unmarshal(plaintext, &grant); err != nil {
+		return "", fmt.Errorf("failed to parse grant: %w", err)
+	}
+	return grant.Scope, nil
+}
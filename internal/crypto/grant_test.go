@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGrantRoundTrip(t *testing.T) {
+	key := sequence(32)
+	token, err := MintGrant(key, "GET /reports/*", time.Minute)
+	if err != nil {
+		t.Fatalf("MintGrant: %v", err)
+	}
+	scope, err := VerifyGrant(key, token)
+	if err != nil || scope != "GET /reports/*" {
+		t.Errorf("VerifyGrant = %q, %v, want the minted scope", scope, err)
+	}
+
+	other := make([]byte, 32)
+	if _, err := VerifyGrant(other, token); err == nil {
+		t.Error("grant verified under another tenant's key")
+	}
+	if _, err := VerifyGrant(key, token[:len(token)-2]+"AA"); err == nil {
+		t.Error("tampered grant verified")
+	}
+	if _, err := VerifyGrant(key, "not base64!"); err == nil {
+		t.Error("malformed grant verified")
+	}
+}
+
+func TestGrantExpires(t *testing.T) {
+	key := sequence(32)
+	token, err := MintGrant(key, "GET /reports/*", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := VerifyGrant(key, token); !errors.Is(err, ErrExpired) {
+		t.Errorf("err = %v, want ErrExpired", err)
+	}
+	if _, err := MintGrant(key, "GET /", 0); err == nil {
+		t.Error("MintGrant accepted a zero validity")
+	}
+}
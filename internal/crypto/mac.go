@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// MACSize is the length of an HMAC-SHA256 tag
+const MACSize = sha256.Size
+
+// maxHeaderSize bounds the routing header so its length fits the 2-byte prefix
+const maxHeaderSize = 1<<16 - 1
+
+// SignHMAC returns HMAC-SHA256(key, data)
+func SignHMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// VerifyHMAC reports whether mac is the HMAC-SHA256 of data under key, in constant time
+func VerifyHMAC(key, data, mac []byte) bool {
+	return hmac.Equal(SignHMAC(key, data), mac)
+}
+
+// PackSigned builds headerLen(2) | header | HMAC | ciphertext, so a relay can
+// read the unencrypted routing header while only the endpoints, who hold the
+// key, can verify it or decrypt the body. The HMAC covers headerLen, header and
+// ciphertext, so a header can't be moved onto another frame's body. Use a MAC
+// subkey from DeriveSubkey rather than the encryption key.
+func PackSigned(key, header, ciphertext []byte) ([]byte, error) {
+	if len(header) > maxHeaderSize {
+		return nil, fmt.Errorf("header too large: %d bytes", len(header))
+	}
+	frame := make([]byte, 2, 2+len(header)+MACSize+len(ciphertext))
+	binary.BigEndian.PutUint16(frame, uint16(len(header)))
+	frame = append(frame, header...)
+	frame = append(frame, signedMAC(key, frame, ciphertext)...)
+	return append(frame, ciphertext...), nil
+}
+
+// signedMAC returns the HMAC of a PackSigned frame: HMAC-SHA256(key,
+// headerLen | header | ciphertext), given prefix = headerLen | header
+func signedMAC(key, prefix, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prefix)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+// ParseSigned splits a frame built by PackSigned without verifying it. Relays,
+// which don't hold the key, use it to read the header.
+func ParseSigned(frame []byte) (header, mac, ciphertext []byte, err error) {
+	if len(frame) < 2 {
+		return nil, nil, nil, fmt.Errorf("frame too short")
+	}
+	headerLen := int(binary.BigEndian.Uint16(frame))
+	if len(frame) < 2+headerLen+MACSize {
+		return nil, nil, nil, fmt.Errorf("frame too short")
+	}
+	header = frame[2 : 2+headerLen]
+	mac = frame[2+headerLen : 2+headerLen+MACSize]
+	return header, mac, frame[2+headerLen+MACSize:], nil
+}
+
+// UnpackSigned splits a frame built by PackSigned and verifies its header and
+// ciphertext together
+func UnpackSigned(key, frame []byte) (header, ciphertext []byte, err error) {
+	header, mac, ciphertext, err := ParseSigned(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal(signedMAC(key, frame[:2+len(header)], ciphertext), mac) {
+		return nil, nil, fmt.Errorf("frame authentication failed")
+	}
+	return header, ciphertext, nil
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSignHMAC(t *testing.T) {
+	// RFC 4231 test case 2
+	want, _ := hex.DecodeString("5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843")
+	mac := SignHMAC([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	if !bytes.Equal(mac, want) {
+		t.Fatalf("SignHMAC = %x, want %x", mac, want)
+	}
+	if !VerifyHMAC([]byte("Jefe"), []byte("what do ya want for nothing?"), mac) {
+		t.Error("valid MAC rejected")
+	}
+	if VerifyHMAC([]byte("Jefe"), []byte("what do ya want for nothing!"), mac) {
+		t.Error("MAC accepted for other data")
+	}
+}
+
+func TestPackSigned(t *testing.T) {
+	key := []byte("mac subkey")
+	header := []byte(`{"type":"data","requestId":"req-1"}`)
+	ciphertext := []byte("opaque ciphertext")
+
+	frame, err := PackSigned(key, header, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The relay reads the header without the key
+	parsedHeader, _, parsedBody, err := ParseSigned(frame)
+	if err != nil || !bytes.Equal(parsedHeader, header) || !bytes.Equal(parsedBody, ciphertext) {
+		t.Fatalf("ParseSigned = %q, %q, %v", parsedHeader, parsedBody, err)
+	}
+	gotHeader, gotBody, err := UnpackSigned(key, frame)
+	if err != nil || !bytes.Equal(gotHeader, header) || !bytes.Equal(gotBody, ciphertext) {
+		t.Fatalf("UnpackSigned = %q, %q, %v", gotHeader, gotBody, err)
+	}
+
+	tamperedHeader := bytes.Clone(frame)
+	tamperedHeader[2+len(`{"type":"data","requestId":"req-`)] = '2'
+	tamperedMAC := bytes.Clone(frame)
+	tamperedMAC[2+len(header)] ^= 1
+	tamperedBody := bytes.Clone(frame)
+	tamperedBody[len(frame)-1] ^= 1
+	// A validly signed header moved onto another frame's body
+	other, err := PackSigned(key, header, []byte("other ciphertext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spliced := append(bytes.Clone(frame[:2+len(header)+MACSize]), other[2+len(header)+MACSize:]...)
+	for name, bad := range map[string][]byte{
+		"tampered header":     tamperedHeader,
+		"tampered MAC":        tamperedMAC,
+		"tampered ciphertext": tamperedBody,
+		"spliced ciphertext":  spliced,
+		"truncated body":      frame[:len(frame)-1],
+		"truncated":           frame[:2+len(header)+MACSize-1],
+		"empty":               nil,
+	} {
+		if _, _, err := UnpackSigned(key, bad); err == nil {
+			t.Errorf("%s frame accepted", name)
+		}
+	}
+	if _, _, err := UnpackSigned([]byte("other key"), frame); err == nil {
+		t.Error("frame accepted under another key")
+	}
+
+	if _, err := PackSigned(key, make([]byte, maxHeaderSize+1), ciphertext); err == nil {
+		t.Error("oversized header accepted")
+	}
+}
@@ -0,0 +1,54 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Component returns a logger that tags every record with a "component"
+// attribute. It resolves the default handler each time it logs, so loggers
+// created in package variables still pick up the configuration applied later
+// by SetupLogging.
+func Component(name string) *slog.Logger {
+	return slog.New(&defaultHandler{}).With("component", name)
+}
+
+// defaultHandler forwards to slog.Default's handler, replaying any attributes
+// and groups added via WithAttrs/WithGroup
+type defaultHandler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *defaultHandler) resolve() slog.Handler {
+	handler := slog.Default().Handler()
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+func (h *defaultHandler) with(wrap func(slog.Handler) slog.Handler) *defaultHandler {
+	wraps := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wraps, h.wrap)
+	return &defaultHandler{wrap: append(wraps, wrap)}
+}
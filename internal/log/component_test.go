@@ -0,0 +1,35 @@
+package log
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestComponentLogger(t *testing.T) {
+	// Created before logging is configured, as a package variable would be
+	logger := Component("relay")
+	buf := setupBuffer(t, map[string]string{"LOG_FORMAT": "json", "LOG_LEVEL": "info"})
+
+	logger.Debug("filtered by level")
+	logger.With("tenantID", "abc").Info("tenant connected")
+	logger.WithGroup("peer").Info("peer connected", "role", "server")
+
+	got := records(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want 2: %v", len(got), got)
+	}
+	if got[0]["component"] != "relay" || got[0]["tenantID"] != "abc" {
+		t.Errorf("record = %v, want component and tenantID", got[0])
+	}
+	peer, _ := got[1]["peer"].(map[string]any)
+	if got[1]["component"] != "relay" || peer["role"] != "server" {
+		t.Errorf("record = %v, want component and a peer group", got[1])
+	}
+
+	// Other loggers aren't tagged
+	buf.Reset()
+	slog.Info("untagged")
+	if component, ok := records(t, buf)[0]["component"]; ok {
+		t.Errorf("default logger tagged with component %v", component)
+	}
+}
@@ -1,25 +1,98 @@
 package log
 
 import (
+	"io"
 	"log/slog"
 	"os"
+	"strings"
 )
 
-func SetupLogging() {
+// level is shared by every handler SetupLoggingTo installs so it can be changed at runtime
+var level slog.LevelVar
+
+// SetLevel changes the minimum level of the default logger without restarting
+func SetLevel(l slog.Level) {
+	level.Set(l)
+	slog.Info("log level changed", "level", l.String())
+}
+
+// Level returns the current minimum log level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetupLogging configures the default logger to write to stderr, to syslog at
+// LOG_SYSLOG (e.g. "udp://log-host:514") or to the file named by LOG_FILE, and
+// to any extra writers, such as a RingBuffer
+func SetupLogging(extra ...io.Writer) {
+	w, warn := destination()
+	SetupLoggingMulti(append([]io.Writer{w}, extra...)...)
+	if warn != nil {
+		warn()
+	}
+}
+
+// destination returns the writer selected by the environment. If it falls back
+// to stderr, warn logs why once logging is set up.
+func destination() (w io.Writer, warn func()) {
+	if target := os.Getenv("LOG_SYSLOG"); target != "" {
+		network, addr, ok := strings.Cut(target, "://")
+		if !ok || addr == "" {
+			return os.Stderr, func() {
+				slog.Warn("invalid LOG_SYSLOG, logging to stderr", "syslog", target)
+			}
+		}
+		w, err := dialSyslog(network, addr)
+		if err != nil {
+			return os.Stderr, func() {
+				slog.Warn("failed to connect to syslog, logging to stderr", "syslog", target, "error", err)
+			}
+		}
+		return w, nil
+	}
+
+	logFile := os.Getenv("LOG_FILE")
+	if logFile == "" {
+		return os.Stderr, nil
+	}
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return os.Stderr, func() {
+			slog.Warn("failed to open log file, logging to stderr", "file", logFile, "error", err)
+		}
+	}
+	return f, nil
+}
+
+// SetupLoggingTo configures the default logger to write to w
+func SetupLoggingTo(w io.Writer) {
 	// set log level from environment variable
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel == "" {
 		logLevel = "info"
 	}
 
-	var level slog.Level
-	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
-		level = slog.LevelInfo
+	var initial slog.Level
+	if err := initial.UnmarshalText([]byte(logLevel)); err != nil {
+		initial = slog.LevelInfo
 		logLevel = "info"
 	}
+	level.Set(initial)
+
+	opts := &slog.HandlerOptions{
+		Level: &level,
+		// include file:line in records when requested
+		AddSource: os.Getenv("LOG_SOURCE") == "true",
+	}
+
+	// set log format from environment variable, text unless json is requested
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
 
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: level,
-	})))
+	slog.SetDefault(slog.New(NewTraceHandler(handler)))
 	slog.Info("setting log level", "level", logLevel)
 }
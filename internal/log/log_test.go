@@ -0,0 +1,164 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logEnv sets the logging environment to env, restoring it along with the
+// default logger and level when the test ends
+func logEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range []string{"LOG_LEVEL", "LOG_FORMAT", "LOG_SOURCE", "LOG_FILE", "LOG_SYSLOG"} {
+		t.Setenv(key, env[key])
+	}
+	previous, previousLevel := slog.Default(), Level()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		level.Set(previousLevel)
+	})
+}
+
+// setupBuffer configures logging into a buffer under the environment in env,
+// restoring the previous default logger and level when the test ends
+func setupBuffer(t *testing.T, env map[string]string) *bytes.Buffer {
+	t.Helper()
+	logEnv(t, env)
+
+	var buf bytes.Buffer
+	SetupLoggingTo(&buf)
+	buf.Reset()
+	return &buf
+}
+
+// records parses each line of buf as a JSON log record
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := setupBuffer(t, map[string]string{"LOG_FORMAT": "json", "LOG_LEVEL": "warn"})
+	slog.Info("filtered by level")
+	slog.Warn("relay started", "tenantID", "abc", "clients", 2)
+
+	got := records(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d records, want only the warning: %v", len(got), got)
+	}
+	record := got[0]
+	if record["msg"] != "relay started" || record["level"] != "WARN" || record["tenantID"] != "abc" || record["clients"] != 2.0 {
+		t.Errorf("record = %v", record)
+	}
+	if _, ok := record["time"]; !ok {
+		t.Error("record has no time")
+	}
+}
+
+func TestTextFormatByDefault(t *testing.T) {
+	buf := setupBuffer(t, nil)
+	slog.Debug("filtered by level")
+	slog.Info("relay started", "tenantID", "abc")
+
+	if out := buf.String(); !strings.Contains(out, `level=INFO msg="relay started" tenantID=abc`) || strings.Contains(out, "filtered") {
+		t.Errorf("text output = %q", out)
+	}
+}
+
+func TestSetupLoggingTo(t *testing.T) {
+	buf := setupBuffer(t, nil)
+	slog.Info("into the buffer")
+	if !strings.Contains(buf.String(), "into the buffer") {
+		t.Errorf("buffer = %q, want the log line", buf.String())
+	}
+}
+
+func TestLogFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.log")
+	if err := os.WriteFile(path, []byte("earlier line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logEnv(t, map[string]string{"LOG_FILE": path})
+	SetupLogging()
+	slog.Info("into the file")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "earlier line\n") || !strings.Contains(string(content), "into the file") {
+		t.Errorf("log file = %q, want the new line appended", content)
+	}
+}
+
+func TestLogFileFallsBackToStderr(t *testing.T) {
+	setupBuffer(t, map[string]string{"LOG_FILE": filepath.Join(t.TempDir(), "missing", "relay.log")})
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	previous := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = previous }()
+
+	SetupLogging()
+	content, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "failed to open log file") {
+		t.Errorf("stderr = %q, want a warning about the log file", content)
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	buf := setupBuffer(t, map[string]string{"LOG_FORMAT": "json", "LOG_SOURCE": "true"})
+	slog.Info("with source")
+	source, ok := records(t, buf)[0]["source"].(map[string]any)
+	if !ok || !strings.HasSuffix(source["file"].(string), "log_test.go") || source["line"] == nil {
+		t.Errorf("source = %v, want this file and line", source)
+	}
+
+	buf = setupBuffer(t, map[string]string{"LOG_FORMAT": "json"})
+	slog.Info("without source")
+	if source, ok := records(t, buf)[0]["source"]; ok {
+		t.Errorf("source = %v with LOG_SOURCE unset", source)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := setupBuffer(t, map[string]string{"LOG_LEVEL": "info"})
+	slog.Debug("before")
+
+	SetLevel(slog.LevelDebug)
+	if Level() != slog.LevelDebug {
+		t.Errorf("Level() = %v, want DEBUG", Level())
+	}
+	slog.Debug("after")
+
+	// Component loggers share the level
+	SetLevel(slog.LevelWarn)
+	Component("relay").Info("filtered")
+
+	out := buf.String()
+	if strings.Contains(out, "before") || !strings.Contains(out, "msg=after") || strings.Contains(out, "filtered") {
+		t.Errorf("output = %q, want only the record logged at DEBUG", out)
+	}
+}
@@ -0,0 +1,72 @@
+package log
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// SetupLoggingMulti configures the default logger to write every record to all
+// of writers
+func SetupLoggingMulti(writers ...io.Writer) {
+	SetupLoggingTo(fanout(writers))
+}
+
+// fanout writes to every writer even if some fail, unlike io.MultiWriter, so
+// one broken destination doesn't silence the others. It returns the first error.
+type fanout []io.Writer
+
+func (f fanout) Write(p []byte) (int, error) {
+	var firstErr error
+	for _, w := range f {
+		if _, err := w.Write(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(p), nil
+}
+
+// RingBuffer is an io.Writer that keeps the last lines written to it, for
+// dumping recent logs on demand
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewRingBuffer creates a RingBuffer holding the last size lines
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{lines: make([]string, max(size, 1))}
+}
+
+// Write stores each line of p; a trailing partial line is stored as a line
+func (b *RingBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % len(b.lines)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Snapshot returns the retained lines, oldest first
+func (b *RingBuffer) Snapshot() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	snapshot := make([]string, 0, len(b.lines))
+	snapshot = append(snapshot, b.lines[b.next:]...)
+	return append(snapshot, b.lines[:b.next]...)
+}
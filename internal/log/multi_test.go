@@ -0,0 +1,57 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// failingWriter rejects every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("destination down")
+}
+
+func TestSetupLoggingMulti(t *testing.T) {
+	logEnv(t, nil)
+	var first, second bytes.Buffer
+	ring := NewRingBuffer(10)
+	SetupLoggingMulti(&first, failingWriter{}, &second, ring)
+	slog.Info("fanned out")
+
+	for name, got := range map[string]string{"first": first.String(), "second": second.String(), "ring": strings.Join(ring.Snapshot(), "\n")} {
+		if !strings.Contains(got, "fanned out") {
+			t.Errorf("%s writer = %q, want the record despite a failing writer before it", name, got)
+		}
+	}
+}
+
+func TestRingBufferKeepsLastLines(t *testing.T) {
+	ring := NewRingBuffer(3)
+	if got := ring.Snapshot(); len(got) != 0 {
+		t.Errorf("empty Snapshot = %q", got)
+	}
+	ring.Write([]byte("one\n"))
+	ring.Write([]byte("two\n"))
+	if got, want := ring.Snapshot(), []string{"one", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot = %q, want %q", got, want)
+	}
+
+	ring.Write([]byte("three\nfour\n"))
+	ring.Write([]byte("five"))
+	if got, want := ring.Snapshot(), []string{"three", "four", "five"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestRingBufferMinimumSize(t *testing.T) {
+	ring := NewRingBuffer(0)
+	ring.Write([]byte("one\ntwo\n"))
+	if got, want := ring.Snapshot(), []string{"two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot = %q, want %q", got, want)
+	}
+}
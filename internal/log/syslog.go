@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+)
+
+// dialSyslog connects to the syslog daemon at addr over network ("udp", "tcp"
+// or "unix"). Every record is sent at info priority; its level is in the text.
+func dialSyslog(network, addr string) (io.Writer, error) {
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, filepath.Base(os.Args[0]))
+}
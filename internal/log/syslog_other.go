@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"fmt"
+	"io"
+)
+
+// dialSyslog is unavailable on this platform
+func dialSyslog(network, addr string) (io.Writer, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogOverUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	logEnv(t, map[string]string{"LOG_SYSLOG": "udp://" + listener.LocalAddr().String(), "LOG_FORMAT": "json", "LOG_LEVEL": "warn"})
+	SetupLogging()
+	slog.Info("filtered by level")
+	slog.Warn("relay started", "tenantID", "abc")
+
+	buf := make([]byte, 4096)
+	listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no syslog message received: %v", err)
+	}
+	message := string(buf[:n])
+	// <30> is LOG_DAEMON|LOG_INFO
+	if !strings.HasPrefix(message, "<30>") || !strings.Contains(message, `"msg":"relay started"`) || !strings.Contains(message, `"tenantID":"abc"`) {
+		t.Errorf("syslog message = %q, want the JSON warning at daemon.info", message)
+	}
+}
+
+func TestSyslogFallsBackToStderr(t *testing.T) {
+	for _, target := range []string{"log-host:514", "bogus://127.0.0.1:514"} {
+		ring := NewRingBuffer(10)
+		logEnv(t, map[string]string{"LOG_SYSLOG": target})
+		SetupLogging(ring)
+		if !strings.Contains(strings.Join(ring.Snapshot(), "\n"), "logging to stderr") {
+			t.Errorf("LOG_SYSLOG=%s: no fallback warning in %q", target, ring.Snapshot())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// TraceIDAttr is the attribute key trace IDs are logged under
+const TraceIDAttr = "traceID"
+
+// traceIDKey is the context key for the trace ID
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying traceID; records logged with it through
+// slog's ...Context methods get a traceID attribute
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID carried by ctx, or "" if there is none
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// traceHandler adds the context's trace ID to every record it handles
+type traceHandler struct {
+	slog.Handler
+}
+
+// NewTraceHandler wraps handler so records logged with a context from
+// WithTraceID carry its trace ID. SetupLogging installs it.
+func NewTraceHandler(handler slog.Handler) slog.Handler {
+	return &traceHandler{Handler: handler}
+}
+
+func (h *traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if traceID := TraceID(ctx); traceID != "" {
+		r.AddAttrs(slog.String(TraceIDAttr, traceID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &traceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *traceHandler) WithGroup(name string) slog.Handler {
+	return &traceHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,38 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestTraceIDLogged(t *testing.T) {
+	buf := setupBuffer(t, map[string]string{"LOG_FORMAT": "json"})
+	ctx := WithTraceID(context.Background(), "trace-123")
+	if got := TraceID(ctx); got != "trace-123" {
+		t.Fatalf("TraceID = %q, want trace-123", got)
+	}
+
+	slog.InfoContext(ctx, "with trace")
+	slog.Default().With("tenantID", "abc").InfoContext(ctx, "with attrs")
+	slog.InfoContext(context.Background(), "without trace")
+	slog.Info("no context")
+
+	got := records(t, buf)
+	if len(got) != 4 {
+		t.Fatalf("got %d records, want 4", len(got))
+	}
+	for _, record := range got[:2] {
+		if record[TraceIDAttr] != "trace-123" {
+			t.Errorf("%q %s = %v, want trace-123", record["msg"], TraceIDAttr, record[TraceIDAttr])
+		}
+	}
+	if got[1]["tenantID"] != "abc" {
+		t.Errorf("With attributes lost: %v", got[1])
+	}
+	for _, record := range got[2:] {
+		if _, ok := record[TraceIDAttr]; ok {
+			t.Errorf("%q has a %s without one in its context", record["msg"], TraceIDAttr)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fcmScope is the OAuth scope needed to send messages with FCM
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmEndpoint is the FCM HTTP v1 send endpoint for a project
+const fcmEndpoint = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+
+// FCM sends push notifications through Firebase Cloud Messaging to every
+// device registered for a tenant. The notification opens the tenant's approval
+// page; the encryption key is never sent, so the page uses the key it
+// remembered when the device was paired. Nor is the request summary: FCM and
+// the browser's push service would see it in plaintext, so the notification
+// only says an approval is needed and the page decrypts the request itself.
+type FCM struct {
+	ProjectID string
+	// BaseURL is where the relay serves the approval page
+	BaseURL    string
+	Tokens     *TokenStore
+	HTTPClient *http.Client
+	account    *serviceAccount
+}
+
+// serviceAccount is the part of a Google service account key file used to
+// obtain access tokens
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// NewFCM creates an FCM notifier authenticating with the service account key
+// file at credentialsFile. An empty projectID uses the service account's project.
+func NewFCM(credentialsFile, projectID, baseURL string, tokens *TokenStore) (*FCM, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	if account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, errors.New("FCM credentials must be a service account key with client_email and token_uri")
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("FCM credentials have no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("FCM private key is not an RSA key")
+	}
+	account.key = key
+
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	if projectID == "" {
+		return nil, errors.New("FCM project ID is not set and not in the credentials")
+	}
+
+	return &FCM{
+		ProjectID:  projectID,
+		BaseURL:    baseURL,
+		Tokens:     tokens,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		account:    &account,
+	}, nil
+}
+
+// fcmMessage is the body of an FCM HTTP v1 send request
+type fcmMessage struct {
+	Message struct {
+		Token        string            `json:"token"`
+		Notification fcmNotification   `json:"notification"`
+		Data         map[string]string `json:"data"`
+		Webpush      struct {
+			FCMOptions struct {
+				Link string `json:"link"`
+			} `json:"fcm_options"`
+		} `json:"webpush"`
+	} `json:"message"`
+}
+
+// fcmBody is the text of every notification, which must not reveal the request
+const fcmBody = "A request is waiting for your decision"
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// errUnregistered is returned when FCM no longer knows a device token
+var errUnregistered = errors.New("device token is no longer registered")
+
+// Notify sends a notification to each of the tenant's devices, dropping tokens
+// FCM reports as unregistered. It fails only if no device could be notified.
+// summary is not sent.
+func (f *FCM) Notify(tenantID, summary, requestID string) error {
+	tokens := f.Tokens.Tokens(tenantID)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	link, err := url.JoinPath(f.BaseURL, "s", tenantID)
+	if err != nil {
+		return fmt.Errorf("invalid approval page URL: %w", err)
+	}
+	accessToken, err := f.account.token(f.HTTPClient)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, token := range tokens {
+		var msg fcmMessage
+		msg.Message.Token = token
+		msg.Message.Notification = fcmNotification{Title: "Approval needed", Body: fcmBody}
+		msg.Message.Data = map[string]string{
+			"requestId": requestID,
+			"tenantId":  tenantID,
+			"url":       link,
+		}
+		msg.Message.Webpush.FCMOptions.Link = link
+
+		err := f.send(accessToken, msg)
+		if errors.Is(err, errUnregistered) {
+			slog.Info("Dropping unregistered device token", "tenantID", tenantID)
+			f.Tokens.Unregister(tenantID, token)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(tokens) {
+		return fmt.Errorf("failed to notify any device: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (f *FCM) send(accessToken string, msg fcmMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal FCM message: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(fcmEndpoint, url.PathEscape(f.ProjectID)), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errUnregistered
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("unexpected FCM status %s", resp.Status)
+	}
+	return nil
+}
+
+// token returns an OAuth access token for the service account, exchanging a
+// signed JWT for a new one shortly before the current one expires
+func (a *serviceAccount) token(client *http.Client) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.accessToken != "" && now.Before(a.expiry.Add(-time.Minute)) {
+		return a.accessToken, nil
+	}
+
+	assertion, err := a.signJWT(now)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.PostForm(a.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get FCM access token: unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.AccessToken == "" {
+		return "", errors.New("failed to get FCM access token: invalid token response")
+	}
+	a.accessToken = result.AccessToken
+	a.expiry = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return a.accessToken, nil
+}
+
+// signJWT returns the RS256-signed assertion exchanged for an access token
+func (a *serviceAccount) signJWT(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   a.ClientEmail,
+		"scope": fcmScope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	enc := base64.RawURLEncoding
+	signingInput := strings.Join([]string{enc.EncodeToString(header), enc.EncodeToString(claims)}, ".")
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign FCM token request: %w", err)
+	}
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc captures the requests an http.Client sends
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFCMOmitsSummary(t *testing.T) {
+	tokens := NewTokenStore()
+	tokens.Register("tenant-a", "phone")
+	var sent []string
+	f := &FCM{
+		ProjectID: "project",
+		BaseURL:   "https://relay.example.com",
+		Tokens:    tokens,
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			sent = append(sent, string(body))
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader("{}"))}, nil
+		})},
+		account: &serviceAccount{accessToken: "token", expiry: time.Now().Add(time.Hour)},
+	}
+
+	summary := "POST /admin/users/delete?id=42"
+	if err := f.Notify("tenant-a", summary, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if strings.Contains(sent[0], "/admin/users") {
+		t.Errorf("FCM message contains the summary: %s", sent[0])
+	}
+	for _, want := range []string{`"requestId":"req-1"`, `"link":"https://relay.example.com/s/tenant-a"`} {
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("FCM message %s lacks %s", sent[0], want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"slices"
+	"sync"
+)
+
+// Notifier alerts a tenant's approver that a request needs a decision while no
+// approval page is open, e.g. with a push notification to their phone
+type Notifier interface {
+	Notify(tenantID, summary, requestID string) error
+}
+
+// Nop is a Notifier that does nothing, used when notifications aren't configured
+type Nop struct{}
+
+// Notify does nothing
+func (Nop) Notify(tenantID, summary, requestID string) error {
+	return nil
+}
+
+// TokenStore holds the push device tokens registered for each tenant
+type TokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string][]string
+}
+
+// NewTokenStore creates an empty token store
+func NewTokenStore() *TokenStore {
+	return &TokenStore{tokens: make(map[string][]string)}
+}
+
+// Register adds a device token for tenantID; registering a token twice is a no-op
+func (s *TokenStore) Register(tenantID, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.Contains(s.tokens[tenantID], token) {
+		s.tokens[tenantID] = append(s.tokens[tenantID], token)
+	}
+}
+
+// Unregister removes a device token for tenantID, e.g. once the push service
+// reports the app was uninstalled
+func (s *TokenStore) Unregister(tenantID, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := slices.DeleteFunc(slices.Clone(s.tokens[tenantID]), func(t string) bool {
+		return t == token
+	})
+	if len(tokens) == 0 {
+		delete(s.tokens, tenantID)
+		return
+	}
+	s.tokens[tenantID] = tokens
+}
+
+// Tokens returns the device tokens registered for tenantID
+func (s *TokenStore) Tokens(tenantID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.tokens[tenantID])
+}
@@ -0,0 +1,29 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenStore(t *testing.T) {
+	s := NewTokenStore()
+	s.Register("tenant-a", "phone")
+	s.Register("tenant-a", "tablet")
+	s.Register("tenant-a", "phone")
+	s.Register("tenant-b", "laptop")
+
+	if got, want := s.Tokens("tenant-a"), []string{"phone", "tablet"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens = %q, want %q", got, want)
+	}
+	s.Unregister("tenant-a", "phone")
+	if got, want := s.Tokens("tenant-a"), []string{"tablet"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens after Unregister = %q, want %q", got, want)
+	}
+	s.Unregister("tenant-a", "tablet")
+	if got := s.Tokens("tenant-a"); len(got) != 0 {
+		t.Errorf("Tokens after removing all = %q, want none", got)
+	}
+	if got, want := s.Tokens("tenant-b"), []string{"laptop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other tenant's Tokens = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package qrcode
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is how many rendered QR codes are kept by default
+const DefaultCacheSize = 64
+
+// cacheKey identifies a rendering: the same URL drawn in another format, at
+// another size or error-correction level is a separate entry
+type cacheKey struct {
+	url    string
+	format string
+	level  ECLevel
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value any
+}
+
+// renderCache is an LRU of rendered QR codes shared by the Generate functions
+type renderCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[cacheKey]*list.Element
+}
+
+var cache = &renderCache{
+	capacity: DefaultCacheSize,
+	order:    list.New(),
+	entries:  make(map[cacheKey]*list.Element),
+}
+
+// SetCacheSize sets how many rendered QR codes are kept, evicting the least
+// recently used beyond that. Zero disables caching and empties the cache.
+func SetCacheSize(n int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.capacity = max(n, 0)
+	cache.evictLocked()
+}
+
+// evictLocked drops the least recently used entries beyond capacity; c.mu must be held
+func (c *renderCache) evictLocked() {
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cached returns the rendering for key, calling generate on a miss. Generation
+// runs without the lock, so concurrent misses for one key may both render; the
+// result is the same either way.
+func cached[T any](key cacheKey, generate func() (T, error)) (T, error) {
+	cache.mu.Lock()
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.MoveToFront(elem)
+		value := elem.Value.(*cacheEntry).value.(T)
+		cache.mu.Unlock()
+		return value, nil
+	}
+	cache.mu.Unlock()
+
+	value, err := generate()
+	if err != nil {
+		return value, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.capacity == 0 {
+		return value, nil
+	}
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.MoveToFront(elem)
+		return value, nil
+	}
+	cache.entries[key] = cache.order.PushFront(&cacheEntry{key: key, value: value})
+	cache.evictLocked()
+	return value, nil
+}
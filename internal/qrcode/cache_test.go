@@ -0,0 +1,123 @@
+package qrcode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// countingGenerate returns a generate function for cached that counts its calls
+func countingGenerate(calls *int, value string) func() (string, error) {
+	return func() (string, error) {
+		*calls++
+		return value, nil
+	}
+}
+
+// resetCache empties the cache with room for n entries, restoring the default
+// size when the test ends
+func resetCache(t *testing.T, n int) {
+	SetCacheSize(0)
+	SetCacheSize(n)
+	t.Cleanup(func() { SetCacheSize(DefaultCacheSize) })
+}
+
+func TestCacheServesRepeats(t *testing.T) {
+	resetCache(t, DefaultCacheSize)
+	calls := 0
+	key := cacheKey{testURL, "test", DefaultECLevel}
+	for range 3 {
+		if got, _ := cached(key, countingGenerate(&calls, "rendered")); got != "rendered" {
+			t.Fatalf("cached = %q, want rendered", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("generated %d times, want once", calls)
+	}
+
+	// Another format or level is a separate rendering
+	cached(cacheKey{testURL, "other", DefaultECLevel}, countingGenerate(&calls, "other"))
+	cached(cacheKey{testURL, "test", High}, countingGenerate(&calls, "high"))
+	if calls != 3 {
+		t.Errorf("generated %d times, want once per format and level", calls)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	resetCache(t, 2)
+	calls := 0
+	a, b, c := cacheKey{"a", "test", DefaultECLevel}, cacheKey{"b", "test", DefaultECLevel}, cacheKey{"c", "test", DefaultECLevel}
+	cached(a, countingGenerate(&calls, "a"))
+	cached(b, countingGenerate(&calls, "b"))
+	cached(a, countingGenerate(&calls, "a"))
+	cached(c, countingGenerate(&calls, "c")) // evicts b
+	if calls != 3 {
+		t.Fatalf("generated %d times, want 3", calls)
+	}
+	cached(a, countingGenerate(&calls, "a"))
+	if calls != 3 {
+		t.Error("recently used entry was evicted")
+	}
+	cached(b, countingGenerate(&calls, "b"))
+	if calls != 4 {
+		t.Error("least recently used entry was kept")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	resetCache(t, 0)
+	calls := 0
+	key := cacheKey{testURL, "test", DefaultECLevel}
+	cached(key, countingGenerate(&calls, "rendered"))
+	cached(key, countingGenerate(&calls, "rendered"))
+	if calls != 2 {
+		t.Errorf("generated %d times with caching disabled, want 2", calls)
+	}
+}
+
+func TestCacheSkipsErrors(t *testing.T) {
+	resetCache(t, DefaultCacheSize)
+	calls := 0
+	failing := func() (string, error) {
+		calls++
+		return "", errors.New("render failed")
+	}
+	key := cacheKey{testURL, "test", DefaultECLevel}
+	if _, err := cached(key, failing); err == nil {
+		t.Fatal("cached swallowed the error")
+	}
+	cached(key, failing)
+	if calls != 2 {
+		t.Errorf("generated %d times, want a failure not to be cached", calls)
+	}
+}
+
+func TestGenerateServedFromCache(t *testing.T) {
+	resetCache(t, DefaultCacheSize)
+	first, err := GenerateQR(testURL, DefaultECLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.entries) != 1 {
+		t.Fatalf("%d cache entries after GenerateQR, want 1", len(cache.entries))
+	}
+	second, _ := GenerateQR(testURL, DefaultECLevel)
+	if second != first {
+		t.Error("cached QR code differs")
+	}
+}
+
+func TestPairingQRNotCached(t *testing.T) {
+	resetCache(t, DefaultCacheSize)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GeneratePairingQR("https://relay.example.com", key); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("%d cache entries after GeneratePairingQR, want the key-bearing link left out", len(cache.entries))
+	}
+}
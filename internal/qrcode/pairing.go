@@ -0,0 +1,60 @@
+package qrcode
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// PairingURL returns the deep link that pairs a browser with the authz server
+// owning key: the relay's /s/{tenantID} page with the key in the fragment, so
+// it never reaches the relay
+func PairingURL(baseURL string, key []byte) (string, error) {
+	if len(key) != crypto.KeySize {
+		return "", fmt.Errorf("invalid key length: expected %d bytes, got %d", crypto.KeySize, len(key))
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	return fmt.Sprintf("%s/s/%s#key=%s", strings.TrimRight(baseURL, "/"), crypto.DeriveTenantID(key), crypto.EncodeKeyRaw(key)), nil
+}
+
+// ParsePairingURL extracts the tenant ID and key from a PairingURL link,
+// checking that the tenant ID belongs to the key
+func ParsePairingURL(link string) (string, []byte, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pairing URL: %w", err)
+	}
+	i := strings.LastIndex(u.Path, "/s/")
+	if i < 0 || u.Path[i+len("/s/"):] == "" {
+		return "", nil, fmt.Errorf("pairing URL has no tenant ID")
+	}
+	tenantID := u.Path[i+len("/s/"):]
+	fragment, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pairing URL fragment: %w", err)
+	}
+	key, err := crypto.DecodeKey(fragment.Get("key"))
+	if err != nil {
+		return "", nil, err
+	}
+	if crypto.DeriveTenantID(key) != tenantID {
+		return "", nil, fmt.Errorf("pairing URL tenant ID does not match its key")
+	}
+	return tenantID, key, nil
+}
+
+// GeneratePairingQR renders the PairingURL for key as a scannable terminal QR
+// code. The link carries the key, so it bypasses the render cache rather than
+// keeping the key in memory after a one-off render.
+func GeneratePairingQR(baseURL string, key []byte) (string, error) {
+	link, err := PairingURL(baseURL, key)
+	if err != nil {
+		return "", err
+	}
+	return generateQR(link, DefaultECLevel)
+}
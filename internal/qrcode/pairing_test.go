@@ -0,0 +1,64 @@
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+func TestPairingURLRoundTrip(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr, err := GeneratePairingQR("https://relay.example.com/", key)
+	if err != nil {
+		t.Fatalf("GeneratePairingQR: %v", err)
+	}
+	link := scanModules(t, halfBlockModules(qr))
+	if want := "https://relay.example.com/s/" + crypto.DeriveTenantID(key) + "#key="; !strings.HasPrefix(link, want) {
+		t.Errorf("scanned %q, want it to start with %q", link, want)
+	}
+
+	tenantID, got, err := ParsePairingURL(link)
+	if err != nil {
+		t.Fatalf("ParsePairingURL: %v", err)
+	}
+	if tenantID != crypto.DeriveTenantID(key) || !bytes.Equal(got, key) {
+		t.Errorf("ParsePairingURL = %s, %x, want %s, %x", tenantID, got, crypto.DeriveTenantID(key), key)
+	}
+}
+
+func TestPairingURLInvalid(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PairingURL("https://relay.example.com", key[:16]); err == nil {
+		t.Error("PairingURL accepted a 16-byte key")
+	}
+	if _, err := PairingURL("relay.example.com", key); err == nil {
+		t.Error("PairingURL accepted a base URL without a scheme")
+	}
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := PairingURL("https://relay.example.com", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := strings.Replace(link, crypto.DeriveTenantID(key), crypto.DeriveTenantID(other), 1)
+	for name, link := range map[string]string{
+		"other tenant": swapped,
+		"no tenant":    "https://relay.example.com/s/#key=" + crypto.EncodeKeyRaw(key),
+		"no key":       "https://relay.example.com/s/" + crypto.DeriveTenantID(key),
+	} {
+		if _, _, err := ParsePairingURL(link); err == nil {
+			t.Errorf("%s: ParsePairingURL succeeded", name)
+		}
+	}
+}
@@ -1,15 +1,45 @@
 package qrcode
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"os"
 	"strings"
 
 	"github.com/skip2/go-qrcode"
 )
 
-// GenerateASCII generates a simple ASCII QR-like display for terminal
-// For production, you'd use a real QR code library like github.com/skip2/go-qrcode
+// ECLevel is the QR error-correction level. Higher levels survive more damage
+// or poor lighting at the cost of a denser code
+type ECLevel int
+
+const (
+	Low ECLevel = iota
+	Medium
+	High
+	Highest
+)
+
+// DefaultECLevel balances density against robustness
+const DefaultECLevel = Medium
+
+func (l ECLevel) recoveryLevel() qrcode.RecoveryLevel {
+	switch l {
+	case Low:
+		return qrcode.Low
+	case High:
+		return qrcode.High
+	case Highest:
+		return qrcode.Highest
+	default:
+		return qrcode.Medium
+	}
+}
+
+// GenerateASCII draws a banner with the URL as plain text. It isn't scannable,
+// but works as a fallback where a QR code can't be rendered
 func GenerateASCII(url string) string {
 	border := strings.Repeat("█", len(url)+4)
 	return fmt.Sprintf(`
@@ -24,22 +54,202 @@ Open this URL on your phone to start approving/denying requests!
 `, border, url, border)
 }
 
-func GenerateQrCode(url string) string {
-	qr, err := qrcode.New(url, qrcode.Medium)
+// GenerateQR returns a scannable QR code for url rendered with block
+// characters for display in a terminal
+func GenerateQR(url string, level ECLevel) (string, error) {
+	return cached(cacheKey{url, "blocks", level}, func() (string, error) {
+		return generateQR(url, level)
+	})
+}
+
+func generateQR(url string, level ECLevel) (string, error) {
+	qr, err := qrcode.New(url, level.recoveryLevel())
 	if err != nil {
-		slog.Error("Error generating QR code", "error", err)
-		return ""
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
 	}
-	return qr.ToSmallString(false)
+	return qr.ToSmallString(false), nil
+}
+
+// GenerateCompact renders the QR code for url at half height by packing two
+// module rows into each line with Unicode half blocks. Light modules are drawn
+// so the code reads correctly on a dark terminal, and the standard quiet zone
+// is kept so scanners can lock on.
+func GenerateCompact(url string, level ECLevel) (string, error) {
+	return cached(cacheKey{url, "compact", level}, func() (string, error) {
+		return generateCompact(url, level)
+	})
 }
 
-// Note: For real QR codes, add this dependency:
-// go get github.com/skip2/go-qrcode
-// Then use:
-// qr, _ := qrcode.New(url, qrcode.Medium)
-// return qr.ToSmallString(false)
+func generateCompact(url string, level ECLevel) (string, error) {
+	qr, err := qrcode.New(url, level.recoveryLevel())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	bitmap := qr.Bitmap()
+
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := !bitmap[y][x]
+			// An odd final row is paired with a light quiet-zone row
+			bottom := y+1 >= len(bitmap) || !bitmap[y+1][x]
+
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// ANSI background colors and reset used by GenerateANSI
+const (
+	ansiLight = "\x1b[47m"
+	ansiDark  = "\x1b[40m"
+	ansiReset = "\x1b[0m"
+)
+
+// GenerateANSI renders the QR code for url as spaces on ANSI white and black
+// backgrounds, two columns per module so modules come out roughly square. Many
+// cameras read this more reliably than block characters, whatever the terminal
+// font. When stdout isn't a terminal, escapes would end up as garbage in a log
+// file, so it falls back to GenerateQR's plain blocks.
+func GenerateANSI(url string) (string, error) {
+	if !isTerminal(os.Stdout) {
+		return GenerateQR(url, DefaultECLevel)
+	}
+	return cached(cacheKey{url, "ansi", DefaultECLevel}, func() (string, error) {
+		return generateANSI(url)
+	})
+}
+
+func generateANSI(url string) (string, error) {
+	qr, err := qrcode.New(url, DefaultECLevel.recoveryLevel())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+
+	var b strings.Builder
+	for _, row := range qr.Bitmap() {
+		// Only emit an escape where the color changes
+		current := ""
+		for _, dark := range row {
+			color := ansiLight
+			if dark {
+				color = ansiDark
+			}
+			if color != current {
+				b.WriteString(color)
+				current = color
+			}
+			b.WriteString("  ")
+		}
+		b.WriteString(ansiReset + "\n")
+	}
+	return b.String(), nil
+}
 
-// Generate is an alias for GenerateASCII
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// GeneratePNG returns a size x size pixel PNG of the QR code for url, suitable
+// for writing to a file or embedding in a web page
+func GeneratePNG(url string, size int, level ECLevel) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid PNG size %d", size)
+	}
+
+	png, err := cached(cacheKey{url, fmt.Sprintf("png:%d", size), level}, func() ([]byte, error) {
+		png, err := qrcode.Encode(url, level.recoveryLevel(), size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate QR PNG: %w", err)
+		}
+		return png, nil
+	})
+	// Callers own the returned slice, the cache keeps its own copy
+	return bytes.Clone(png), err
+}
+
+// GenerateSVG returns an SVG document of the QR code for url, drawing each dark
+// module as a moduleSize square rect with quietZone light modules of margin.
+// The viewBox lets it scale to any size without blurring.
+func GenerateSVG(url string, moduleSize, quietZone int, level ECLevel) (string, error) {
+	if moduleSize <= 0 {
+		return "", fmt.Errorf("invalid module size %d", moduleSize)
+	}
+	if quietZone < 0 {
+		return "", fmt.Errorf("invalid quiet zone %d", quietZone)
+	}
+	return cached(cacheKey{url, fmt.Sprintf("svg:%d:%d", moduleSize, quietZone), level}, func() (string, error) {
+		return generateSVG(url, moduleSize, quietZone, level)
+	})
+}
+
+func generateSVG(url string, moduleSize, quietZone int, level ECLevel) (string, error) {
+	qr, err := qrcode.New(url, level.recoveryLevel())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	qr.DisableBorder = true
+	bitmap := qr.Bitmap()
+
+	dim := (len(bitmap) + 2*quietZone) * moduleSize
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`, dim, dim, dim, dim)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`, dim, dim)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#000000"/>`,
+					(x+quietZone)*moduleSize, (y+quietZone)*moduleSize, moduleSize, moduleSize)
+			}
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String(), nil
+}
+
+// Bounds on the pixel size of data URLs, so callers can't accidentally inline
+// megabytes of image into a page
+const (
+	MinDataURLSize = 64
+	MaxDataURLSize = 1024
+)
+
+// GenerateDataURL returns the PNG QR code for url as a "data:image/png;base64,..."
+// URL that can be used directly as an img src
+func GenerateDataURL(url string, size int, level ECLevel) (string, error) {
+	if size < MinDataURLSize || size > MaxDataURLSize {
+		return "", fmt.Errorf("data URL size %d out of range [%d, %d]", size, MinDataURLSize, MaxDataURLSize)
+	}
+
+	png, err := GeneratePNG(url, size, level)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// Generate returns the URL banner followed by a scannable QR code, or just the
+// banner if the QR code can't be generated. It prints the pairing link once at
+// startup, and that link carries the key, so the render isn't cached.
 func Generate(url string) string {
-	return GenerateASCII(url) + "\n" + GenerateQrCode(url)
+	qr, err := generateQR(url, DefaultECLevel)
+	if err != nil {
+		slog.Error("Error generating QR code", "error", err)
+		return GenerateASCII(url)
+	}
+	return GenerateASCII(url) + "\n" + qr
 }
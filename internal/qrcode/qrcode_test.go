@@ -0,0 +1,325 @@
+package qrcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/gozxing"
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/skip2/go-qrcode"
+)
+
+const testURL = "https://relay.example.com/s/0123456789abcdef01234567#key=c2VjcmV0IGtleSBmb3IgdGVzdGluZw"
+
+// scanImage decodes the QR code in img
+func scanImage(t *testing.T, img image.Image) string {
+	t.Helper()
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatalf("binarize: %v", err)
+	}
+	result, err := zxqrcode.NewQRCodeReader().Decode(bitmap, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return result.GetText()
+}
+
+// scanModules renders a matrix of modules, true for dark, and decodes it
+func scanModules(t *testing.T, modules [][]bool) string {
+	t.Helper()
+	const scale = 4
+	img := image.NewGray(image.Rect(0, 0, len(modules[0])*scale, len(modules)*scale))
+	for y, row := range modules {
+		for x, dark := range row {
+			c := color.Gray{Y: 0xff}
+			if dark {
+				c = color.Gray{}
+			}
+			for dy := range scale {
+				for dx := range scale {
+					img.SetGray(x*scale+dx, y*scale+dy, c)
+				}
+			}
+		}
+	}
+	return scanImage(t, img)
+}
+
+// halfBlockModules reads a QR code drawn two module rows per line, where a
+// drawn half is a light module as on a dark terminal
+func halfBlockModules(text string) [][]bool {
+	var modules [][]bool
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		var top, bottom []bool
+		for _, r := range line {
+			top = append(top, r != '█' && r != '▀')
+			bottom = append(bottom, r != '█' && r != '▄')
+		}
+		modules = append(modules, top, bottom)
+	}
+	// A square code of odd size leaves a padding row at the bottom
+	return modules[:len(modules[0])]
+}
+
+func TestGenerateQRScans(t *testing.T) {
+	qr, err := GenerateQR(testURL, DefaultECLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scanModules(t, halfBlockModules(qr)); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+}
+
+func TestGenerateFallsBackToBanner(t *testing.T) {
+	if banner := GenerateASCII(testURL); !strings.Contains(banner, testURL) {
+		t.Errorf("banner doesn't show the URL:\n%s", banner)
+	}
+	out := Generate(testURL)
+	if !strings.HasPrefix(out, GenerateASCII(testURL)) || !strings.ContainsRune(out, '▀') {
+		t.Errorf("Generate should print the banner then the QR code:\n%s", out)
+	}
+}
+
+// scanPNG decodes a PNG QR code, checking it is size pixels square
+func scanPNG(t *testing.T, data []byte, size int) string {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+		t.Errorf("PNG is %dx%d, want %dx%d", b.Dx(), b.Dy(), size, size)
+	}
+	return scanImage(t, img)
+}
+
+func TestGeneratePNGScans(t *testing.T) {
+	data, err := GeneratePNG(testURL, 256, DefaultECLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scanPNG(t, data, 256); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+
+	if _, err := GeneratePNG(testURL, 0, DefaultECLevel); err == nil {
+		t.Error("zero PNG size accepted")
+	}
+}
+
+var svgRect = regexp.MustCompile(`<rect x="(\d+)" y="(\d+)" width="(\d+)" height="(\d+)" fill="#000000"/>`)
+
+func TestGenerateSVGModules(t *testing.T) {
+	const moduleSize, quietZone = 3, 4
+	svg, err := GenerateSVG(testURL, moduleSize, quietZone, Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qr, err := qrcode.New(testURL, qrcode.Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr.DisableBorder = true
+	want := 0
+	for _, row := range qr.Bitmap() {
+		for _, dark := range row {
+			if dark {
+				want++
+			}
+		}
+	}
+
+	size := len(qr.Bitmap()) + 2*quietZone
+	dim := size * moduleSize
+	if viewBox := fmt.Sprintf(`viewBox="0 0 %d %d"`, dim, dim); !strings.Contains(svg, viewBox) {
+		t.Errorf("SVG lacks %s", viewBox)
+	}
+
+	rects := svgRect.FindAllStringSubmatch(svg, -1)
+	if len(rects) != want {
+		t.Fatalf("SVG has %d module rects, want %d", len(rects), want)
+	}
+	modules := make([][]bool, size)
+	for y := range modules {
+		modules[y] = make([]bool, size)
+	}
+	for _, rect := range rects {
+		x, _ := strconv.Atoi(rect[1])
+		y, _ := strconv.Atoi(rect[2])
+		if rect[3] != strconv.Itoa(moduleSize) || rect[4] != strconv.Itoa(moduleSize) {
+			t.Fatalf("rect %s isn't one module", rect[0])
+		}
+		modules[y/moduleSize][x/moduleSize] = true
+	}
+	if got := scanModules(t, modules); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+
+	if _, err := GenerateSVG(testURL, 0, quietZone, Low); err == nil {
+		t.Error("zero module size accepted")
+	}
+	if _, err := GenerateSVG(testURL, moduleSize, -1, Low); err == nil {
+		t.Error("negative quiet zone accepted")
+	}
+}
+
+func TestECLevels(t *testing.T) {
+	previous := 0
+	for _, level := range []ECLevel{Low, Medium, High, Highest} {
+		data, err := GeneratePNG(testURL, 512, level)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if got := scanPNG(t, data, 512); got != testURL {
+			t.Errorf("level %d: scanned %q, want %q", level, got, testURL)
+		}
+
+		qr, err := GenerateQR(testURL, level)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		modules := halfBlockModules(qr)
+		if got := scanModules(t, modules); got != testURL {
+			t.Errorf("level %d: terminal code scanned %q, want %q", level, got, testURL)
+		}
+		// More redundancy needs at least as many modules
+		if len(modules) < previous {
+			t.Errorf("level %d: %d modules, fewer than the level below", level, len(modules))
+		}
+		previous = len(modules)
+	}
+
+	// The terminal rendering still fits a standard terminal at High
+	qr, err := GenerateCompact(testURL, High)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimRight(qr, "\n"), "\n"); len(lines) > 40 || utf8.RuneCountInString(lines[0]) > 80 {
+		t.Errorf("High code is %d columns by %d lines, want it within 80x40", utf8.RuneCountInString(lines[0]), len(lines))
+	}
+
+	if DefaultECLevel.recoveryLevel() != qrcode.Medium || ECLevel(99).recoveryLevel() != qrcode.Medium {
+		t.Error("default and unknown levels should map to Medium")
+	}
+}
+
+func TestGenerateCompactHalfHeight(t *testing.T) {
+	compact, err := GenerateCompact(testURL, DefaultECLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr, err := qrcode.New(testURL, DefaultECLevel.recoveryLevel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(qr.Bitmap())
+
+	lines := strings.Split(strings.TrimRight(compact, "\n"), "\n")
+	if want := (size + 1) / 2; len(lines) != want {
+		t.Errorf("%d lines for %d modules including the quiet zone, want %d", len(lines), size, want)
+	}
+	// The four-module quiet zone fills the first two lines with light modules
+	for _, line := range lines[:2] {
+		if line != strings.Repeat("█", size) {
+			t.Errorf("quiet zone line %q isn't all light", line)
+		}
+	}
+	if got := scanModules(t, halfBlockModules(compact)); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+}
+
+func TestGenerateDataURL(t *testing.T) {
+	const prefix = "data:image/png;base64,"
+	dataURL, err := GenerateDataURL(testURL, 256, DefaultECLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dataURL, prefix) {
+		t.Fatalf("data URL starts %.30q, want %q", dataURL, prefix)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(dataURL, prefix))
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if got := scanPNG(t, data, 256); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+
+	for _, size := range []int{MinDataURLSize - 1, MaxDataURLSize + 1} {
+		if _, err := GenerateDataURL(testURL, size, DefaultECLevel); err == nil {
+			t.Errorf("size %d accepted", size)
+		}
+	}
+}
+
+// ansiModules reads a QR code drawn as pairs of spaces on ANSI backgrounds
+func ansiModules(t *testing.T, text string) [][]bool {
+	t.Helper()
+	var modules [][]bool
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line, ok := strings.CutSuffix(line, ansiReset)
+		if !ok {
+			t.Fatalf("line %q doesn't reset the colors", line)
+		}
+		var row []bool
+		dark := false
+		for line != "" {
+			switch {
+			case strings.HasPrefix(line, ansiLight):
+				dark, line = false, line[len(ansiLight):]
+			case strings.HasPrefix(line, ansiDark):
+				dark, line = true, line[len(ansiDark):]
+			case strings.HasPrefix(line, "  "):
+				row, line = append(row, dark), line[2:]
+			default:
+				t.Fatalf("unexpected output %q", line)
+			}
+		}
+		modules = append(modules, row)
+	}
+	return modules
+}
+
+func TestGenerateANSI(t *testing.T) {
+	out, err := generateANSI(testURL)
+	if err != nil {
+		t.Fatalf("generateANSI: %v", err)
+	}
+	if !strings.Contains(out, ansiLight) || !strings.Contains(out, ansiDark) {
+		t.Fatal("ANSI output lacks white or black background escapes")
+	}
+	if got := scanModules(t, ansiModules(t, out)); got != testURL {
+		t.Errorf("scanned %q, want %q", got, testURL)
+	}
+}
+
+func TestGenerateANSIWithoutTerminal(t *testing.T) {
+	if isTerminal(os.Stdout) {
+		t.Skip("stdout is a terminal")
+	}
+	out, err := GenerateANSI(testURL)
+	if err != nil {
+		t.Fatalf("GenerateANSI: %v", err)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Error("ANSI escapes written to a non-terminal")
+	}
+	want, _ := GenerateQR(testURL, DefaultECLevel)
+	if out != want {
+		t.Error("non-terminal output differs from GenerateQR")
+	}
+}
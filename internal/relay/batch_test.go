@@ -0,0 +1,110 @@
+package relay_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestBatchedDecision(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	type result struct {
+		approved bool
+		err      error
+	}
+	results := make(map[string]chan result)
+	for i := range 3 {
+		id := fmt.Sprintf("req-%d", i)
+		done := make(chan result, 1)
+		results[id] = done
+		go func() {
+			approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), id, relay.AuthRequest{ID: id, Method: "GET", Path: "/"})
+			done <- result{approved, err}
+		}()
+	}
+
+	var reqs []relay.AuthRequest
+	for range 3 {
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		reqs = append(reqs, req)
+	}
+	want := map[string]bool{reqs[0].ID: true, reqs[1].ID: false, reqs[2].ID: true}
+	// An unknown request in the batch is logged and doesn't stop the rest
+	batch := append(reqs, relay.AuthRequest{ID: "req-unknown"})
+	if err := browser.DecideBatch(batch, []bool{true, false, true, true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, done := range results {
+		select {
+		case r := <-done:
+			if r.err != nil || r.approved != want[id] {
+				t.Errorf("%s = %v, %v, want approved=%v", id, r.approved, r.err, want[id])
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not resolved by the batch", id)
+		}
+	}
+}
+
+func TestBatchedDecisionChecksNonces(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		req.Nonce = "forged"
+		browser.DecideBatch([]relay.AuthRequest{req}, []bool{true})
+	}()
+	if _, err := c.SendRequestAndWait(ctxWithTimeout(t, 300*time.Millisecond), "req-1", relay.AuthRequest{ID: "req-1"}); err == nil {
+		t.Error("batch entry with a forged nonce resolved the request")
+	}
+}
+
+func TestBatchedDecisionAnswersSentRequests(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+	decisions := c.Decisions()
+
+	// Requests sent without waiting are answered by a batch too, checked
+	// against their nonces before reaching the channel
+	reqs := []relay.AuthRequest{sendAndShow(t, c, browser, "req-1"), sendAndShow(t, c, browser, "req-2")}
+	reqs[1].Nonce = "forged"
+	if err := browser.DecideBatch(reqs, []bool{true, true}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-decisions:
+		if d.RequestID != "req-1" || !d.Approved {
+			t.Errorf("decision = %+v, want req-1 approved", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch entry for a sent request not applied")
+	}
+	select {
+	case d := <-decisions:
+		t.Errorf("forged batch entry reached the channel: %+v", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
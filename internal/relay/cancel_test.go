@@ -0,0 +1,65 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestTimeoutCancelsPrompt(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	shown := make(chan relay.AuthRequest, 2)
+	cancelled := make(chan string, 1)
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		shown <- req
+		// The cancel is the next frame, then the marker request sent below
+		if marker, err := browser.Next(context.Background(), func(id string) { cancelled <- id }); err == nil {
+			shown <- marker
+		}
+	}()
+
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, 100*time.Millisecond), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if !errors.Is(err, relay.ErrTimeout) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	req := <-shown
+	select {
+	case id := <-cancelled:
+		if id != "req-1" {
+			t.Errorf("cancelled %s, want req-1", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no cancel frame after the timeout")
+	}
+
+	// A late tap on the stale prompt is dropped; the marker's decision, sent
+	// after it, shows it was processed
+	handled := make(chan string, 2)
+	c.SetDecisionHandler(func(requestID string, approved bool) { handled <- requestID })
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: "done"}); err != nil {
+		t.Fatal(err)
+	}
+	marker := <-shown
+	browser.Decide(req, true)
+	browser.Decide(marker, true)
+	select {
+	case id := <-handled:
+		if id != "done" {
+			t.Errorf("late decision for a cancelled request handled: %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("decisions not handled")
+	}
+}
@@ -0,0 +1,127 @@
+package relay
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A frame too large to send whole can be split into CHUNK frames. Each chunk
+// payload is messageID(8) | total(4) | offset(4) | data, where total is the
+// length of the original frame and offset is where data starts in it. Chunks
+// of one message are sent in order; the relay forwards them untouched and the
+// receiver reassembles the original frame.
+
+// ChunkHeaderSize is the length of the header at the start of a chunk payload
+const ChunkHeaderSize = 16
+
+// MaxChunkedFrameSize is the largest frame a Reassembler will rebuild
+const MaxChunkedFrameSize = 16 << 20
+
+// maxPendingChunked is how many partially received frames a Reassembler keeps
+const maxPendingChunked = 16
+
+// ChunkHeader describes where a chunk's data belongs in the original frame
+type ChunkHeader struct {
+	MessageID uint64
+	Total     uint32
+	Offset    uint32
+}
+
+// SplitFrame splits frame into CHUNK frames carrying at most chunkSize bytes
+// of it each. messageID must be unique among the sender's in-flight messages.
+func SplitFrame(messageID uint64, frame []byte, chunkSize int) ([][]byte, error) {
+	if chunkSize < 1 {
+		return nil, fmt.Errorf("chunk size must be at least 1")
+	}
+	if len(frame) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+	if len(frame) > MaxChunkedFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds chunked limit of %d", len(frame), MaxChunkedFrameSize)
+	}
+
+	chunks := make([][]byte, 0, (len(frame)+chunkSize-1)/chunkSize)
+	for offset := 0; offset < len(frame); offset += chunkSize {
+		end := min(offset+chunkSize, len(frame))
+		payload := make([]byte, ChunkHeaderSize+end-offset)
+		binary.BigEndian.PutUint64(payload[0:8], messageID)
+		binary.BigEndian.PutUint32(payload[8:12], uint32(len(frame)))
+		binary.BigEndian.PutUint32(payload[12:16], uint32(offset))
+		copy(payload[ChunkHeaderSize:], frame[offset:end])
+		chunks = append(chunks, EncodeFrame(FrameChunk, payload))
+	}
+	return chunks, nil
+}
+
+// ParseChunk splits a CHUNK frame payload into its header and data
+func ParseChunk(payload []byte) (ChunkHeader, []byte, error) {
+	var header ChunkHeader
+	if len(payload) < ChunkHeaderSize {
+		return header, nil, fmt.Errorf("chunk too short")
+	}
+	header.MessageID = binary.BigEndian.Uint64(payload[0:8])
+	header.Total = binary.BigEndian.Uint32(payload[8:12])
+	header.Offset = binary.BigEndian.Uint32(payload[12:16])
+	data := payload[ChunkHeaderSize:]
+	if uint64(header.Offset)+uint64(len(data)) > uint64(header.Total) {
+		return header, nil, fmt.Errorf("chunk overruns its frame")
+	}
+	return header, data, nil
+}
+
+// IsFinalChunk reports whether a CHUNK frame payload completes its frame
+func IsFinalChunk(payload []byte) bool {
+	header, data, err := ParseChunk(payload)
+	return err == nil && uint64(header.Offset)+uint64(len(data)) == uint64(header.Total)
+}
+
+// partialFrame is a chunked frame still being received
+type partialFrame struct {
+	buf   []byte
+	total uint32
+}
+
+// Reassembler rebuilds frames from the CHUNK frames of one connection. It is not
+// safe for concurrent use.
+type Reassembler struct {
+	partial map[uint64]*partialFrame
+}
+
+// NewReassembler creates an empty Reassembler
+func NewReassembler() *Reassembler {
+	return &Reassembler{partial: make(map[uint64]*partialFrame)}
+}
+
+// Add takes a CHUNK frame payload and returns the original frame once its last
+// chunk arrives, or nil while it is incomplete. A chunk at offset zero restarts
+// its message, so a sender may resend a message from the start after a failure.
+// A chunk out of order discards the partial frame and returns an error.
+func (r *Reassembler) Add(payload []byte) ([]byte, error) {
+	header, data, err := ParseChunk(payload)
+	if err != nil {
+		return nil, err
+	}
+	if header.Total > MaxChunkedFrameSize {
+		return nil, fmt.Errorf("chunked frame of %d bytes exceeds limit of %d", header.Total, MaxChunkedFrameSize)
+	}
+
+	p := r.partial[header.MessageID]
+	if header.Offset == 0 {
+		if p == nil && len(r.partial) >= maxPendingChunked {
+			return nil, fmt.Errorf("too many partial frames")
+		}
+		p = &partialFrame{buf: make([]byte, 0, header.Total), total: header.Total}
+		r.partial[header.MessageID] = p
+	}
+	if p == nil || header.Offset != uint32(len(p.buf)) || header.Total != p.total {
+		delete(r.partial, header.MessageID)
+		return nil, fmt.Errorf("chunk at offset %d of message %d is out of order", header.Offset, header.MessageID)
+	}
+
+	p.buf = append(p.buf, data...)
+	if uint32(len(p.buf)) < p.total {
+		return nil, nil
+	}
+	delete(r.partial, header.MessageID)
+	return p.buf, nil
+}
@@ -0,0 +1,161 @@
+package relay_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// chunkPayloads splits frame and returns each chunk's CHUNK frame payload
+func chunkPayloads(t *testing.T, messageID uint64, frame []byte, chunkSize int) [][]byte {
+	t.Helper()
+	chunks, err := relay.SplitFrame(messageID, frame, chunkSize)
+	if err != nil {
+		t.Fatalf("SplitFrame: %v", err)
+	}
+	payloads := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		frameType, payload, err := relay.DecodeFrame(chunk)
+		if err != nil || frameType != relay.FrameChunk {
+			t.Fatalf("chunk %d = %v, %v, want a CHUNK frame", i, frameType, err)
+		}
+		payloads[i] = payload
+	}
+	return payloads
+}
+
+func testFrame(n int) []byte {
+	frame := make([]byte, n)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	return frame
+}
+
+func TestSplitAndReassemble(t *testing.T) {
+	for _, size := range []int{1, 299, 300, 1000} {
+		frame := testFrame(size)
+		payloads := chunkPayloads(t, 1, frame, 300)
+		if want := (size + 299) / 300; len(payloads) != want {
+			t.Errorf("%d bytes: %d chunks, want %d", size, len(payloads), want)
+		}
+
+		r := relay.NewReassembler()
+		for i, payload := range payloads {
+			header, data, err := relay.ParseChunk(payload)
+			if err != nil {
+				t.Fatalf("ParseChunk: %v", err)
+			}
+			if header.Total != uint32(size) || header.Offset != uint32(i*300) || len(data) > 300 {
+				t.Errorf("chunk %d header = %+v with %d bytes", i, header, len(data))
+			}
+			final := i == len(payloads)-1
+			if relay.IsFinalChunk(payload) != final {
+				t.Errorf("chunk %d IsFinalChunk = %v, want %v", i, !final, final)
+			}
+			got, err := r.Add(payload)
+			if err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if final && !bytes.Equal(got, frame) {
+				t.Errorf("%d bytes: reassembled frame differs", size)
+			} else if !final && got != nil {
+				t.Errorf("chunk %d returned a frame before the last chunk", i)
+			}
+		}
+	}
+}
+
+func TestReassembleInterleaved(t *testing.T) {
+	first, second := testFrame(700), bytes.Repeat([]byte{0xaa}, 500)
+	a, b := chunkPayloads(t, 1, first, 256), chunkPayloads(t, 2, second, 256)
+	r := relay.NewReassembler()
+
+	var done [][]byte
+	for i := range max(len(a), len(b)) {
+		for _, chunks := range [][][]byte{a, b} {
+			if i >= len(chunks) {
+				continue
+			}
+			frame, err := r.Add(chunks[i])
+			if err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if frame != nil {
+				done = append(done, frame)
+			}
+		}
+	}
+	if len(done) != 2 || !bytes.Equal(done[0], second) || !bytes.Equal(done[1], first) {
+		t.Errorf("reassembled %d frames, want the 500 byte frame then the 700 byte one", len(done))
+	}
+}
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	payloads := chunkPayloads(t, 1, testFrame(900), 300)
+	r := relay.NewReassembler()
+	if _, err := r.Add(payloads[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add(payloads[2]); err == nil {
+		t.Fatal("skipping a chunk succeeded")
+	}
+	// The partial frame was discarded, so the sender must start over
+	if _, err := r.Add(payloads[1]); err == nil {
+		t.Fatal("continuing a discarded frame succeeded")
+	}
+	var frame []byte
+	for _, payload := range payloads {
+		var err error
+		if frame, err = r.Add(payload); err != nil {
+			t.Fatalf("resend: %v", err)
+		}
+	}
+	if !bytes.Equal(frame, testFrame(900)) {
+		t.Error("resent frame differs")
+	}
+}
+
+func TestChunkInvalid(t *testing.T) {
+	if _, err := relay.SplitFrame(1, testFrame(10), 0); err == nil {
+		t.Error("SplitFrame with chunk size 0 succeeded")
+	}
+	if _, err := relay.SplitFrame(1, nil, 10); err == nil {
+		t.Error("SplitFrame of an empty frame succeeded")
+	}
+	if _, _, err := relay.ParseChunk(make([]byte, relay.ChunkHeaderSize-1)); err == nil {
+		t.Error("ParseChunk of a short payload succeeded")
+	}
+	overrun := chunkPayloads(t, 1, testFrame(10), 10)[0]
+	overrun = append(overrun, 0)
+	if _, _, err := relay.ParseChunk(overrun); err == nil {
+		t.Error("ParseChunk of an overrunning chunk succeeded")
+	}
+}
+
+func TestChunkedRequestThroughRelay(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithChunkSize(128))
+	browser := dialBrowser(t, srv, key)
+
+	path := "/" + strings.Repeat("a", 1000)
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err == nil && req.Path == path {
+			browser.Decide(req, true)
+		}
+	}()
+	approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: path})
+	if err != nil || !approved {
+		t.Errorf("SendRequestAndWait = %v, %v, want approved", approved, err)
+	}
+}
@@ -1,9 +1,14 @@
 package relay
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net/http"
 	"sync"
 	"time"
 
@@ -11,30 +16,304 @@ import (
 	"github.com/yuval/extauth-match/internal/crypto"
 )
 
+// DefaultMaxMessageSize is the default limit on messages read from the relay
+const DefaultMaxMessageSize = 1 << 20
+
+// DefaultDedupeWindow is how many recent decision request IDs are remembered
+const DefaultDedupeWindow = 1024
+
+// DefaultPayloadTTL is how long a sealed request stays decryptable, so a
+// frame buffered by the relay or captured off the wire can't be shown to the
+// approver long after it was sent
+const DefaultPayloadTTL = 5 * time.Minute
+
+// DecisionBufferSize is the capacity of the channel returned by Decisions
+const DecisionBufferSize = 64
+
+// Decision is an approver's answer to a request
+type Decision struct {
+	RequestID string
+	Approved  bool
+	// Headers are response headers the approver added, if any
+	Headers map[string]string
+}
+
 // DecisionHandler is a callback for handling authorization decisions
 type DecisionHandler func(requestID string, approved bool)
 
-// Client represents a relay client that connects authz server to the relay
+// RawFrameHandler is a callback observing each message read from the relay,
+// before it is decoded or decrypted
+type RawFrameHandler func(messageType int, data []byte)
+
+// Client represents a relay client that connects authz server to the relay.
+// All writes go through a single writer goroutine that takes them from a FIFO
+// queue, so messages sent from one goroutine reach the relay in the order they
+// were sent, even across reconnects.
+//
+// Reconnecting replaces only the connection. Everything else belongs to the
+// Client and carries over to every connection epoch: the decision and delivery
+// handlers and webhook (read afresh for each message, so a handler set at any
+// time sees the next decision), the encryption and MAC keys, the dedupe window
+// and the registry of pending requests. What is tied to one connection is
+// dropped with it: unacknowledged sends, whose acks can no longer arrive, and
+// partly received chunked frames. Requests already sent on the old connection
+// keep waiting, are resent or fail according to the ReconnectPolicy.
 type Client struct {
-	relayURL        string
-	tenantID        string
-	encryptionKey   []byte
-	conn            *websocket.Conn
+	relayURL string
+	tenantID string
+	// encKey and macKey are derived from the pairing key
+	encKey []byte
+	macKey []byte
+	conn   *websocket.Conn
+	// epoch counts connections made; each connection's reader only acts while
+	// its epoch is still current
+	epoch           uint64
 	decisionHandler DecisionHandler
+	deliveryHandler DeliveryHandler
+	rawHandler      RawFrameHandler
+	authToken       string
+	webhook         *Webhook
+	maxMessageSize  int64
+	waiters         map[string]*pendingRequest
+	acks            []string
+	queue           chan *outbound
+	done            chan struct{}
+	writerDone      chan struct{}
+	closeOnce       sync.Once
 	mu              sync.RWMutex
 	maxRetries      int
 	retryDelay      time.Duration
+	reconnectBudget time.Duration
+	pingInterval    time.Duration
+	heartbeat       time.Duration
+	lastPong        time.Time
+	probes          map[string]chan struct{}
+	probeSeq        uint64
+	idleTimeout     time.Duration
+	lastActivity    time.Time
+	redial          bool
+	// rejected is set once the relay permanently rejects the connection
+	rejected *RejectedError
+	// drainedUntil is when a draining relay said reconnecting may succeed
+	drainedUntil time.Time
+	chunkSize    int
+	// maxPlaintextSize bounds a request before encryption and a message after
+	// decryption
+	maxPlaintextSize int
+	// payloadTTL is sealed into each request as its expiry; zero sends
+	// requests that never expire
+	payloadTTL time.Duration
+	// seen holds the last dedupeWindow decided request IDs, oldest first in seenOrder
+	dedupeWindow int
+	seen         map[string]struct{}
+	seenOrder    []string
+	decisions    chan Decision
+	closed       bool
+	// nonces holds the nonce each sent request must be answered with. Those
+	// sent without waiting are also listed in nonceOrder, oldest first, and
+	// forgotten once DefaultDedupeWindow newer ones have been sent.
+	nonces     map[string]string
+	nonceOrder []sentNonce
+	// quorum is how many distinct approvers must approve, out of approvers
+	quorum    int
+	approvers int
+	// compression offers permessage-deflate; wire counts the bytes written to
+	// the connection when the relay accepted it
+	compression bool
+	wire        *countingConn
+	// payloadCompression deflates request payloads before they are sealed
+	payloadCompression bool
+	// reconnectPolicy decides what happens to requests in flight when the
+	// connection is replaced
+	reconnectPolicy ReconnectPolicy
 }
 
-// NewClient creates a new relay client
-func NewClient(relayURL, tenantID string, encryptionKey []byte) (*Client, error) {
-	return &Client{
-		relayURL:      relayURL,
-		tenantID:      tenantID,
-		encryptionKey: encryptionKey,
-		maxRetries:    3,
-		retryDelay:    time.Second,
-	}, nil
+// outbound is a message queued for the writer goroutine
+type outbound struct {
+	// requestID matches the relay's acknowledgement to a waiting request
+	requestID   string
+	messageType int
+	// data is nil for a Flush marker
+	data []byte
+	// bestEffort messages (pings and cancels) are never retried, acknowledged or used to re-dial
+	bestEffort bool
+	// reconnect re-dials a dropped connection without sending anything
+	reconnect bool
+	result    chan error
+}
+
+// waitResult resolves a SendRequestAndWait call
+type waitResult struct {
+	approved bool
+	headers  map[string]string
+	err      error
+}
+
+// pendingRequest is a SendRequestAndWait call awaiting its decision. Each call
+// owns its entry and its context's deadline, so one slow or cancelled request
+// never resolves or removes another's.
+type pendingRequest struct {
+	result chan waitResult
+	// deadline is the zero time if the caller's context has none
+	deadline time.Time
+	// votes holds each approver's decision when a quorum is required
+	votes map[string]bool
+	// frame is the DATA frame as sent, set once it has been written
+	frame []byte
+	// reconnected is signalled to resend frame on a new connection
+	reconnected chan struct{}
+}
+
+// resolve delivers r unless the request was already resolved; the first result wins
+func (p *pendingRequest) resolve(r waitResult) {
+	select {
+	case p.result <- r:
+	default:
+	}
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithMaxRetries sets how many times a failed send is retried after reconnecting
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithReconnectBudget caps the total time a send spends reconnecting before it
+// gives up, however many retries remain. Zero means no limit.
+func WithReconnectBudget(d time.Duration) Option {
+	return func(c *Client) {
+		c.reconnectBudget = d
+	}
+}
+
+// WithIdleTimeout closes the relay connection once no message has been sent or
+// received for d while no request is awaiting a decision; the next send re-dials.
+// Zero disables the idle timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = d
+	}
+}
+
+// WithDedupeWindow sets how many recent decisions are remembered so a repeated
+// decision for the same request ID, e.g. from a second browser, is ignored
+func WithDedupeWindow(n int) Option {
+	return func(c *Client) {
+		c.dedupeWindow = n
+	}
+}
+
+// WithChunkSize sends frames larger than n bytes as CHUNK frames of at most n
+// bytes of the original each, which the browser reassembles. Zero, the default,
+// sends every frame whole.
+func WithChunkSize(n int) Option {
+	return func(c *Client) {
+		c.chunkSize = n
+	}
+}
+
+// WithMaxPlaintextSize limits requests and decisions to n bytes of plaintext,
+// instead of crypto.DefaultMaxPlaintextSize. A larger request fails with
+// crypto.ErrTooLarge before it is encrypted, and a larger message from the
+// relay is dropped.
+func WithMaxPlaintextSize(n int) Option {
+	return func(c *Client) {
+		c.maxPlaintextSize = n
+	}
+}
+
+// WithPayloadTTL sets how long a request stays valid after it is sent,
+// instead of DefaultPayloadTTL. The expiry is sealed with the request, and the
+// approval page drops a request whose expiry has passed. Zero disables it.
+func WithPayloadTTL(d time.Duration) Option {
+	return func(c *Client) {
+		c.payloadTTL = d
+	}
+}
+
+// NewClient creates a new relay client. encryptionKey must be crypto.KeySize
+// bytes, so a misconfigured key fails here rather than on the first send.
+func NewClient(relayURL, tenantID string, encryptionKey []byte, opts ...Option) (*Client, error) {
+	if err := crypto.CheckKeyLength(encryptionKey); err != nil {
+		return nil, err
+	}
+	encKey, err := crypto.DeriveSubkey(encryptionKey, EncSubkeyPurpose, crypto.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := crypto.DeriveSubkey(encryptionKey, MACSubkeyPurpose, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		macKey:           macKey,
+		relayURL:         relayURL,
+		tenantID:         tenantID,
+		encKey:           encKey,
+		maxRetries:       3,
+		retryDelay:       time.Second,
+		maxMessageSize:   DefaultMaxMessageSize,
+		waiters:          make(map[string]*pendingRequest),
+		queue:            make(chan *outbound, 64),
+		done:             make(chan struct{}),
+		writerDone:       make(chan struct{}),
+		dedupeWindow:     DefaultDedupeWindow,
+		maxPlaintextSize: crypto.DefaultMaxPlaintextSize,
+		payloadTTL:       DefaultPayloadTTL,
+		seen:             make(map[string]struct{}),
+		nonces:           make(map[string]string),
+		probes:           make(map[string]chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxRetries < 0 {
+		return nil, fmt.Errorf("max retries must not be negative")
+	}
+	if c.dedupeWindow < 1 {
+		return nil, fmt.Errorf("dedupe window must be at least 1")
+	}
+	if c.chunkSize < 0 {
+		return nil, fmt.Errorf("chunk size must not be negative")
+	}
+	if c.maxPlaintextSize < 1 {
+		return nil, fmt.Errorf("max plaintext size must be positive")
+	}
+	if c.payloadTTL < 0 {
+		return nil, fmt.Errorf("payload TTL must not be negative")
+	}
+	if c.reconnectPolicy < ReconnectWait || c.reconnectPolicy > ReconnectFail {
+		return nil, fmt.Errorf("invalid reconnect policy %d", c.reconnectPolicy)
+	}
+	if c.quorum < 0 || c.approvers < 0 {
+		return nil, fmt.Errorf("quorum must not be negative")
+	}
+	if c.approvers > 0 && c.approvers < c.quorum {
+		return nil, fmt.Errorf("quorum of %d needs at least as many approvers, got %d", c.quorum, c.approvers)
+	}
+	go c.writeLoop()
+	if c.idleTimeout > 0 {
+		go c.closeWhenIdle()
+	}
+	if c.pingInterval > 0 {
+		go c.keepalive()
+	}
+	if c.heartbeat > 0 {
+		go c.sendHeartbeats()
+	}
+	return c, nil
+}
+
+// SetMaxMessageSize sets the largest message accepted from the relay, in bytes
+func (c *Client) SetMaxMessageSize(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxMessageSize = size
 }
 
 // SetDecisionHandler sets the handler for authorization decisions
@@ -44,141 +323,735 @@ func (c *Client) SetDecisionHandler(handler DecisionHandler) {
 	c.decisionHandler = handler
 }
 
+// Decisions returns a channel receiving every decision, as an alternative to
+// SetDecisionHandler. It holds DecisionBufferSize decisions; if the consumer
+// falls further behind, new decisions are dropped from the channel (the handler
+// and SendRequestAndWait still see them). The channel is closed by Close.
+func (c *Client) Decisions() <-chan Decision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.decisions == nil {
+		c.decisions = make(chan Decision, DecisionBufferSize)
+		if c.closed {
+			close(c.decisions)
+		}
+	}
+	return c.decisions
+}
+
+// SetDeliveryHandler sets the handler for relay delivery acknowledgements
+func (c *Client) SetDeliveryHandler(handler DeliveryHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deliveryHandler = handler
+}
+
+// SetRawFrameHandler sets a handler called with every message read from the
+// relay exactly as it arrived, for debugging or inspecting wire traffic. It
+// gets its own copy of the bytes and runs on the read loop before the message
+// is handled as usual, so it should return quickly. Nil removes it.
+func (c *Client) SetRawFrameHandler(handler RawFrameHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rawHandler = handler
+}
+
+// SetWebhook posts every decision to webhook in the background
+func (c *Client) SetWebhook(webhook *Webhook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.webhook = webhook
+}
+
+// SetAuthToken sets the bearer token presented to the relay on connect
+func (c *Client) SetAuthToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authToken = token
+}
+
 // Connect establishes WebSocket connection to relay
 func (c *Client) Connect() error {
 	wsURL := fmt.Sprintf("%s/ws/server/%s", c.relayURL, c.tenantID)
 
-	var err error
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	c.mu.RLock()
+	authToken := c.authToken
+	maxMessageSize := c.maxMessageSize
+	c.mu.RUnlock()
+
+	header := http.Header{}
+	if authToken != "" {
+		header.Set("Authorization", "Bearer "+authToken)
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = Subprotocols
+	var counted func() *countingConn
+	if c.compression {
+		counted = c.offerCompression(&dialer)
+	}
+	conn, resp, err := dialer.Dial(wsURL, header)
 	if err != nil {
+		if resp != nil && !RetryableStatus(resp.StatusCode) {
+			rejected := &RejectedError{Status: resp.StatusCode, Reason: http.StatusText(resp.StatusCode)}
+			slog.Error("Relay rejected connection", "status", resp.StatusCode)
+			c.mu.Lock()
+			c.rejected = rejected
+			c.mu.Unlock()
+			return fmt.Errorf("failed to connect to relay: %w", rejected)
+		}
 		return fmt.Errorf("failed to connect to relay: %w", err)
 	}
+	var wire *countingConn
+	if counted != nil {
+		if compressionAccepted(resp) {
+			wire = counted()
+		} else {
+			slog.Info("Relay doesn't support compression, sending uncompressed")
+		}
+	}
+	// A relay from before subprotocols were negotiated selects none
+	if protocol := conn.Subprotocol(); protocol != "" && protocol != Subprotocol {
+		conn.Close()
+		return fmt.Errorf("relay selected unsupported subprotocol %q", protocol)
+	}
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetPongHandler(c.handlePong)
 
 	c.mu.Lock()
+	if c.conn != nil {
+		// The reader of the replaced connection sees its epoch is stale and exits
+		c.conn.Close()
+	}
+	c.epoch++
+	epoch := c.epoch
 	c.conn = conn
+	c.wire = wire
+	c.redial = false
+	c.rejected = nil
+	c.lastActivity = time.Now()
+	c.lastPong = time.Now()
+	c.notifyReconnectedLocked()
 	c.mu.Unlock()
 
 	slog.Info("Connected to relay as server", "tenantID", c.tenantID)
 
 	// Start reading messages from relay
-	go c.readMessages()
+	go c.readMessages(conn, epoch)
 
 	return nil
 }
 
-// SendRequest sends an encrypted auth request to the browser
+// Epoch returns the generation of the current relay connection, incremented by
+// every Connect; it is zero before the first connection
+func (c *Client) Epoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+// current reports whether epoch is still the live connection's
+func (c *Client) current(epoch uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch == epoch && c.conn != nil
+}
+
+// SendRequest sends an encrypted auth request to the browser. An AuthRequest
+// (or pointer to one) is sent as SendAuthRequest sends it; other payloads
+// carry no nonce, so no decision is accepted for them.
 func (c *Client) SendRequest(requestData interface{}) error {
+	if ptr, ok := requestData.(*AuthRequest); ok && ptr != nil {
+		requestData = *ptr
+	}
+	if req, ok := requestData.(AuthRequest); ok {
+		return c.SendAuthRequest(req)
+	}
+	return c.send("", requestData)
+}
+
+// send encrypts a request and queues it for the writer, recording requestID so
+// the relay's acknowledgement, which arrives in send order, can be matched to it
+func (c *Client) send(requestID string, requestData interface{}) error {
+	frame, err := c.encode(requestID, requestData)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(&outbound{requestID: requestID, messageType: websocket.BinaryMessage, data: frame})
+}
+
+// encode marshals and encrypts a request into a DATA frame
+func (c *Client) encode(requestID string, requestData interface{}) ([]byte, error) {
 	// Marshal to JSON
 	plaintext, err := json.Marshal(requestData)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if len(plaintext) > c.maxPlaintextSize {
+		return nil, fmt.Errorf("%w: %w: %d bytes exceeds %d", ErrEncrypt, crypto.ErrTooLarge, len(plaintext), c.maxPlaintextSize)
 	}
 
 	// Encrypt
-	ciphertext, err := crypto.Encrypt(c.encryptionKey, plaintext)
+	var opts []crypto.SealOption
+	if c.payloadCompression {
+		opts = append(opts, crypto.WithCompression())
+	}
+	if c.payloadTTL > 0 {
+		opts = append(opts, crypto.WithExpiry(c.payloadTTL))
+	}
+	ciphertext, err := crypto.SealEnvelope(crypto.AlgAES256GCM, c.encKey, plaintext, opts...)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt request: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrEncrypt, err)
 	}
 
-	// Try to send with retry logic
-	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+	return EncodeData(c.macKey, RoutingHeader{RequestID: requestID}, ciphertext)
+}
+
+// Flush blocks until every message queued before it has been written
+func (c *Client) Flush() error {
+	return c.enqueue(&outbound{})
+}
+
+// enqueue hands msg to the writer goroutine and waits for it to be written
+func (c *Client) enqueue(msg *outbound) error {
+	msg.result = make(chan error, 1)
+
+	select {
+	case c.queue <- msg:
+	case <-c.done:
+		return ErrClientClosed
+	}
+
+	select {
+	case err := <-msg.result:
+		return err
+	case <-c.writerDone:
+		select {
+		case err := <-msg.result:
+			return err
+		default:
+			return ErrClientClosed
+		}
+	}
+}
+
+// writeLoop is the only goroutine that writes data messages to the relay
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+	for {
+		select {
+		case msg := <-c.queue:
+			msg.result <- c.write(msg)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write sends one queued message, reconnecting and retrying if the connection is broken
+func (c *Client) write(msg *outbound) error {
+	if msg.reconnect {
+		return c.reconnect()
+	}
+	if msg.data == nil {
+		return nil
+	}
+
+	if msg.bestEffort {
 		c.mu.RLock()
 		conn := c.conn
 		c.mu.RUnlock()
-
 		if conn == nil {
-			return fmt.Errorf("not connected to relay")
+			return ErrNotConnected
 		}
+		return conn.WriteMessage(msg.messageType, msg.data)
+	}
 
-		if err := conn.WriteMessage(websocket.BinaryMessage, ciphertext); err != nil {
-			// If connection is broken, try to reconnect
-			if attempt < c.maxRetries {
-				slog.Warn("Failed to send to relay, attempting reconnect", "attempt", attempt+1, "error", err)
+	// A connection the client closed itself (idle or unresponsive) is re-dialed on demand
+	c.mu.RLock()
+	redial := c.conn == nil && c.redial
+	c.mu.RUnlock()
+	if redial {
+		if err := c.Connect(); err != nil {
+			return fmt.Errorf("%w: failed to reconnect: %w", ErrNotConnected, err)
+		}
+	}
 
-				// Close existing connection
-				c.mu.Lock()
-				if c.conn != nil {
-					c.conn.Close()
-					c.conn = nil
-				}
-				c.mu.Unlock()
+	// A chunked message is acknowledged once, for its final chunk, and a retry
+	// resends it from the first chunk
+	frames := [][]byte{msg.data}
+	if c.chunkSize > 0 && len(msg.data) > c.chunkSize {
+		var err error
+		frames, err = SplitFrame(rand.Uint64(), msg.data, c.chunkSize)
+		if err != nil {
+			return err
+		}
+	}
 
-				// Wait before retrying
-				time.Sleep(c.retryDelay)
+	var lastErr error
+	var reconnectStart time.Time
 
-				// Attempt to reconnect
-				if reconnectErr := c.Connect(); reconnectErr != nil {
-					slog.Error("Failed to reconnect to relay", "error", reconnectErr)
-					continue
-				}
+	// Try to send with retry logic
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if c.reconnectBudget > 0 && time.Since(reconnectStart) >= c.reconnectBudget {
+				err := fmt.Errorf("%w: reconnect budget of %s exhausted: %w", ErrNotConnected, c.reconnectBudget, lastErr)
+				c.failWaiters(err)
+				return err
+			}
+			// Reconnecting won't help once the relay has refused this client for good
+			c.mu.RLock()
+			rejected := c.rejected
+			c.mu.RUnlock()
+			if rejected != nil {
+				c.failWaiters(rejected)
+				return rejected
+			}
+			slog.Warn("Failed to send to relay, attempting reconnect", "attempt", attempt, "error", lastErr)
+
+			// Close existing connection
+			c.mu.Lock()
+			if c.conn != nil {
+				c.conn.Close()
+				c.conn = nil
+			}
+			// Acks for the old connection will never arrive
+			c.acks = nil
+			c.mu.Unlock()
+
+			// Wait before retrying, longer if the relay asked for it while draining
+			c.mu.RLock()
+			delay := max(c.retryDelay, time.Until(c.drainedUntil))
+			c.mu.RUnlock()
+			time.Sleep(delay)
 
-				// Retry sending the message
+			// Attempt to reconnect
+			if err := c.Connect(); err != nil {
+				slog.Error("Failed to reconnect to relay", "error", err)
+				lastErr = err
 				continue
 			}
+		}
+
+		c.mu.RLock()
+		conn := c.conn
+		wire := c.wire
+		c.mu.RUnlock()
 
-			return fmt.Errorf("failed to send to relay after %d attempts: %w", c.maxRetries+1, err)
+		if conn == nil {
+			return ErrNotConnected
+		}
+		var before int64
+		if wire != nil {
+			before = wire.written.Load()
+		}
+
+		// Queue the ack before writing, the relay may answer before WriteMessage returns
+		c.mu.Lock()
+		c.acks = append(c.acks, msg.requestID)
+		c.mu.Unlock()
+
+		if err := writeFrames(conn, msg.messageType, frames); err != nil {
+			c.mu.Lock()
+			c.acks = c.acks[:len(c.acks)-1]
+			c.mu.Unlock()
+
+			lastErr = err
+			if reconnectStart.IsZero() {
+				reconnectStart = time.Now()
+			}
+			continue
 		}
 
 		// Success
+		logCompression(wire, before, msg.requestID, len(msg.data))
+		c.touch()
 		return nil
 	}
 
-	return fmt.Errorf("failed to send to relay after all retries")
+	err := fmt.Errorf("%w: failed to send after %d attempts: %w", ErrNotConnected, c.maxRetries+1, lastErr)
+	c.failWaiters(err)
+	return err
 }
 
-// readMessages reads encrypted messages from relay (decisions from browser)
-func (c *Client) readMessages() {
+// writeFrames writes each frame to conn in order
+func writeFrames(conn *websocket.Conn, messageType int, frames [][]byte) error {
+	for _, frame := range frames {
+		if err := conn.WriteMessage(messageType, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failWaiters resolves every pending SendRequestAndWait with err; decisions for
+// them can no longer arrive
+func (c *Client) failWaiters(err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.failWaitersLocked(err)
+}
+
+// failWaitersLocked is failWaiters with c.mu held
+func (c *Client) failWaitersLocked(err error) {
+	for _, pending := range c.waiters {
+		pending.resolve(waitResult{err: err})
+	}
+}
+
+// SendRequestAndWait sends an auth request and blocks until the browser decides
+// on requestID or ctx is done. It returns ErrNoApprover as soon as the relay
+// reports no browser to deliver to, ErrRateLimited if the relay's rate limit
+// dropped it, and ErrClientClosed if the client is closed while waiting. The
+// decision handler, if set, is still called. requestData must be an AuthRequest
+// (or pointer to one); it is sent with a fresh nonce, and decisions that don't
+// echo it are rejected, so a decision captured for one prompt can't answer
+// another.
+func (c *Client) SendRequestAndWait(ctx context.Context, requestID string, requestData interface{}) (bool, error) {
+	decision, err := c.SendRequestAndWaitDecision(ctx, requestID, requestData)
+	return decision.Approved, err
+}
+
+// SendRequestAndWaitDecision is SendRequestAndWait returning the whole
+// decision, including any headers the approver added
+func (c *Client) SendRequestAndWaitDecision(ctx context.Context, requestID string, requestData interface{}) (Decision, error) {
+	pending := &pendingRequest{result: make(chan waitResult, 1), reconnected: make(chan struct{}, 1)}
+	if deadline, ok := ctx.Deadline(); ok {
+		pending.deadline = deadline
+	}
+	if ptr, ok := requestData.(*AuthRequest); ok && ptr != nil {
+		requestData = *ptr
+	}
+	req, ok := requestData.(AuthRequest)
+	if !ok {
+		return Decision{}, fmt.Errorf("request %s is a %T, only an AuthRequest can be answered", requestID, requestData)
+	}
+	if req.ID != requestID {
+		return Decision{}, fmt.Errorf("request ID %q does not match the request's ID %q", requestID, req.ID)
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	req.Nonce = nonce
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return Decision{}, ErrClientClosed
+	}
+	if _, exists := c.waiters[requestID]; exists {
+		c.mu.Unlock()
+		return Decision{}, fmt.Errorf("request %s is already pending", requestID)
+	}
+	c.waiters[requestID] = pending
+	c.nonces[requestID] = nonce
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.waiters[requestID] == pending {
+			delete(c.waiters, requestID)
+		}
+		if c.nonces[requestID] == nonce {
+			delete(c.nonces, requestID)
+		}
+		c.mu.Unlock()
+	}()
+
+	frame, err := c.encode(requestID, req)
+	if err != nil {
+		return Decision{}, err
+	}
+	if err := c.enqueue(&outbound{requestID: requestID, messageType: websocket.BinaryMessage, data: frame}); err != nil {
+		return Decision{}, err
+	}
+	c.mu.Lock()
+	pending.frame = frame
+	c.mu.Unlock()
+
 	for {
-		c.mu.RLock()
-		conn := c.conn
-		c.mu.RUnlock()
+		select {
+		case r := <-pending.result:
+			return Decision{RequestID: requestID, Approved: r.approved, Headers: r.headers}, r.err
+		case <-pending.reconnected:
+			// The same frame is sent again, so the approver sees one request
+			// whichever connection it arrives on
+			slog.Info("Resending request after reconnecting to relay", "requestID", requestID)
+			if err := c.enqueue(&outbound{requestID: requestID, messageType: websocket.BinaryMessage, data: frame}); err != nil {
+				return Decision{}, err
+			}
+		case <-ctx.Done():
+			c.cancelRequest(requestID)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return Decision{}, fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+			}
+			return Decision{}, ctx.Err()
+		}
+	}
+}
 
-		if conn == nil {
-			return
+// cancelRequest tells browsers to dismiss the prompt for a request that is no
+// longer awaited, and drops any decision for it that still arrives
+func (c *Client) cancelRequest(requestID string) {
+	if !c.firstDecision(requestID) {
+		return
+	}
+	cancel, err := json.Marshal(ControlFrame{Type: ControlTypeCancel, RequestID: requestID})
+	if err != nil {
+		slog.Error("Failed to marshal cancel frame", "requestID", requestID, "error", err)
+		return
+	}
+	// The caller has already given up, so it doesn't wait for the write
+	go func() {
+		msg := &outbound{messageType: websocket.BinaryMessage, data: EncodeFrame(FrameControl, cancel), bestEffort: true}
+		if err := c.enqueue(msg); err != nil {
+			slog.Debug("Failed to send cancel to relay", "requestID", requestID, "error", err)
 		}
+	}()
+}
 
-		_, message, err := conn.ReadMessage()
+// readMessages reads encrypted messages from conn (decisions from browser)
+// until it fails or is closed. It stops as soon as epoch is no longer current,
+// so a reader left over from a replaced connection never consumes the new
+// connection's acks or marks it rejected.
+func (c *Client) readMessages(conn *websocket.Conn, epoch uint64) {
+	chunks := NewReassembler()
+	for {
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if !c.current(epoch) {
+				return
+			}
+			var closeErr *websocket.CloseError
+			if errors.Is(err, websocket.ErrReadLimit) {
+				slog.Warn("Relay message exceeds size limit, closing connection")
+				conn.Close()
+			} else if errors.As(err, &closeErr) && !RetryableClose(closeErr.Code) {
+				slog.Error("Relay rejected connection", "code", closeErr.Code, "reason", closeErr.Text)
+				c.mu.Lock()
+				if c.epoch == epoch {
+					c.rejected = &RejectedError{Code: closeErr.Code, Reason: closeErr.Text}
+				}
+				c.mu.Unlock()
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				slog.Error("Relay connection error", "error", err)
 			}
+			go c.dropped(epoch)
+			return
+		}
+		if !c.current(epoch) {
+			slog.Debug("Discarding message from a replaced relay connection", "epoch", epoch)
 			return
 		}
+		c.touch()
 
-		// Decrypt message
-		plaintext, err := crypto.Decrypt(c.encryptionKey, message)
+		c.mu.RLock()
+		rawHandler := c.rawHandler
+		c.mu.RUnlock()
+		if rawHandler != nil {
+			rawHandler(messageType, bytes.Clone(message))
+		}
+
+		if messageType != websocket.BinaryMessage {
+			slog.Debug("Ignoring non-binary relay message", "type", messageType)
+			continue
+		}
+		frameType, payload, err := DecodeFrame(message)
 		if err != nil {
-			slog.Error("Failed to decrypt message", "error", err)
+			slog.Error("Failed to decode relay frame", "error", err)
 			continue
 		}
 
-		// Parse decision
-		var decision struct {
-			RequestID string `json:"requestId"`
-			Approved  bool   `json:"approved"`
+		if frameType == FrameChunk {
+			frame, err := chunks.Add(payload)
+			if err != nil {
+				slog.Error("Failed to reassemble chunked frame", "error", err)
+				continue
+			}
+			if frame == nil {
+				continue
+			}
+			// Only a DATA frame may be chunked
+			frameType, payload, err = DecodeFrame(frame)
+			if err != nil || frameType != FrameData {
+				slog.Error("Reassembled frame is not a data frame", "frame", frameType, "error", err)
+				continue
+			}
 		}
 
-		if err := json.Unmarshal(plaintext, &decision); err != nil {
-			slog.Error("Failed to unmarshal decision", "error", err)
+		switch frameType {
+		case FrameAck, FrameControl:
+			c.handleControl(epoch, payload)
+			continue
+		case FramePing:
 			continue
 		}
 
-		// Call handler
-		c.mu.RLock()
-		handler := c.decisionHandler
-		c.mu.RUnlock()
+		header, ciphertext, err := DecodeData(c.macKey, payload)
+		if err != nil {
+			slog.Error("Failed to verify data frame", "error", fmt.Errorf("%w: %w", ErrDecrypt, err))
+			continue
+		}
+
+		// Decrypt message
+		plaintext, err := crypto.OpenEnvelope(c.encKey, ciphertext, crypto.WithMaxPlaintextSize(c.maxPlaintextSize))
+		if errors.Is(err, crypto.ErrTooLarge) {
+			slog.Error("Dropping oversized message", "requestID", header.RequestID, "error", fmt.Errorf("%w: %w", ErrDecrypt, err))
+			continue
+		}
+		if errors.Is(err, crypto.ErrExpired) {
+			slog.Warn("Dropping expired message", "requestID", header.RequestID, "error", fmt.Errorf("%w: %w", ErrDecrypt, err))
+			continue
+		}
+		if err != nil {
+			slog.Error("Failed to decrypt message", "error", fmt.Errorf("%w: %w", ErrDecrypt, err))
+			continue
+		}
+
+		c.handleDecision(header, plaintext)
+	}
+}
+
+// firstDecision records requestID and reports whether no decision for it has
+// been seen within the dedupe window
+func (c *Client) firstDecision(requestID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, dup := c.seen[requestID]; dup {
+		return false
+	}
+	if len(c.seenOrder) >= c.dedupeWindow {
+		delete(c.seen, c.seenOrder[0])
+		c.seenOrder = c.seenOrder[1:]
+	}
+	c.seen[requestID] = struct{}{}
+	c.seenOrder = append(c.seenOrder, requestID)
+	return true
+}
+
+// handleControl processes the payload of an ACK or CONTROL frame received on
+// the connection of the given epoch
+func (c *Client) handleControl(epoch uint64, message []byte) {
+	var frame ControlFrame
+	if err := json.Unmarshal(message, &frame); err != nil {
+		slog.Error("Failed to unmarshal control frame", "error", err)
+		return
+	}
+
+	switch frame.Type {
+	case ControlTypeAck:
+		c.mu.Lock()
+		if c.epoch != epoch {
+			// The queue now holds the new connection's acks
+			c.mu.Unlock()
+			return
+		}
+		var requestID string
+		if len(c.acks) > 0 {
+			requestID = c.acks[0]
+			c.acks = c.acks[1:]
+		}
+		status := DeliveryStatus{RequestID: requestID, Clients: frame.Clients, Buffered: frame.Buffered, RateLimited: frame.RateLimited}
+		waiter := c.waiters[requestID]
+		handler := c.deliveryHandler
+		c.mu.Unlock()
+
+		if status.RateLimited {
+			slog.Warn("Relay rate limit dropped request", "requestID", requestID)
+			if waiter != nil {
+				waiter.resolve(waitResult{err: ErrRateLimited})
+			}
+		} else if !status.Delivered() {
+			slog.Warn("Relay reports no approver online for request", "requestID", requestID)
+			if waiter != nil {
+				waiter.resolve(waitResult{err: ErrNoApprover})
+			}
+		}
 
 		if handler != nil {
-			handler(decision.RequestID, decision.Approved)
+			handler(status)
+		}
+	case ControlTypeDrain:
+		retryAfter := time.Duration(frame.RetryAfter) * time.Second
+		slog.Info("Relay is draining, backing off before reconnecting", "retryAfter", retryAfter)
+		c.mu.Lock()
+		c.drainedUntil = time.Now().Add(retryAfter)
+		c.mu.Unlock()
+	default:
+		slog.Debug("Ignoring unknown control frame", "type", frame.Type)
+	}
+}
+
+// touch records activity on the connection for the idle timeout
+func (c *Client) touch() {
+	c.mu.Lock()
+	c.lastActivity = time.Now()
+	c.mu.Unlock()
+}
+
+// awaitingLocked reports whether any request is still waiting for a decision
+// that can arrive, ignoring entries whose own deadline has passed. c.mu must be held.
+func (c *Client) awaitingLocked() bool {
+	now := time.Now()
+	for _, pending := range c.waiters {
+		if pending.deadline.IsZero() || now.Before(pending.deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeWhenIdle closes the connection once it has been idle for idleTimeout
+func (c *Client) closeWhenIdle() {
+	ticker := time.NewTicker(c.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		conn := c.conn
+		if conn == nil || c.awaitingLocked() || time.Since(c.lastActivity) < c.idleTimeout {
+			c.mu.Unlock()
+			continue
 		}
+		c.conn = nil
+		c.acks = nil
+		c.redial = true
+		c.mu.Unlock()
+
+		slog.Info("Closing idle relay connection", "idleTimeout", c.idleTimeout)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle"), time.Now().Add(time.Second))
+		conn.Close()
 	}
 }
 
-// Close closes the relay connection
+// Close stops the writer, resolves pending SendRequestAndWait calls with
+// ErrClientClosed and closes the relay connection
 func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	<-c.writerDone
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if !c.closed {
+		c.closed = true
+		c.failWaitersLocked(ErrClientClosed)
+		if c.decisions != nil {
+			close(c.decisions)
+		}
+	}
+
 	if c.conn != nil {
 		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 		time.Sleep(time.Second)
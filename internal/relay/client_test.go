@@ -0,0 +1,232 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// newClient connects a Client with a fresh key to srv, closing it when the
+// test ends
+func newClient(t *testing.T, srv *relaytest.Server, opts ...relay.Option) (*relay.Client, []byte) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient(srv.WSURL(), crypto.DeriveTenantID(key), key, opts...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return c, key
+}
+
+// dialBrowser connects a fake approval page for key's tenant and waits until
+// the relay has attached it
+func dialBrowser(t *testing.T, srv *relaytest.Server, key []byte) *relaytest.Browser {
+	t.Helper()
+	tenantID := crypto.DeriveTenantID(key)
+	before := srv.Clients(tenantID)
+	browser, err := srv.DialBrowser(tenantID, key)
+	if err != nil {
+		t.Fatalf("DialBrowser: %v", err)
+	}
+	t.Cleanup(func() { browser.Close() })
+	waitFor(t, "browser to attach", func() bool { return srv.Clients(tenantID) > before })
+	return browser
+}
+
+// ctxWithTimeout returns a context cancelled after d or when the test ends
+func ctxWithTimeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// waitFor polls cond until it holds or two seconds pass
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	for _, approve := range []bool{true, false} {
+		go func() {
+			req, err := browser.Next(context.Background(), nil)
+			if err == nil {
+				browser.Decide(req, approve)
+			}
+		}()
+		id := "req-" + map[bool]string{true: "approve", false: "deny"}[approve]
+		approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), id, relay.AuthRequest{ID: id, Method: "GET", Path: "/"})
+		if err != nil || approved != approve {
+			t.Fatalf("approved=%v err=%v, want approved=%v", approved, err, approve)
+		}
+	}
+}
+
+func TestNewClientChecksKeyLength(t *testing.T) {
+	for _, size := range []int{0, 16, crypto.KeySize - 1, crypto.KeySize, crypto.KeySize + 1, 64} {
+		key := make([]byte, size)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		c, err := relay.NewClient("ws://relay.invalid", "tenant", key)
+		if size == crypto.KeySize {
+			if err != nil {
+				t.Errorf("NewClient with a %d-byte key: %v", size, err)
+			} else {
+				c.Close()
+			}
+		} else if err == nil {
+			c.Close()
+			t.Errorf("NewClient accepted a %d-byte key", size)
+		}
+	}
+}
+
+func TestPayloadsUseEncSubkey(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialRawBrowser(t, srv, key)
+
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := nextCiphertext(t, browser, key)
+
+	if _, err := crypto.OpenEnvelope(key, ciphertext); err == nil {
+		t.Error("the pairing key decrypts a payload directly")
+	}
+	encKey, _ := crypto.DeriveSubkey(key, relay.EncSubkeyPurpose, crypto.KeySize)
+	if _, err := crypto.OpenEnvelope(encKey, ciphertext); err != nil {
+		t.Errorf("enc subkey doesn't decrypt the payload: %v", err)
+	}
+}
+
+func TestPayloadCompressionShrinksHeaderDump(t *testing.T) {
+	headers := make(map[string]string)
+	var dump strings.Builder
+	for i := range 64 {
+		name, value := fmt.Sprintf("x-forwarded-header-%02d", i), strings.Repeat("trace=abcdef0123456789;", 8)
+		headers[name] = value
+		fmt.Fprintf(&dump, "%s: %s\n", name, value)
+	}
+	req := relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/reports", Headers: headers, Summary: dump.String()}
+
+	wireSize := func(opts ...relay.Option) int {
+		srv := relaytest.NewServer()
+		defer srv.Close()
+		c, key := newClient(t, srv, opts...)
+		raw := dialRawBrowser(t, srv, key)
+		browser := dialBrowser(t, srv, key)
+
+		if err := c.SendAuthRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		ciphertext := nextCiphertext(t, raw, key)
+		got, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Summary != req.Summary || !reflect.DeepEqual(got.Headers, headers) {
+			t.Errorf("request didn't round-trip: %+v", got)
+		}
+		return len(ciphertext)
+	}
+
+	plain := wireSize()
+	compressed := wireSize(relay.WithPayloadCompression(true))
+	if compressed*4 > plain {
+		t.Errorf("compressed payload is %d bytes, want well under the %d uncompressed", compressed, plain)
+	}
+}
+
+func TestExpiredPayloadIsRejected(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithPayloadTTL(50*time.Millisecond))
+	browser := dialBrowser(t, srv, key)
+
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil); !errors.Is(err, crypto.ErrExpired) {
+		t.Errorf("err = %v, want ErrExpired", err)
+	}
+}
+
+func TestPayloadTTLMustNotBeNegative(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.NewClient("ws://relay.invalid", crypto.DeriveTenantID(key), key, relay.WithPayloadTTL(-time.Second)); err == nil {
+		t.Error("NewClient accepted a negative payload TTL")
+	}
+}
+
+// dialRawBrowser attaches a plain WebSocket to the tenant, for reading frames
+// as the relay forwards them
+func dialRawBrowser(t *testing.T, srv *relaytest.Server, key []byte) *websocket.Conn {
+	t.Helper()
+	tenantID := crypto.DeriveTenantID(key)
+	before := srv.Clients(tenantID)
+	dialer := websocket.Dialer{Subprotocols: relay.Subprotocols}
+	conn, _, err := dialer.Dial(srv.WSURL()+"/ws/client/"+tenantID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	waitFor(t, "browser to attach", func() bool { return srv.Clients(tenantID) == before+1 })
+	return conn
+}
+
+// nextCiphertext reads conn up to the next DATA frame and returns its verified
+// ciphertext
+func nextCiphertext(t *testing.T, conn *websocket.Conn, key []byte) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frameType, payload, err := relay.DecodeFrame(message)
+		if err != nil || frameType != relay.FrameData {
+			continue
+		}
+		macKey, _ := crypto.DeriveSubkey(key, relay.MACSubkeyPurpose, 32)
+		_, ciphertext, err := relay.DecodeData(macKey, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ciphertext
+	}
+}
@@ -0,0 +1,83 @@
+package relay_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestCloseResolvesWaiters(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, _ := newClient(t, srv)
+	var buffered atomic.Int32
+	c.SetDeliveryHandler(func(status relay.DeliveryStatus) {
+		if status.Buffered {
+			buffered.Add(1)
+		}
+	})
+
+	// No browser is connected, so the relay buffers the requests and they wait
+	const waiters = 5
+	results := make(chan error, waiters)
+	for i := range waiters {
+		id := fmt.Sprintf("req-%d", i)
+		go func() {
+			_, err := c.SendRequestAndWait(ctxWithTimeout(t, 10*time.Second), id, relay.AuthRequest{ID: id})
+			results <- err
+		}()
+	}
+	waitFor(t, "requests to be buffered", func() bool {
+		return buffered.Load() == waiters
+	})
+
+	c.Close()
+	for range waiters {
+		select {
+		case err := <-results:
+			if !errors.Is(err, relay.ErrClientClosed) {
+				t.Errorf("waiter returned %v, want ErrClientClosed", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("waiter still blocked after Close")
+		}
+	}
+
+	if _, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "late", relay.AuthRequest{ID: "late"}); !errors.Is(err, relay.ErrClientClosed) {
+		t.Errorf("SendRequestAndWait after Close = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestCloseRacingDecision(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.SendRequestAndWait(ctxWithTimeout(t, 5*time.Second), "req-1", relay.AuthRequest{ID: "req-1"})
+		result <- err
+	}()
+	req, err := browser.Next(ctxWithTimeout(t, time.Second), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go browser.Decide(req, true)
+	c.Close()
+
+	// Whichever lands first resolves the waiter; neither may leave it hanging
+	select {
+	case err := <-result:
+		if err != nil && !errors.Is(err, relay.ErrClientClosed) {
+			t.Errorf("waiter returned %v, want a decision or ErrClientClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter still blocked after Close")
+	}
+}
@@ -0,0 +1,79 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithCompression offers permessage-deflate when connecting. Messages are
+// compressed only if the relay accepts the extension; otherwise they are sent
+// as before. DATA payloads are ciphertext, which deflate can't shrink, so the
+// saving is limited to framing and control messages (see
+// WithPayloadCompression); with debug logging on, the bytes each message took
+// on the wire are logged against its size.
+func WithCompression(enabled bool) Option {
+	return func(c *Client) {
+		c.compression = enabled
+	}
+}
+
+// WithPayloadCompression deflates each request before it is encrypted, which
+// shrinks large header dumps that permessage-deflate can't once they are
+// ciphertext. A request that doesn't get smaller is sent uncompressed, and the
+// envelope flags tell the approval page which it received.
+func WithPayloadCompression(enabled bool) Option {
+	return func(c *Client) {
+		c.payloadCompression = enabled
+	}
+}
+
+// countingConn counts the bytes written to a network connection
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// offerCompression has dialer offer permessage-deflate and returns the counter
+// of the connection it dials, set once dialed
+func (c *Client) offerCompression(dialer *websocket.Dialer) func() *countingConn {
+	var counted *countingConn
+	var netDialer net.Dialer
+	dialer.EnableCompression = true
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := netDialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		counted = &countingConn{Conn: conn}
+		return counted, nil
+	}
+	return func() *countingConn { return counted }
+}
+
+// compressionAccepted reports whether the relay accepted permessage-deflate
+func compressionAccepted(resp *http.Response) bool {
+	return resp != nil && strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
+
+// logCompression logs how many bytes a message of size bytes took on the wire
+// since wire had written before, at debug level
+func logCompression(wire *countingConn, before int64, requestID string, size int) {
+	if wire == nil || size == 0 || !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	written := wire.written.Load() - before
+	slog.Debug("Sent compressed message to relay", "requestID", requestID, "bytes", size, "wireBytes", written,
+		"ratio", float64(written)/float64(size))
+}
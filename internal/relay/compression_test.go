@@ -0,0 +1,73 @@
+package relay_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// compressionLogs counts the messages the client logs as sent compressed
+// until the test ends
+type compressionLogs struct {
+	slog.Handler
+	mu   sync.Mutex
+	sent int
+}
+
+func (h *compressionLogs) Handle(ctx context.Context, record slog.Record) error {
+	if record.Message != "Sent compressed message to relay" {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sent++
+	return nil
+}
+
+func captureCompression(t *testing.T) *compressionLogs {
+	logs := &compressionLogs{Handler: slog.NewTextHandler(nil, &slog.HandlerOptions{Level: slog.LevelDebug})}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(logs))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return logs
+}
+
+func TestCompression(t *testing.T) {
+	path := "/" + strings.Repeat("compressible/", 2000)
+	for _, relayCompresses := range []bool{true, false} {
+		logs := captureCompression(t)
+		cfg := server.DefaultConfig()
+		cfg.Compression = relayCompresses
+		srv := relaytest.NewServerWithConfig(cfg)
+		defer srv.Close()
+		c, key := newClient(t, srv, relay.WithCompression(true))
+		browser := dialBrowser(t, srv, key)
+
+		if err := c.SendAuthRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: path}); err != nil {
+			t.Fatalf("SendAuthRequest: %v", err)
+		}
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if req.Path != path {
+			t.Errorf("relay compression %v: path of %d bytes arrived as %d", relayCompresses, len(path), len(req.Path))
+		}
+
+		// Only a connection that negotiated permessage-deflate logs compressed
+		// sends; the ciphertext itself doesn't shrink, so sizes aren't compared
+		logs.mu.Lock()
+		sent := logs.sent
+		logs.mu.Unlock()
+		if want := map[bool]int{true: 1, false: 0}[relayCompresses]; sent != want {
+			t.Errorf("relay compression %v: %d messages sent compressed, want %d", relayCompresses, sent, want)
+		}
+	}
+}
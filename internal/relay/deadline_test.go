@@ -0,0 +1,102 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestPerRequestDeadlines(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	var mu sync.Mutex
+	cancelled := map[string]bool{}
+	requests := make(chan relay.AuthRequest, 3)
+	go func() {
+		for {
+			req, err := browser.Next(context.Background(), func(id string) {
+				mu.Lock()
+				cancelled[id] = true
+				mu.Unlock()
+			})
+			if err != nil {
+				return
+			}
+			requests <- req
+		}
+	}()
+
+	type result struct {
+		approved bool
+		err      error
+	}
+	send := func(ctx context.Context, id string) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			approved, err := c.SendRequestAndWait(ctx, id, relay.AuthRequest{ID: id, Method: "GET", Path: "/"})
+			done <- result{approved, err}
+		}()
+		return done
+	}
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	short := send(ctxWithTimeout(t, 200*time.Millisecond), "short")
+	cancelledReq := send(cancelCtx, "cancelled")
+	long := send(ctxWithTimeout(t, 5*time.Second), "long")
+
+	seen := map[string]relay.AuthRequest{}
+	for len(seen) < 3 {
+		select {
+		case req := <-requests:
+			seen[req.ID] = req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("browser saw %d of 3 requests", len(seen))
+		}
+	}
+
+	cancel()
+	if r := <-cancelledReq; !errors.Is(r.err, context.Canceled) {
+		t.Errorf("cancelled request err = %v, want context.Canceled", r.err)
+	}
+	if r := <-short; !errors.Is(r.err, relay.ErrTimeout) {
+		t.Errorf("short request err = %v, want ErrTimeout", r.err)
+	}
+	waitFor(t, "cancel frames", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return cancelled["short"] && cancelled["cancelled"]
+	})
+	mu.Lock()
+	if cancelled["long"] {
+		t.Error("long request was cancelled along with the others")
+	}
+	mu.Unlock()
+
+	// Late decisions for the expired requests must not resolve the live one
+	browser.Decide(seen["short"], false)
+	browser.Decide(seen["cancelled"], false)
+	select {
+	case r := <-long:
+		t.Fatalf("long request resolved early: %v, %v", r.approved, r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	browser.Decide(seen["long"], true)
+	select {
+	case r := <-long:
+		if r.err != nil || !r.approved {
+			t.Errorf("long request = %v, %v, want approved", r.approved, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("long request did not resolve")
+	}
+}
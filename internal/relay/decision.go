@@ -0,0 +1,127 @@
+package relay
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// MaxBatchSize is the most decisions one batched decision message may carry
+const MaxBatchSize = 100
+
+// decisionMessage is the decrypted payload of a browser's DATA frame: a single
+// decision, or a batch answering several pending requests at once
+type decisionMessage struct {
+	RequestID string `json:"requestId"`
+	Approved  bool   `json:"approved"`
+	// Approver identifies the browser that decided, for quorums
+	Approver string `json:"approver"`
+	Nonce    string `json:"nonce"`
+	// Headers are added to the response of an approved request; batches
+	// carry none
+	Headers map[string]string `json:"headers,omitempty"`
+	// Decisions is set instead of RequestID for a batch
+	Decisions []batchedDecision `json:"decisions,omitempty"`
+}
+
+// batchedDecision is one request's entry in a batch
+type batchedDecision struct {
+	RequestID string `json:"requestId"`
+	Approved  bool   `json:"approved"`
+	Nonce     string `json:"nonce"`
+}
+
+// handleDecision applies a decrypted decision message from the browser
+func (c *Client) handleDecision(header RoutingHeader, plaintext []byte) {
+	var msg decisionMessage
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		slog.Error("Failed to unmarshal decision", "error", err)
+		return
+	}
+
+	if len(msg.Decisions) == 0 {
+		if header.RequestID != "" && header.RequestID != msg.RequestID {
+			slog.Error("Decision does not match its routing header", "requestID", msg.RequestID, "headerRequestID", header.RequestID)
+			return
+		}
+		if !c.applyDecision(msg.RequestID, msg.Approved, msg.Approver, msg.Nonce, msg.Headers) {
+			slog.Warn("Rejecting decision for a request not sent or without its nonce", "requestID", msg.RequestID)
+		}
+		return
+	}
+
+	// Each entry is checked like a single decision; rejected ones are logged
+	if len(msg.Decisions) > MaxBatchSize {
+		slog.Error("Batched decision is too large", "decisions", len(msg.Decisions), "limit", MaxBatchSize)
+		return
+	}
+	var rejected []string
+	for _, d := range msg.Decisions {
+		if !c.applyDecision(d.RequestID, d.Approved, msg.Approver, d.Nonce, nil) {
+			rejected = append(rejected, d.RequestID)
+		}
+	}
+	if len(rejected) > 0 {
+		slog.Warn("Batched decision names requests not sent or without their nonce", "requestIDs", rejected)
+	}
+}
+
+// applyDecision checks one decision against the nonce its request was sent
+// with, reporting false if it doesn't match. Only a matching decision may
+// count towards a quorum; unless it still awaits one or answers an already
+// decided request, it resolves the waiting SendRequestAndWait and is reported
+// to the handler, channel and webhook.
+func (c *Client) applyDecision(requestID string, approved bool, approver, nonce string, headers map[string]string) bool {
+	if !c.verifyNonce(requestID, nonce) {
+		return false
+	}
+
+	if c.quorum > 1 {
+		outcome, decided := c.vote(requestID, approver, approved)
+		if !decided {
+			return true
+		}
+		approved = outcome
+	}
+
+	if !c.firstDecision(requestID) {
+		slog.Debug("Ignoring decision for an already resolved request", "requestID", requestID)
+		return true
+	}
+
+	// Wake a waiting SendRequestAndWait and call handler
+	c.mu.RLock()
+	waiter := c.waiters[requestID]
+	handler := c.decisionHandler
+	webhook := c.webhook
+	if c.decisions != nil && !c.closed {
+		select {
+		case c.decisions <- Decision{RequestID: requestID, Approved: approved, Headers: headers}:
+		default:
+			slog.Warn("Decision channel full, dropping decision", "requestID", requestID)
+		}
+	}
+	c.mu.RUnlock()
+
+	if webhook != nil {
+		event := WebhookEvent{
+			RequestID: requestID,
+			Approved:  approved,
+			TenantID:  c.tenantID,
+			Timestamp: time.Now(),
+		}
+		go func() {
+			if err := webhook.Send(event); err != nil {
+				slog.Error("Failed to deliver decision webhook", "requestID", event.RequestID, "error", err)
+			}
+		}()
+	}
+
+	if waiter != nil {
+		waiter.resolve(waitResult{approved: approved, headers: headers})
+	}
+	if handler != nil {
+		handler(requestID, approved)
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package relay_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestDecisionsChannel(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+	decisions := c.Decisions()
+
+	go func() {
+		for _, approved := range []bool{true, false} {
+			req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+			if err == nil {
+				browser.Decide(req, approved)
+			}
+		}
+	}()
+	for _, id := range []string{"req-1", "req-2"} {
+		if _, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), id, relay.AuthRequest{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []relay.Decision{{RequestID: "req-1", Approved: true}, {RequestID: "req-2", Approved: false}} {
+		select {
+		case got := <-decisions:
+			if got.RequestID != want.RequestID || got.Approved != want.Approved {
+				t.Errorf("decision = %+v, want %+v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no decision for %s on the channel", want.RequestID)
+		}
+	}
+
+	c.Close()
+	if _, ok := <-decisions; ok {
+		t.Error("channel still open after Close")
+	}
+	if _, ok := <-c.Decisions(); ok {
+		t.Error("Decisions after Close returned an open channel")
+	}
+}
+
+func TestDecisionsChannelDropsWhenFull(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+	decisions := c.Decisions()
+
+	// Nobody reads the channel; the handler still sees every decision
+	var ids []string
+	for i := range relay.DecisionBufferSize + 5 {
+		ids = append(ids, fmt.Sprintf("req-%d", i))
+	}
+	if got := handledDecisions(t, c, browser, ids...); len(got) != len(ids) {
+		t.Fatalf("handler saw %d decisions, want %d", len(got), len(ids))
+	}
+	if len(decisions) != relay.DecisionBufferSize {
+		t.Errorf("channel holds %d decisions, want it full at %d", len(decisions), relay.DecisionBufferSize)
+	}
+	if first := <-decisions; first.RequestID != "req-0" {
+		t.Errorf("first buffered decision = %s, want the oldest kept", first.RequestID)
+	}
+}
+
+func TestDecisionCarriesApproverHeaders(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	go func() {
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err == nil {
+			browser.ApproveWithHeaders(req, map[string]string{"x-approved-by": "alice"})
+		}
+	}()
+	decision, err := c.SendRequestAndWaitDecision(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Approved || decision.Headers["x-approved-by"] != "alice" {
+		t.Errorf("decision = %+v, want approved with the approver's header", decision)
+	}
+}
@@ -0,0 +1,153 @@
+package relay_test
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// handledDecisions sends a request for each of ids, has browser decide them in
+// order, then returns the request IDs c's decision handler was called with
+func handledDecisions(t *testing.T, c *relay.Client, browser *relaytest.Browser, ids ...string) []string {
+	t.Helper()
+	sent := make(map[string]relay.AuthRequest)
+	var reqs []relay.AuthRequest
+	for _, id := range ids {
+		if _, ok := sent[id]; !ok {
+			sent[id] = sendAndShow(t, c, browser, id)
+		}
+		reqs = append(reqs, sent[id])
+	}
+	return handledAnswers(t, c, browser, reqs...)
+}
+
+// sendAndShow sends a request for id and returns it as browser received it,
+// nonce included
+func sendAndShow(t *testing.T, c *relay.Client, browser *relaytest.Browser, id string) relay.AuthRequest {
+	t.Helper()
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+	if err != nil || req.ID != id {
+		t.Fatalf("Next = %s, %v, want %s", req.ID, err, id)
+	}
+	return req
+}
+
+// handledAnswers has browser approve reqs in order, then returns the request
+// IDs c's decision handler was called with
+func handledAnswers(t *testing.T, c *relay.Client, browser *relaytest.Browser, reqs ...relay.AuthRequest) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var handled []string
+	done := make(chan struct{})
+	c.SetDecisionHandler(func(requestID string, approved bool) {
+		if requestID == "done" {
+			close(done)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, requestID)
+	})
+
+	// Decisions from one browser arrive in order, so the last marks the end
+	for _, req := range append(reqs, sendAndShow(t, c, browser, "done")) {
+		if err := browser.Decide(req, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("decisions not handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return handled
+}
+
+func TestDuplicateDecisionsIgnored(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	got := handledDecisions(t, c, browser, "req-1", "req-1", "req-2", "req-1")
+	if !slices.Equal(got, []string{"req-1", "req-2"}) {
+		t.Errorf("handler called for %v, want each request once", got)
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithDedupeWindow(1))
+	browser := dialBrowser(t, srv, key)
+
+	// req-2 pushes req-1 out of a one-entry window
+	got := handledDecisions(t, c, browser, "req-1", "req-1", "req-2", "req-1")
+	if !slices.Equal(got, []string{"req-1", "req-2", "req-1"}) {
+		t.Errorf("handler called for %v, want req-1 again once it left the window", got)
+	}
+}
+
+func TestDecisionFromTwoBrowsersResolvesOnce(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browsers := []*relaytest.Browser{dialBrowser(t, srv, key), dialBrowser(t, srv, key)}
+
+	var mu sync.Mutex
+	var handled []string
+	c.SetDecisionHandler(func(requestID string, approved bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, requestID)
+	})
+	// Both browsers answer, then mark the end of their decisions
+	for i, browser := range browsers {
+		go func() {
+			req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+			if err != nil {
+				return
+			}
+			browser.Decide(req, true)
+			for {
+				marker, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+				if err != nil {
+					return
+				}
+				if marker.ID == fmt.Sprintf("done-%d", i) {
+					browser.Decide(marker, true)
+					return
+				}
+			}
+		}()
+	}
+	if approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1"}); err != nil || !approved {
+		t.Fatalf("approved=%v err=%v", approved, err)
+	}
+	for i := range browsers {
+		if err := c.SendAuthRequest(relay.AuthRequest{ID: fmt.Sprintf("done-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "both browsers' decisions", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Contains(handled, "done-0") && slices.Contains(handled, "done-1")
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if n := len(slices.DeleteFunc(slices.Clone(handled), func(id string) bool { return id != "req-1" })); n != 1 {
+		t.Errorf("handler called %d times for req-1, want once", n)
+	}
+}
@@ -0,0 +1,52 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestSendRequestAndWaitNoApprover(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.BufferSize = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, _ := newClient(t, srv)
+
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-1", relay.AuthRequest{ID: "req-1"})
+	if !errors.Is(err, relay.ErrNoApprover) {
+		t.Fatalf("err = %v, want ErrNoApprover", err)
+	}
+}
+
+func TestSendRequestAndWaitRateLimited(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0.001
+	cfg.RateBurst = 1
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err == nil {
+			browser.Decide(req, true)
+		}
+	}()
+	if approved, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-1", relay.AuthRequest{ID: "req-1"}); err != nil || !approved {
+		t.Fatalf("first request: approved=%v err=%v", approved, err)
+	}
+
+	// The approver is still connected; the second request is dropped by the
+	// rate limit and must not look like a missing approver
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-2", relay.AuthRequest{ID: "req-2"})
+	if !errors.Is(err, relay.ErrRateLimited) || errors.Is(err, relay.ErrNoApprover) {
+		t.Fatalf("err = %v, want ErrRateLimited only", err)
+	}
+}
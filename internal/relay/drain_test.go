@@ -0,0 +1,73 @@
+package relay_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestDrainNoticeDelaysReconnect(t *testing.T) {
+	const retryAfter = 2
+	drained := make(chan time.Time, 1)
+	redialed := make(chan time.Time, 1)
+	upgrader := websocket.Upgrader{Subprotocols: relay.Subprotocols}
+	var dials atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if dials.Add(1) > 1 {
+			redialed <- time.Now()
+			conn.ReadMessage()
+			return
+		}
+		// Wait for the request, then drain the connection it was sent on
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		notice, _ := json.Marshal(relay.ControlFrame{Type: relay.ControlTypeDrain, RetryAfter: retryAfter})
+		conn.WriteMessage(websocket.BinaryMessage, relay.EncodeFrame(relay.FrameControl, notice))
+		drained <- time.Now()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "draining"), time.Now().Add(time.Second))
+	}))
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key, relay.WithResendOnReconnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	go c.SendRequestAndWait(ctxWithTimeout(t, 5*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+
+	var drainedAt, redialedAt time.Time
+	select {
+	case drainedAt = <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request never reached the relay")
+	}
+	select {
+	case redialedAt = <-redialed:
+	case <-time.After(2 * retryAfter * time.Second):
+		t.Fatal("client never reconnected")
+	}
+	if wait := redialedAt.Sub(drainedAt); wait < retryAfter*time.Second-200*time.Millisecond {
+		t.Errorf("reconnected %v after the drain notice, want about %ds", wait, retryAfter)
+	}
+}
@@ -0,0 +1,73 @@
+package relay_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestRapidReconnectKeepsLiveConnection(t *testing.T) {
+	const reconnects = 25
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	// Sends race the reconnects; they may fail, but must not corrupt the client
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.SendAuthRequest(relay.AuthRequest{ID: fmt.Sprintf("noise-%d", i)})
+		}
+	}()
+	start := c.Epoch()
+	for i := range reconnects {
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect %d: %v", i, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if got := c.Epoch(); got < start+reconnects {
+		t.Fatalf("Epoch = %d after %d reconnects from %d", got, reconnects, start)
+	}
+
+	// Give the replaced connections' readers time to see they are stale. If one
+	// closed or cleared the live connection, the next send would have to redial.
+	time.Sleep(100 * time.Millisecond)
+	live := c.Epoch()
+	go func() {
+		for {
+			req, err := browser.Next(context.Background(), nil)
+			if err != nil {
+				return
+			}
+			if req.ID == "req-1" {
+				browser.Decide(req, true)
+			}
+		}
+	}()
+	approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if err != nil || !approved {
+		t.Fatalf("after reconnects: %v, %v, want approved", approved, err)
+	}
+	if c.Epoch() != live {
+		t.Errorf("epoch moved from %d to %d: a stale reader broke the live connection", live, c.Epoch())
+	}
+}
@@ -0,0 +1,31 @@
+package relay
+
+import "errors"
+
+// Errors returned by the Client, wrapped with detail where there is any, so
+// callers can tell failures apart with errors.Is
+var (
+	// ErrNotConnected means a message couldn't be written because the client
+	// has no connection to the relay and couldn't re-establish one
+	ErrNotConnected = errors.New("not connected to relay")
+	// ErrClientClosed is returned for sends and waits on a closed client
+	ErrClientClosed = errors.New("relay client closed")
+	// ErrTimeout is returned by SendRequestAndWait when its context's deadline
+	// passes before a decision arrives; it also matches context.DeadlineExceeded
+	ErrTimeout = errors.New("timed out waiting for decision")
+	// ErrNoApprover is returned by SendRequestAndWait when the relay reports that no
+	// browser was connected to receive the request
+	ErrNoApprover = errors.New("no approver connected")
+	// ErrRateLimited is returned by SendRequestAndWait when the relay dropped the
+	// request for exceeding the tenant's rate limit. It says nothing about
+	// whether an approver is connected.
+	ErrRateLimited = errors.New("request dropped by relay rate limit")
+	// ErrConnectionLost is returned by SendRequestAndWait under ReconnectFail when
+	// the connection its request was sent on is replaced
+	ErrConnectionLost = errors.New("relay connection lost while awaiting decision")
+	// ErrEncrypt means a request couldn't be encrypted
+	ErrEncrypt = errors.New("failed to encrypt request")
+	// ErrDecrypt means a message couldn't be authenticated or decrypted with
+	// the client's key
+	ErrDecrypt = errors.New("failed to decrypt message")
+)
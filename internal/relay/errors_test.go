@@ -0,0 +1,86 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestErrorsIs(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	request := relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}
+
+	t.Run("not connected", func(t *testing.T) {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := relay.NewClient(srv.WSURL(), crypto.DeriveTenantID(key), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.SendRequest(request); !errors.Is(err, relay.ErrNotConnected) {
+			t.Errorf("SendRequest before Connect: %v, want ErrNotConnected", err)
+		}
+	})
+
+	t.Run("client closed", func(t *testing.T) {
+		c, _ := newClient(t, srv)
+		c.Close()
+		if err := c.SendRequest(request); !errors.Is(err, relay.ErrClientClosed) {
+			t.Errorf("SendRequest: %v, want ErrClientClosed", err)
+		}
+		if _, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-1", request); !errors.Is(err, relay.ErrClientClosed) {
+			t.Errorf("SendRequestAndWait: %v, want ErrClientClosed", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c, key := newClient(t, srv)
+		dialBrowser(t, srv, key)
+		_, err := c.SendRequestAndWait(ctxWithTimeout(t, 100*time.Millisecond), "req-1", request)
+		if !errors.Is(err, relay.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want ErrTimeout matching context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("encrypt", func(t *testing.T) {
+		c, _ := newClient(t, srv, relay.WithMaxPlaintextSize(64))
+		big := relay.AuthRequest{ID: "req-1", Path: "/" + strings.Repeat("a", 64)}
+		if err := c.SendRequest(big); !errors.Is(err, relay.ErrEncrypt) {
+			t.Errorf("SendRequest: %v, want ErrEncrypt", err)
+		}
+		if _, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-1", big); !errors.Is(err, relay.ErrEncrypt) {
+			t.Errorf("SendRequestAndWait: %v, want ErrEncrypt", err)
+		}
+	})
+
+	t.Run("decrypt", func(t *testing.T) {
+		c, key := newClient(t, srv)
+		wrongKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tenantID := crypto.DeriveTenantID(key)
+		browser, err := srv.DialBrowser(tenantID, wrongKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer browser.Close()
+		waitFor(t, "browser to attach", func() bool { return srv.Clients(tenantID) == 1 })
+		if err := c.SendRequest(request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := browser.Next(ctxWithTimeout(t, time.Second), nil); !errors.Is(err, relay.ErrDecrypt) {
+			t.Errorf("Next with the wrong key: %v, want ErrDecrypt", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// Every message on the relay wire is a binary WebSocket message whose first
+// byte is the frame type. DATA frames carry the end-to-end encrypted payload
+// and are forwarded untouched; the other types are unencrypted and handled by
+// whichever hop receives them.
+
+// FrameType identifies the kind of frame
+type FrameType byte
+
+const (
+	// FrameData carries a signed routing header and an encrypted payload between
+	// server and browser
+	FrameData FrameType = 1
+	// FrameAck is a ControlFrame the relay sends back for each DATA frame from the server
+	FrameAck FrameType = 2
+	// FramePing is an application-level heartbeat with an empty payload
+	FramePing FrameType = 3
+	// FrameControl is a ControlFrame handled locally by the receiver
+	FrameControl FrameType = 4
+	// FrameChunk carries part of a larger frame and is forwarded untouched like
+	// DATA (see SplitFrame)
+	FrameChunk FrameType = 5
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameData:
+		return "DATA"
+	case FrameAck:
+		return "ACK"
+	case FramePing:
+		return "PING"
+	case FrameControl:
+		return "CONTROL"
+	case FrameChunk:
+		return "CHUNK"
+	default:
+		return fmt.Sprintf("FrameType(%d)", byte(t))
+	}
+}
+
+// Forwarded reports whether the relay passes frames of this type between server
+// and browser rather than handling them itself
+func (t FrameType) Forwarded() bool {
+	return t == FrameData || t == FrameChunk
+}
+
+// EncodeFrame prefixes payload with its frame type
+func EncodeFrame(t FrameType, payload []byte) []byte {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = byte(t)
+	copy(frame[1:], payload)
+	return frame
+}
+
+// DecodeFrame splits a frame into its type and payload
+func DecodeFrame(frame []byte) (FrameType, []byte, error) {
+	if len(frame) == 0 {
+		return 0, nil, fmt.Errorf("empty frame")
+	}
+	t := FrameType(frame[0])
+	if t < FrameData || t > FrameChunk {
+		return 0, nil, fmt.Errorf("unknown frame type %d", frame[0])
+	}
+	return t, frame[1:], nil
+}
+
+// MACSubkeyPurpose is the DeriveSubkey purpose for the key that signs routing headers
+const MACSubkeyPurpose = "mac"
+
+// EncSubkeyPurpose is the DeriveSubkey purpose for the key that encrypts DATA
+// frame payloads, so the pairing key itself never encrypts anything
+const EncSubkeyPurpose = "enc"
+
+// RoutingHeader is unencrypted metadata carried by DATA frames so the relay can
+// correlate a message without decrypting it. It must never hold sensitive data.
+type RoutingHeader struct {
+	RequestID string `json:"rid,omitempty"`
+}
+
+// EncodeData builds a DATA frame: the routing header, then the ciphertext,
+// signed together with macKey (see crypto.PackSigned)
+func EncodeData(macKey []byte, header RoutingHeader, ciphertext []byte) ([]byte, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal routing header: %w", err)
+	}
+	payload, err := crypto.PackSigned(macKey, headerJSON, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeFrame(FrameData, payload), nil
+}
+
+// DecodeData verifies a DATA frame payload's routing header and ciphertext with
+// macKey and returns them
+func DecodeData(macKey, payload []byte) (RoutingHeader, []byte, error) {
+	var header RoutingHeader
+	headerJSON, ciphertext, err := crypto.UnpackSigned(macKey, payload)
+	if err != nil {
+		return header, nil, err
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return header, nil, fmt.Errorf("failed to unmarshal routing header: %w", err)
+	}
+	return header, ciphertext, nil
+}
+
+// PeekRoutingHeader reads a DATA frame payload's routing header without
+// verifying it; the relay, which has no key, uses it for logging only
+func PeekRoutingHeader(payload []byte) (RoutingHeader, error) {
+	var header RoutingHeader
+	headerJSON, _, _, err := crypto.ParseSigned(payload)
+	if err != nil {
+		return header, err
+	}
+	err = json.Unmarshal(headerJSON, &header)
+	return header, err
+}
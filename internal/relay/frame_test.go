@@ -0,0 +1,88 @@
+package relay_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		frameType relay.FrameType
+		name      string
+		forwarded bool
+	}{
+		{relay.FrameData, "DATA", true},
+		{relay.FrameAck, "ACK", false},
+		{relay.FramePing, "PING", false},
+		{relay.FrameControl, "CONTROL", false},
+		{relay.FrameChunk, "CHUNK", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := []byte(`{"type":"x"}`)
+			frame := relay.EncodeFrame(tt.frameType, payload)
+			if frame[0] != byte(tt.frameType) {
+				t.Errorf("first byte = %d, want %d", frame[0], tt.frameType)
+			}
+			frameType, got, err := relay.DecodeFrame(frame)
+			if err != nil {
+				t.Fatalf("DecodeFrame: %v", err)
+			}
+			if frameType != tt.frameType || !bytes.Equal(got, payload) {
+				t.Errorf("DecodeFrame = %v, %q, want %v, %q", frameType, got, tt.frameType, payload)
+			}
+			if frameType.String() != tt.name {
+				t.Errorf("String() = %q, want %q", frameType.String(), tt.name)
+			}
+			if frameType.Forwarded() != tt.forwarded {
+				t.Errorf("Forwarded() = %v, want %v", frameType.Forwarded(), tt.forwarded)
+			}
+		})
+	}
+}
+
+func TestFrameEmptyPayload(t *testing.T) {
+	frameType, payload, err := relay.DecodeFrame(relay.EncodeFrame(relay.FramePing, nil))
+	if err != nil || frameType != relay.FramePing || len(payload) != 0 {
+		t.Errorf("DecodeFrame = %v, %q, %v, want an empty PING", frameType, payload, err)
+	}
+}
+
+func TestDecodeFrameRejectsInvalid(t *testing.T) {
+	for _, frame := range [][]byte{nil, {0}, {6, 'x'}, {0xff}} {
+		if _, _, err := relay.DecodeFrame(frame); err == nil {
+			t.Errorf("DecodeFrame(%v) succeeded, want an error", frame)
+		}
+	}
+}
+
+func TestDataFrameRoundTrip(t *testing.T) {
+	macKey := bytes.Repeat([]byte{7}, 32)
+	header := relay.RoutingHeader{RequestID: "req-1"}
+	frame, err := relay.EncodeData(macKey, header, []byte("ciphertext"))
+	if err != nil {
+		t.Fatalf("EncodeData: %v", err)
+	}
+	frameType, payload, err := relay.DecodeFrame(frame)
+	if err != nil || frameType != relay.FrameData {
+		t.Fatalf("DecodeFrame = %v, %v, want DATA", frameType, err)
+	}
+
+	peeked, err := relay.PeekRoutingHeader(payload)
+	if err != nil || peeked != header {
+		t.Errorf("PeekRoutingHeader = %+v, %v, want %+v", peeked, err, header)
+	}
+	got, ciphertext, err := relay.DecodeData(macKey, payload)
+	if err != nil {
+		t.Fatalf("DecodeData: %v", err)
+	}
+	if got != header || string(ciphertext) != "ciphertext" {
+		t.Errorf("DecodeData = %+v, %q, want %+v, %q", got, ciphertext, header, "ciphertext")
+	}
+
+	if _, _, err := relay.DecodeData(bytes.Repeat([]byte{8}, 32), payload); err == nil {
+		t.Error("DecodeData with the wrong MAC key succeeded")
+	}
+}
@@ -0,0 +1,64 @@
+package relay_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestDecisionHandlerSurvivesReconnect(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithResendOnReconnect(true))
+	browser := dialBrowser(t, srv, key)
+	handled := make(chan string, 1)
+	c.SetDecisionHandler(func(requestID string, approved bool) {
+		if approved {
+			handled <- requestID
+		}
+	})
+
+	for i := range 4 {
+		id := fmt.Sprintf("req-%d", i)
+		epoch := c.Epoch()
+		// Alternate a deliberate reconnect with the relay dropping the
+		// connection while the request is in flight, which resends it
+		if i%2 == 0 {
+			if err := c.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+		}
+		done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), id)
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if i%2 == 1 {
+			if !srv.DropServer(crypto.DeriveTenantID(key)) {
+				t.Fatal("server not connected")
+			}
+			if req, err = browser.Next(ctxWithTimeout(t, 4*time.Second), nil); err != nil {
+				t.Fatalf("%s not resent: %v", id, err)
+			}
+		}
+		if c.Epoch() == epoch {
+			t.Fatalf("epoch %d unchanged by reconnect %d", epoch, i)
+		}
+		browser.Decide(req, true)
+		select {
+		case got := <-handled:
+			if got != id {
+				t.Errorf("handler saw %s, want %s", got, id)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("handler not called for %s after reconnect %d", id, i)
+		}
+		if err := resolved(t, done); err != nil {
+			t.Errorf("%s: %v, want approved", id, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package relay_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// serverAttached reports whether the relay holds a server connection for key's tenant
+func serverAttached(srv *relaytest.Server, key []byte) bool {
+	attached, _ := srv.Relay.TenantStatus(crypto.DeriveTenantID(key))
+	return attached
+}
+
+func TestIdleTimeoutClosesAndRedials(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithIdleTimeout(100*time.Millisecond))
+	browser := dialBrowser(t, srv, key)
+	epoch := c.Epoch()
+
+	waitFor(t, "idle connection to close", func() bool { return !serverAttached(srv, key) })
+
+	if err := c.SendRequest(relay.AuthRequest{ID: "req-1"}); err != nil {
+		t.Fatalf("SendRequest after the idle close: %v", err)
+	}
+	if c.Epoch() == epoch {
+		t.Error("send didn't re-dial")
+	}
+	if req, err := browser.Next(ctxWithTimeout(t, time.Second), nil); err != nil || req.ID != "req-1" {
+		t.Fatalf("Next = %+v, %v", req, err)
+	}
+}
+
+func TestIdleTimeoutWaitsForDecision(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithIdleTimeout(100*time.Millisecond))
+	browser := dialBrowser(t, srv, key)
+
+	// The approver takes several idle periods to answer
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err == nil {
+			time.Sleep(400 * time.Millisecond)
+			browser.Decide(req, true)
+		}
+	}()
+	approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1"})
+	if err != nil || !approved {
+		t.Fatalf("approved=%v err=%v, want the decision despite the idle timeout", approved, err)
+	}
+}
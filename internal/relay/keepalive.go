@@ -0,0 +1,145 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Ping payloads tell keepalive pongs apart from latency probe pongs
+const (
+	keepalivePayload   = "keepalive"
+	probePayloadPrefix = "probe-"
+)
+
+// WithPingInterval pings the relay every d and drops the connection if no
+// keepalive pong arrives for two intervals; the next send re-dials. Zero, the
+// default, leaves keepalive to the relay's own pings.
+func WithPingInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.pingInterval = d
+	}
+}
+
+// WithHeartbeat sends a PING frame every d while connected, so a relay with a
+// heartbeat timeout keeps the tenant's connections open even when no requests
+// are flowing. WebSocket pings don't count towards that timeout. Zero, the
+// default, sends none.
+func WithHeartbeat(d time.Duration) Option {
+	return func(c *Client) {
+		c.heartbeat = d
+	}
+}
+
+// LatencyProbe measures the round trip to the relay with a ping carrying its
+// own payload, so it doesn't disturb keepalive pong tracking. Like every other
+// write, the ping goes through the client's single writer.
+func (c *Client) LatencyProbe(ctx context.Context) (time.Duration, error) {
+	pong := make(chan struct{}, 1)
+
+	c.mu.Lock()
+	c.probeSeq++
+	payload := fmt.Sprintf("%s%d", probePayloadPrefix, c.probeSeq)
+	c.probes[payload] = pong
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.probes, payload)
+		c.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := c.enqueue(&outbound{messageType: websocket.PingMessage, data: []byte(payload), bestEffort: true}); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.done:
+		return 0, ErrClientClosed
+	}
+}
+
+// handlePong routes a pong to keepalive tracking or the waiting probe
+func (c *Client) handlePong(appData string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if appData == keepalivePayload {
+		c.lastPong = time.Now()
+		return nil
+	}
+	if pong, ok := c.probes[appData]; ok {
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// keepalive pings the relay every pingInterval and drops an unresponsive connection
+func (c *Client) keepalive() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		conn := c.conn
+		if conn != nil && time.Since(c.lastPong) > 2*c.pingInterval {
+			c.conn = nil
+			c.acks = nil
+			c.redial = true
+			c.mu.Unlock()
+
+			slog.Warn("Relay stopped answering pings, dropping connection", "pingInterval", c.pingInterval)
+			conn.Close()
+			continue
+		}
+		c.mu.Unlock()
+
+		if conn == nil {
+			continue
+		}
+		if err := c.enqueue(&outbound{messageType: websocket.PingMessage, data: []byte(keepalivePayload), bestEffort: true}); err != nil {
+			slog.Debug("Failed to ping relay", "error", err)
+		}
+	}
+}
+
+// sendHeartbeats sends a PING frame every heartbeat interval while connected
+func (c *Client) sendHeartbeats() {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		c.mu.RLock()
+		connected := c.conn != nil
+		c.mu.RUnlock()
+		if !connected {
+			continue
+		}
+		if err := c.enqueue(&outbound{messageType: websocket.BinaryMessage, data: EncodeFrame(FramePing, nil), bestEffort: true}); err != nil {
+			slog.Debug("Failed to send heartbeat to relay", "error", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestKeepaliveAndProbeTogether(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithPingInterval(interval))
+	epoch := c.Epoch()
+
+	// Probe continuously across many keepalive intervals
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for deadline := time.Now().Add(15 * interval); time.Now().Before(deadline); {
+				rtt, err := c.LatencyProbe(ctxWithTimeout(t, time.Second))
+				if err != nil || rtt <= 0 {
+					t.Errorf("LatencyProbe = %v, %v", rtt, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.Epoch() != epoch || !serverAttached(srv, key) {
+		t.Error("keepalive dropped a responsive connection while probes ran")
+	}
+}
+
+func TestKeepaliveDropsUnresponsiveRelay(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	// A relay that never pongs
+	disconnected := make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: relay.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Read the raw socket, bypassing gorilla's ping handler, until the client hangs up
+		buf := make([]byte, 1)
+		for {
+			conn.UnderlyingConn().SetReadDeadline(time.Now().Add(5 * time.Millisecond))
+			if _, err := conn.UnderlyingConn().Read(buf); err != nil && !isTimeout(err) {
+				close(disconnected)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key, relay.WithPingInterval(interval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.LatencyProbe(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("probe of a relay that never pongs = %v, want a timeout", err)
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client kept a connection that never answered pings")
+	}
+}
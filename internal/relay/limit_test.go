@@ -0,0 +1,66 @@
+package relay_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestClientClosesOnOversizedMessage(t *testing.T) {
+	// A fake relay that sends one message over the client's limit and reports
+	// whether the client hung up
+	closed := make(chan bool, 1)
+	upgrader := websocket.Upgrader{Subprotocols: relay.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte{1}, 2048))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = conn.ReadMessage()
+		select {
+		case closed <- err != nil && !isTimeout(err):
+		default:
+			// A redial after the close; the first connection already reported
+		}
+	}))
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetMaxMessageSize(1024)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	select {
+	case ok := <-closed:
+		if !ok {
+			t.Error("client kept the connection open after an over-limit message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fake relay never finished")
+	}
+}
+
+// isTimeout reports whether err is a read deadline expiring
+func isTimeout(err error) bool {
+	netErr, ok := err.(interface{ Timeout() bool })
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,302 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// RelayMode decides how a MultiClient spreads requests over its relays
+type RelayMode int
+
+const (
+	// RelayFailover sends through one relay at a time, moving on to the next
+	// only when the current one can't be reached
+	RelayFailover RelayMode = iota
+	// RelayActiveActive sends every request through all relays at once; the
+	// first decision from any of them resolves it
+	RelayActiveActive
+)
+
+// ParseRelayMode parses "failover" or "active-active"
+func ParseRelayMode(value string) (RelayMode, error) {
+	switch value {
+	case "failover":
+		return RelayFailover, nil
+	case "active-active":
+		return RelayActiveActive, nil
+	default:
+		return RelayFailover, fmt.Errorf("invalid relay mode %q: must be failover or active-active", value)
+	}
+}
+
+// MultiClient connects the authz server to several relays so that one relay
+// going down doesn't stop approvals. It holds a Client per relay, each created
+// with the same tenant, key and options, and offers the same methods as a
+// Client. Approver pages must be able to reach whichever relay is in use, e.g.
+// by opening the page on every relay.
+//
+// Under RelayFailover only the active relay is used; a send that finds it
+// unreachable, or rejecting the connection, moves on to the next relay in
+// order and stays there. Under RelayActiveActive a request is sent through
+// every relay and the first decision wins: the other relays are sent a cancel
+// so their prompts are dismissed, and the decision handler sees each request
+// once however many relays a decision arrives on.
+type MultiClient struct {
+	clients []*Client
+	mode    RelayMode
+
+	mu sync.Mutex
+	// active is the index of the relay in use under RelayFailover
+	active          int
+	decisionHandler DecisionHandler
+	// seen holds the last DefaultDedupeWindow decided request IDs, oldest first in seenOrder
+	seen      map[string]struct{}
+	seenOrder []string
+}
+
+// NewMultiClient creates a client for the relays at relayURLs, tried in order
+// under RelayFailover
+func NewMultiClient(relayURLs []string, mode RelayMode, tenantID string, encryptionKey []byte, opts ...Option) (*MultiClient, error) {
+	if len(relayURLs) == 0 {
+		return nil, fmt.Errorf("at least one relay URL is required")
+	}
+	if mode < RelayFailover || mode > RelayActiveActive {
+		return nil, fmt.Errorf("invalid relay mode %d", mode)
+	}
+
+	m := &MultiClient{
+		mode: mode,
+		seen: make(map[string]struct{}),
+	}
+	for _, relayURL := range relayURLs {
+		c, err := NewClient(relayURL, tenantID, encryptionKey, opts...)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		c.SetDecisionHandler(m.handleDecision)
+		m.clients = append(m.clients, c)
+	}
+	return m, nil
+}
+
+// SetDecisionHandler sets the handler for authorization decisions from any relay
+func (m *MultiClient) SetDecisionHandler(handler DecisionHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decisionHandler = handler
+}
+
+// SetDeliveryHandler sets the handler for delivery acknowledgements; under
+// RelayActiveActive it sees one for each relay a request is sent through
+func (m *MultiClient) SetDeliveryHandler(handler DeliveryHandler) {
+	for _, c := range m.clients {
+		c.SetDeliveryHandler(handler)
+	}
+}
+
+// SetWebhook posts every decision to webhook in the background
+func (m *MultiClient) SetWebhook(webhook *Webhook) {
+	for _, c := range m.clients {
+		c.SetWebhook(webhook)
+	}
+}
+
+// SetAuthToken sets the bearer token presented to every relay on connect
+func (m *MultiClient) SetAuthToken(token string) {
+	for _, c := range m.clients {
+		c.SetAuthToken(token)
+	}
+}
+
+// Connect connects to every relay under RelayActiveActive, and to the first
+// relay that accepts under RelayFailover. It fails only if no relay could be
+// reached; a relay that couldn't is re-dialed by the next send through it.
+func (m *MultiClient) Connect() error {
+	if m.mode == RelayActiveActive {
+		var errs []error
+		for _, c := range m.clients {
+			if err := c.Connect(); err != nil {
+				slog.Warn("Failed to connect to relay", "relay", c.relayURL, "error", err)
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == len(m.clients) {
+			return errors.Join(errs...)
+		}
+		return nil
+	}
+
+	// failover connects the relay it settles on
+	return m.failover(func(*Client) error {
+		return nil
+	}, unreachable)
+}
+
+// SendRequest sends an auth request, succeeding if any relay accepts it
+func (m *MultiClient) SendRequest(requestData interface{}) error {
+	if m.mode == RelayFailover {
+		return m.failover(func(c *Client) error {
+			return c.SendRequest(requestData)
+		}, unreachable)
+	}
+
+	errs := make(chan error, len(m.clients))
+	for _, c := range m.clients {
+		go func() {
+			errs <- c.SendRequest(requestData)
+		}()
+	}
+	var failed []error
+	for range m.clients {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == len(m.clients) {
+		return errors.Join(failed...)
+	}
+	return nil
+}
+
+// SendRequestAndWait sends an auth request and blocks until an approver on any
+// relay decides on requestID or ctx is done. Under RelayActiveActive it fails
+// only once every relay has failed, with all of their errors joined, so
+// errors.Is matches, e.g., ErrNoApprover if any relay reported it.
+func (m *MultiClient) SendRequestAndWait(ctx context.Context, requestID string, requestData interface{}) (bool, error) {
+	decision, err := m.SendRequestAndWaitDecision(ctx, requestID, requestData)
+	return decision.Approved, err
+}
+
+// SendRequestAndWaitDecision is SendRequestAndWait returning the whole
+// decision, including any headers the approver added
+func (m *MultiClient) SendRequestAndWaitDecision(ctx context.Context, requestID string, requestData interface{}) (Decision, error) {
+	if m.mode == RelayFailover {
+		var decision Decision
+		err := m.failover(func(c *Client) error {
+			var err error
+			decision, err = c.SendRequestAndWaitDecision(ctx, requestID, requestData)
+			return err
+		}, unreachable)
+		return decision, err
+	}
+
+	// Returning cancels the requests still waiting on the other relays
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		decision Decision
+		err      error
+	}
+	results := make(chan result, len(m.clients))
+	for _, c := range m.clients {
+		go func() {
+			decision, err := c.SendRequestAndWaitDecision(ctx, requestID, requestData)
+			results <- result{decision, err}
+		}()
+	}
+	var errs []error
+	for range m.clients {
+		r := <-results
+		if r.err == nil {
+			return r.decision, nil
+		}
+		errs = append(errs, r.err)
+	}
+	return Decision{}, errors.Join(errs...)
+}
+
+// failover connects the active relay if need be and runs send on it, moving
+// on to the next relay while retry reports the error as the relay's fault,
+// until one succeeds or each has been tried once
+func (m *MultiClient) failover(send func(*Client) error, retry func(error) bool) error {
+	var errs []error
+	for range m.clients {
+		m.mu.Lock()
+		index := m.active
+		m.mu.Unlock()
+
+		err := connected(m.clients[index])
+		if err == nil {
+			err = send(m.clients[index])
+		}
+		if err == nil || !retry(err) {
+			return err
+		}
+		errs = append(errs, err)
+
+		next := (index + 1) % len(m.clients)
+		m.mu.Lock()
+		// Another send may already have moved on
+		if m.active == index {
+			m.active = next
+		}
+		m.mu.Unlock()
+		if next != index {
+			slog.Warn("Relay unavailable, failing over", "from", m.clients[index].relayURL, "to", m.clients[next].relayURL, "error", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// connected dials c unless it has a connection, or is re-dialing one itself
+func connected(c *Client) error {
+	c.mu.RLock()
+	dial := c.conn == nil && !c.redial
+	c.mu.RUnlock()
+	if !dial {
+		return nil
+	}
+	if err := c.Connect(); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	return nil
+}
+
+// unreachable reports whether err means the relay itself is unavailable, as
+// opposed to the request failing on a working relay
+func unreachable(err error) bool {
+	var rejected *RejectedError
+	return errors.Is(err, ErrNotConnected) || errors.Is(err, ErrConnectionLost) || errors.As(err, &rejected)
+}
+
+// handleDecision passes each request's first decision, from whichever relay,
+// to the decision handler
+func (m *MultiClient) handleDecision(requestID string, approved bool) {
+	m.mu.Lock()
+	if _, dup := m.seen[requestID]; dup {
+		m.mu.Unlock()
+		slog.Debug("Ignoring decision already received from another relay", "requestID", requestID)
+		return
+	}
+	if len(m.seenOrder) >= DefaultDedupeWindow {
+		delete(m.seen, m.seenOrder[0])
+		m.seenOrder = m.seenOrder[1:]
+	}
+	m.seen[requestID] = struct{}{}
+	m.seenOrder = append(m.seenOrder, requestID)
+	handler := m.decisionHandler
+	m.mu.Unlock()
+
+	if handler != nil {
+		handler(requestID, approved)
+	}
+}
+
+// Close closes the client of every relay
+func (m *MultiClient) Close() error {
+	var wg sync.WaitGroup
+	for _, c := range m.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Close()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
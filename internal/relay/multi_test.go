@@ -0,0 +1,138 @@
+package relay_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// newMultiClient returns a MultiClient for relayURLs and its key, closed when
+// the test ends
+func newMultiClient(t *testing.T, relayURLs []string, mode relay.RelayMode, opts ...relay.Option) (*relay.MultiClient, []byte) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := relay.NewMultiClient(relayURLs, mode, crypto.DeriveTenantID(key), key, opts...)
+	if err != nil {
+		t.Fatalf("NewMultiClient: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m, key
+}
+
+// approveAll approves every request browser receives until the test ends
+func approveAll(browser *relaytest.Browser) {
+	go func() {
+		for {
+			req, err := browser.Next(context.Background(), nil)
+			if err != nil {
+				return
+			}
+			browser.Decide(req, true)
+		}
+	}()
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	// The first relay is down from the start
+	down := httptest.NewServer(nil)
+	down.Close()
+	first := relaytest.NewServer()
+	defer first.Close()
+	second := relaytest.NewServer()
+	defer second.Close()
+	m, key := newMultiClient(t, []string{"ws" + strings.TrimPrefix(down.URL, "http"), first.WSURL(), second.WSURL()},
+		relay.RelayFailover, relay.WithReconnectPolicy(relay.ReconnectFail))
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	firstBrowser := dialBrowser(t, first, key)
+	approveAll(dialBrowser(t, second, key))
+
+	// The first live relay is used until it drops the request in flight, then
+	// the request is sent through the next
+	done := make(chan error, 1)
+	go func() {
+		approved, err := m.SendRequestAndWait(ctxWithTimeout(t, 5*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+		if err == nil && !approved {
+			err = errDenied
+		}
+		done <- err
+	}()
+	if _, err := firstBrowser.Next(ctxWithTimeout(t, 2*time.Second), nil); err != nil {
+		t.Fatalf("request not sent through the first live relay: %v", err)
+	}
+	if !first.DropServer(crypto.DeriveTenantID(key)) {
+		t.Fatal("not connected to the first live relay")
+	}
+	if err := resolved(t, done); err != nil {
+		t.Fatalf("after failover: %v, want approved", err)
+	}
+
+	// Later requests stay on the relay failed over to
+	approved, err := m.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-2", relay.AuthRequest{ID: "req-2", Method: "GET", Path: "/"})
+	if err != nil || !approved {
+		t.Errorf("req-2: %v, %v, want approved", approved, err)
+	}
+	if req, err := firstBrowser.Next(ctxWithTimeout(t, 200*time.Millisecond), nil); err == nil {
+		t.Errorf("%s sent through the relay failed over from", req.ID)
+	}
+}
+
+func TestMultiClientActiveActiveDedup(t *testing.T) {
+	first := relaytest.NewServer()
+	defer first.Close()
+	second := relaytest.NewServer()
+	defer second.Close()
+	m, key := newMultiClient(t, []string{first.WSURL(), second.WSURL()}, relay.RelayActiveActive)
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	var handled atomic.Int32
+	m.SetDecisionHandler(func(string, bool) { handled.Add(1) })
+	firstBrowser, secondBrowser := dialBrowser(t, first, key), dialBrowser(t, second, key)
+
+	// Both relays carry the request and both approvers answer it
+	if err := m.SendRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	for _, browser := range []*relaytest.Browser{firstBrowser, secondBrowser} {
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err != nil {
+			t.Fatalf("request not sent through every relay: %v", err)
+		}
+		browser.Decide(req, true)
+	}
+	waitFor(t, "decision", func() bool { return handled.Load() > 0 })
+	time.Sleep(100 * time.Millisecond)
+	if n := handled.Load(); n != 1 {
+		t.Errorf("handler called %d times for one request, want once", n)
+	}
+
+	// A wait resolves on the first decision from either relay
+	approveAll(secondBrowser)
+	approved, err := m.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-2", relay.AuthRequest{ID: "req-2", Method: "GET", Path: "/"})
+	if err != nil || !approved {
+		t.Errorf("req-2: %v, %v, want approved", approved, err)
+	}
+}
+
+func TestParseRelayMode(t *testing.T) {
+	for value, want := range map[string]relay.RelayMode{"failover": relay.RelayFailover, "active-active": relay.RelayActiveActive} {
+		if got, err := relay.ParseRelayMode(value); err != nil || got != want {
+			t.Errorf("ParseRelayMode(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := relay.ParseRelayMode("round-robin"); err == nil {
+		t.Error("ParseRelayMode accepted round-robin")
+	}
+}
@@ -0,0 +1,143 @@
+package relay_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// answerWith has browser answer the next request with each nonce in turn,
+// approving it
+func answerWith(browser *relaytest.Browser, nonces ...func(relay.AuthRequest) string) {
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		for _, nonce := range nonces {
+			forged := req
+			forged.Nonce = nonce(req)
+			browser.Decide(forged, true)
+		}
+	}()
+}
+
+func TestDecisionNonceRequired(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	answerWith(browser,
+		func(relay.AuthRequest) string { return "" },
+		func(relay.AuthRequest) string { return "0123456789abcdef0123456789abcdef" },
+	)
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, 300*time.Millisecond), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if !errors.Is(err, relay.ErrTimeout) {
+		t.Errorf("decisions with a missing and a wrong nonce: %v, want them rejected and a timeout", err)
+	}
+}
+
+func TestStaleNonceRejected(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	first := make(chan relay.AuthRequest, 1)
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		first <- req
+		browser.Decide(req, true)
+	}()
+	approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if err != nil || !approved {
+		t.Fatalf("decision echoing the nonce: %v, %v, want approved", approved, err)
+	}
+	stale := <-first
+
+	// The first approval replayed for the next prompt doesn't answer it, only a
+	// decision echoing the new nonce does
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		if req.Nonce == stale.Nonce {
+			t.Error("nonce reused across requests")
+		}
+		replayed := stale
+		replayed.ID = req.ID
+		browser.Decide(replayed, true)
+		browser.Decide(req, false)
+	}()
+	approved, err = c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-2", relay.AuthRequest{ID: "req-2", Method: "GET", Path: "/"})
+	if err != nil || approved {
+		t.Errorf("after a replayed approval: %v, %v, want denied by the fresh decision", approved, err)
+	}
+}
+
+func TestAuthRequestPointerBound(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	// A decision without the nonce doesn't answer a request passed by pointer
+	answerWith(browser, func(relay.AuthRequest) string { return "" })
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, 300*time.Millisecond), "req-1", &relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if !errors.Is(err, relay.ErrTimeout) {
+		t.Errorf("decision without the nonce: %v, want it rejected and a timeout", err)
+	}
+
+	if _, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-2", relay.AuthRequest{ID: "req-3"}); err == nil {
+		t.Error("AuthRequest with another ID than the waited-on request was sent")
+	}
+}
+
+func TestDecisionForUnsentRequestRejected(t *testing.T) {
+	hooked := make(chan string, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event relay.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		hooked <- event.RequestID
+	}))
+	defer hook.Close()
+
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	webhook := relay.NewWebhook(hook.URL)
+	webhook.HTTPClient = hook.Client()
+	c.SetWebhook(webhook)
+	browser := dialBrowser(t, srv, key)
+
+	// Neither a decision for a request never sent, even echoing another
+	// request's nonce, nor one with a forged nonce is handled; the marker
+	// decided after them is
+	sent := sendAndShow(t, c, browser, "req-1")
+	unsent := relay.AuthRequest{ID: "req-2", Nonce: sent.Nonce}
+	forged := sent
+	forged.Nonce = "forged"
+	if got := handledAnswers(t, c, browser, unsent, forged); len(got) != 0 {
+		t.Errorf("handler called for %v, want both decisions rejected", got)
+	}
+	select {
+	case id := <-hooked:
+		if id != "done" {
+			t.Errorf("webhook called for %s, want only the marker", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called for the marker")
+	}
+}
@@ -0,0 +1,75 @@
+package relay_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestSendsArriveInOrderPerSender(t *testing.T) {
+	const senders, perSender = 8, 25
+	cfg := server.DefaultConfig()
+	cfg.RateLimit = 0
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	var wg sync.WaitGroup
+	for sender := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range perSender {
+				if err := c.SendRequest(relay.AuthRequest{ID: fmt.Sprintf("%d-%d", sender, seq)}); err != nil {
+					t.Errorf("sender %d: %v", sender, err)
+					return
+				}
+			}
+		}()
+	}
+
+	next := make([]int, senders)
+	ctx := ctxWithTimeout(t, 5*time.Second)
+	for range senders * perSender {
+		req, err := browser.Next(ctx, nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		var sender, seq int
+		fmt.Sscanf(req.ID, "%d-%d", &sender, &seq)
+		if seq != next[sender] {
+			t.Fatalf("sender %d: got message %d, want %d", sender, seq, next[sender])
+		}
+		next[sender]++
+	}
+	wg.Wait()
+}
+
+func TestFlush(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	if err := c.SendRequest(relay.AuthRequest{ID: "req-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if req, err := browser.Next(ctxWithTimeout(t, time.Second), nil); err != nil || req.ID != "req-1" {
+		t.Fatalf("Next = %+v, %v", req, err)
+	}
+
+	c.Close()
+	if err := c.Flush(); !errors.Is(err, relay.ErrClientClosed) {
+		t.Errorf("Flush after Close = %v, want ErrClientClosed", err)
+	}
+}
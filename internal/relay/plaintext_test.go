@@ -0,0 +1,54 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestMaxPlaintextSizeRejectsLargeRequest(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, _ := newClient(t, srv, relay.WithMaxPlaintextSize(1024))
+
+	err := c.SendRequest(relay.AuthRequest{ID: "req-1", Path: "/" + strings.Repeat("a", 1024)})
+	if !errors.Is(err, crypto.ErrTooLarge) || !errors.Is(err, relay.ErrEncrypt) {
+		t.Errorf("err = %v, want ErrEncrypt and ErrTooLarge", err)
+	}
+}
+
+func TestMaxPlaintextSizeDropsLargeDecision(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithMaxPlaintextSize(1024))
+	browser := dialBrowser(t, srv, key)
+	browser.Approver = strings.Repeat("a", 1024)
+
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err == nil {
+			browser.Decide(req, true)
+		}
+	}()
+	// The oversized decision is dropped, so the request never resolves
+	_, err := c.SendRequestAndWait(ctxWithTimeout(t, 500*time.Millisecond), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if !errors.Is(err, relay.ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestMaxPlaintextSizeMustBePositive(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.NewClient("ws://relay.invalid", crypto.DeriveTenantID(key), key, relay.WithMaxPlaintextSize(0)); err == nil {
+		t.Error("NewClient accepted a zero max plaintext size")
+	}
+}
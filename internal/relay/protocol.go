@@ -0,0 +1,56 @@
+package relay
+
+// Subprotocol is the WebSocket subprotocol naming this version of the wire
+// protocol. Peers offer it in Sec-WebSocket-Protocol so an incompatible
+// client and relay fail the handshake instead of exchanging frames neither
+// understands.
+const Subprotocol = "extauthz.v1"
+
+// Subprotocols lists the subprotocols the relay speaks, most preferred first
+var Subprotocols = []string{Subprotocol}
+
+// ControlTypeAck acknowledges a server message to the server
+const ControlTypeAck = "ack"
+
+// ControlTypeDrain tells every peer the relay is shutting down and will close
+// its connection; peers should wait RetryAfter seconds before reconnecting
+const ControlTypeDrain = "drain"
+
+// ControlTypeCancel tells browsers the server stopped waiting for RequestID,
+// e.g. after a timeout, so its prompt should be dismissed
+const ControlTypeCancel = "cancel"
+
+// ControlFrame is the JSON payload of ACK and CONTROL frames
+type ControlFrame struct {
+	Type string `json:"type"`
+	// Clients is the number of browser clients the message was forwarded to
+	Clients int `json:"clients"`
+	// Buffered is set when no client was connected and the relay queued the message
+	Buffered bool `json:"buffered,omitempty"`
+	// RateLimited is set when the relay dropped the message for exceeding the
+	// tenant's rate limit, whether or not a client was connected
+	RateLimited bool `json:"rateLimited,omitempty"`
+	// RetryAfter is how many seconds a drained peer should wait before reconnecting
+	RetryAfter int `json:"retryAfter,omitempty"`
+	// RequestID is the request a cancel applies to
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// DeliveryStatus reports what the relay did with a message sent by the server
+type DeliveryStatus struct {
+	// RequestID is the request the acknowledged message carried
+	RequestID string
+	Clients   int
+	Buffered  bool
+	// RateLimited means the relay dropped the message; Clients is then 0
+	// whether or not an approver is connected
+	RateLimited bool
+}
+
+// Delivered reports whether the message reached, or is queued for, an approver
+func (d DeliveryStatus) Delivered() bool {
+	return !d.RateLimited && (d.Clients > 0 || d.Buffered)
+}
+
+// DeliveryHandler is a callback for relay delivery acknowledgements
+type DeliveryHandler func(status DeliveryStatus)
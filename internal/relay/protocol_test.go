@@ -0,0 +1,39 @@
+package relay_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestConnectRejectsUnknownSubprotocol(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Pick a subprotocol the client never offered
+		conn, err := upgrader.Upgrade(w, req, http.Header{"Sec-Websocket-Protocol": {"extauthz.v2"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "extauthz.v2") {
+		t.Errorf("Connect: %v, want an unsupported subprotocol error", err)
+	}
+}
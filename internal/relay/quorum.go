@@ -0,0 +1,65 @@
+package relay
+
+import "log/slog"
+
+// WithQuorum requires required distinct approvers to approve a request before
+// SendRequestAndWait reports it approved. It is denied once approvers-required+1
+// of them deny, so with approvers zero or equal to required any denial denies.
+// Approvers are told apart by the identity their browser sends with each
+// decision; everyone holding the key can claim any identity, so the quorum
+// guards against mistakes and single compromised phones, not against a
+// malicious key holder.
+func WithQuorum(required, approvers int) Option {
+	return func(c *Client) {
+		c.quorum = required
+		c.approvers = approvers
+	}
+}
+
+// denialsToDeny is how many distinct denials deny a request under the quorum
+func (c *Client) denialsToDeny() int {
+	return max(c.approvers-c.quorum+1, 1)
+}
+
+// vote counts approver's decision on requestID toward the quorum, reporting
+// the outcome once enough approvers agree. Only requests awaiting a decision
+// in SendRequestAndWait collect votes; each approver's first vote counts.
+func (c *Client) vote(requestID, approver string, approved bool) (outcome bool, decided bool) {
+	if approver == "" {
+		slog.Warn("Ignoring decision without approver identity, a quorum is required", "requestID", requestID)
+		return false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	waiter := c.waiters[requestID]
+	if waiter == nil {
+		slog.Debug("Ignoring vote for request not awaiting a decision", "requestID", requestID)
+		return false, false
+	}
+	if waiter.votes == nil {
+		waiter.votes = make(map[string]bool)
+	}
+	if _, voted := waiter.votes[approver]; voted {
+		return false, false
+	}
+	waiter.votes[approver] = approved
+
+	var approvals, denials int
+	for _, v := range waiter.votes {
+		if v {
+			approvals++
+		} else {
+			denials++
+		}
+	}
+	switch {
+	case approvals >= c.quorum:
+		return true, true
+	case denials >= c.denialsToDeny():
+		return false, true
+	}
+	slog.Info("Recorded approver vote", "requestID", requestID, "approvals", approvals, "denials", denials, "required", c.quorum)
+	return false, false
+}
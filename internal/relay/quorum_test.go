@@ -0,0 +1,156 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// approvers connects n approval pages named approver-0 and up, each passing
+// the first request it receives on requests
+func approvers(t *testing.T, srv *relaytest.Server, key []byte, n int) (browsers []*relaytest.Browser, requests chan relay.AuthRequest) {
+	t.Helper()
+	requests = make(chan relay.AuthRequest, n)
+	for i := range n {
+		browser := dialBrowser(t, srv, key)
+		browser.Approver = fmt.Sprintf("approver-%d", i)
+		browsers = append(browsers, browser)
+		go func() {
+			if req, err := browser.Next(context.Background(), nil); err == nil {
+				requests <- req
+			}
+		}()
+	}
+	return browsers, requests
+}
+
+// errDenied is what sendAsync reports for a denied request
+var errDenied = errors.New("denied")
+
+// sendAsync runs SendRequestAndWait for id in the background
+func sendAsync(c *relay.Client, ctx context.Context, id string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		approved, err := c.SendRequestAndWait(ctx, id, relay.AuthRequest{ID: id, Method: "DELETE", Path: "/prod"})
+		if err == nil && !approved {
+			err = errDenied
+		}
+		done <- err
+	}()
+	return done
+}
+
+// pending fails the test if done has resolved
+func pending(t *testing.T, done <-chan error, after string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("resolved after %s: %v", after, err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// resolved waits for done and returns its result
+func resolved(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("request never resolved")
+		return nil
+	}
+}
+
+func TestQuorumReached(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithQuorum(2, 3))
+	browsers, requests := approvers(t, srv, key, 3)
+
+	done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-1")
+	req := <-requests
+	browsers[0].Decide(req, true)
+	pending(t, done, "one approval")
+	// The same approver approving again doesn't count twice
+	browsers[0].Decide(req, true)
+	pending(t, done, "a repeated approval")
+	// One denial of three doesn't deny a 2-of-3 quorum
+	browsers[1].Decide(req, false)
+	pending(t, done, "one denial")
+	browsers[2].Decide(req, true)
+	if err := resolved(t, done); err != nil {
+		t.Errorf("with two approvals: %v, want approved", err)
+	}
+}
+
+func TestQuorumDenied(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+
+	// With every approver required, one denial denies
+	c, key := newClient(t, srv, relay.WithQuorum(2, 2))
+	browsers, requests := approvers(t, srv, key, 2)
+	done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-1")
+	req := <-requests
+	browsers[0].Decide(req, true)
+	browsers[1].Decide(req, false)
+	if err := resolved(t, done); !errors.Is(err, errDenied) {
+		t.Errorf("2-of-2 with a denial: %v, want denied", err)
+	}
+
+	// A 2-of-3 quorum is denied once it can no longer be reached
+	c, key = newClient(t, srv, relay.WithQuorum(2, 3))
+	browsers, requests = approvers(t, srv, key, 3)
+	done = sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-2")
+	req = <-requests
+	browsers[0].Decide(req, false)
+	pending(t, done, "one denial")
+	browsers[1].Decide(req, false)
+	if err := resolved(t, done); !errors.Is(err, errDenied) {
+		t.Errorf("2-of-3 with two denials: %v, want denied", err)
+	}
+}
+
+func TestQuorumTimeout(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithQuorum(2, 3))
+	browsers, requests := approvers(t, srv, key, 3)
+
+	done := sendAsync(c, ctxWithTimeout(t, 300*time.Millisecond), "req-1")
+	req := <-requests
+	browsers[0].Decide(req, true)
+	// A decision without an approver identity can't count toward the quorum
+	browsers[1].Approver = ""
+	browsers[1].Decide(req, true)
+	if err := resolved(t, done); !errors.Is(err, relay.ErrTimeout) {
+		t.Errorf("one approval of two: %v, want ErrTimeout", err)
+	}
+}
+
+func TestQuorumIgnoresForgedVotes(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithQuorum(2, 3))
+	browsers, requests := approvers(t, srv, key, 3)
+
+	done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-1")
+	req := <-requests
+	browsers[0].Decide(req, true)
+	// A second approval without the request's nonce isn't a vote, so it
+	// neither reaches the quorum nor uses up the approver's vote
+	forged := req
+	forged.Nonce = "forged"
+	browsers[1].Decide(forged, true)
+	pending(t, done, "a forged approval")
+	browsers[1].Decide(req, true)
+	if err := resolved(t, done); err != nil {
+		t.Errorf("with two genuine approvals: %v, want approved", err)
+	}
+}
@@ -0,0 +1,109 @@
+package relay_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// rawFrame is a message as a RawFrameHandler saw it
+type rawFrame struct {
+	messageType int
+	data        []byte
+}
+
+func TestRawFrameHandlerSeesExactBytes(t *testing.T) {
+	sent := []rawFrame{
+		{websocket.BinaryMessage, relay.EncodeFrame(relay.FramePing, nil)},
+		{websocket.BinaryMessage, []byte{0xff, 0x00, 0x13, 0x37}},
+		{websocket.TextMessage, []byte(`{"not":"a frame"}`)},
+	}
+	upgrader := websocket.Upgrader{Subprotocols: relay.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, frame := range sent {
+			conn.WriteMessage(frame.messageType, frame.data)
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	var mu sync.Mutex
+	var seen []rawFrame
+	c.SetRawFrameHandler(func(messageType int, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, rawFrame{messageType, data})
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	waitFor(t, "frames to arrive", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) == len(sent)
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for i, frame := range sent {
+		if seen[i].messageType != frame.messageType || !bytes.Equal(seen[i].data, frame.data) {
+			t.Errorf("frame %d: handler saw %d %x, want %d %x", i, seen[i].messageType, seen[i].data, frame.messageType, frame.data)
+		}
+	}
+}
+
+func TestRawFrameHandlerDoesNotInterfere(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+	// The handler's copy is its own to scribble on
+	c.SetRawFrameHandler(func(_ int, data []byte) {
+		clear(data)
+	})
+
+	go func() {
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err == nil {
+			browser.Decide(req, true)
+		}
+	}()
+	approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"})
+	if err != nil || !approved {
+		t.Errorf("with a raw frame handler: %v, %v, want approved", approved, err)
+	}
+
+	c.SetRawFrameHandler(nil)
+	go func() {
+		req, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+		if err == nil {
+			browser.Decide(req, true)
+		}
+	}()
+	if approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-2", relay.AuthRequest{ID: "req-2", Method: "GET", Path: "/"}); err != nil || !approved {
+		t.Errorf("after removing the handler: %v, %v, want approved", approved, err)
+	}
+}
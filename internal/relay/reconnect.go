@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ReconnectPolicy decides what happens to a SendRequestAndWait call whose
+// request was sent on a connection that has since been replaced
+type ReconnectPolicy int
+
+const (
+	// ReconnectWait keeps waiting; the decision still resolves the request if
+	// the approver saw it before the drop or the relay buffered it
+	ReconnectWait ReconnectPolicy = iota
+	// ReconnectResend re-dials a dropped connection while requests await a
+	// decision and sends them again on the new one
+	ReconnectResend
+	// ReconnectFail resolves the request with ErrConnectionLost, leaving any
+	// retry to the caller
+	ReconnectFail
+)
+
+// ParseReconnectPolicy parses "wait", "resend" or "fail"
+func ParseReconnectPolicy(value string) (ReconnectPolicy, error) {
+	switch value {
+	case "wait":
+		return ReconnectWait, nil
+	case "resend":
+		return ReconnectResend, nil
+	case "fail":
+		return ReconnectFail, nil
+	default:
+		return ReconnectWait, fmt.Errorf("invalid reconnect policy %q: must be wait, resend or fail", value)
+	}
+}
+
+// WithReconnectPolicy sets what happens to requests in flight when the relay
+// connection is replaced. The default is ReconnectWait.
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(c *Client) {
+		c.reconnectPolicy = policy
+	}
+}
+
+// WithResendOnReconnect re-dials the relay when the connection drops while a
+// SendRequestAndWait call is waiting, and sends its request again on the new
+// connection so an approver who reconnects still sees it. The resend carries
+// the same request ID and nonce, so a decision for either copy resolves the
+// request once. It is shorthand for WithReconnectPolicy(ReconnectResend).
+func WithResendOnReconnect(enabled bool) Option {
+	return func(c *Client) {
+		if enabled {
+			c.reconnectPolicy = ReconnectResend
+		} else if c.reconnectPolicy == ReconnectResend {
+			c.reconnectPolicy = ReconnectWait
+		}
+	}
+}
+
+// notifyReconnectedLocked applies the reconnect policy to waiters whose
+// request was sent on an earlier connection. c.mu must be held.
+func (c *Client) notifyReconnectedLocked() {
+	for _, pending := range c.waiters {
+		if pending.frame == nil {
+			continue
+		}
+		switch c.reconnectPolicy {
+		case ReconnectResend:
+			select {
+			case pending.reconnected <- struct{}{}:
+			default:
+			}
+		case ReconnectFail:
+			pending.resolve(waitResult{err: ErrConnectionLost})
+		}
+	}
+}
+
+// dropped handles the connection of epoch failing underneath the client.
+// Under ReconnectResend a connection still awaited by a request is re-dialed
+// by the writer so the request can be resent, and under ReconnectFail its
+// requests fail at once; otherwise the next send re-dials.
+func (c *Client) dropped(epoch uint64) {
+	if c.reconnectPolicy == ReconnectWait {
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed || c.epoch != epoch || c.conn == nil || c.rejected != nil || !c.awaitingLocked() {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.acks = nil
+	c.redial = true
+	if c.reconnectPolicy == ReconnectFail {
+		c.notifyReconnectedLocked()
+		c.mu.Unlock()
+		slog.Warn("Relay connection dropped, failing requests awaiting a decision")
+		return
+	}
+	c.mu.Unlock()
+
+	slog.Warn("Relay connection dropped while requests await a decision, reconnecting")
+	if err := c.enqueue(&outbound{reconnect: true}); err != nil {
+		slog.Error("Failed to reconnect to relay", "error", err)
+	}
+}
+
+// reconnect re-dials a dropped connection, retrying like a failed send while
+// a request still awaits a decision
+func (c *Client) reconnect() error {
+	var err error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		c.mu.RLock()
+		done := c.conn != nil || !c.redial || !c.awaitingLocked()
+		// The first attempt is immediate unless a draining relay asked to wait
+		delay := time.Until(c.drainedUntil)
+		if attempt > 0 {
+			delay = max(c.retryDelay, delay)
+		}
+		c.mu.RUnlock()
+		if done {
+			return nil
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if err = c.Connect(); err == nil {
+			return nil
+		}
+		slog.Error("Failed to reconnect to relay", "attempt", attempt, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package relay_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+)
+
+// brokenRelay accepts one connection and breaks it by sending a message over
+// the client's 1 KiB limit, then refuses every redial. It returns a client
+// whose next send fails and must reconnect, and the number of dials made.
+func brokenRelay(t *testing.T, opts ...relay.Option) (*relay.Client, *atomic.Int32) {
+	t.Helper()
+	var dials atomic.Int32
+	broken := make(chan struct{})
+	upgrader := websocket.Upgrader{Subprotocols: relay.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if dials.Add(1) > 1 {
+			http.Error(w, "relay down", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte{1}, 2048))
+		conn.ReadMessage()
+		close(broken)
+	}))
+	t.Cleanup(srv.Close)
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), crypto.DeriveTenantID(key), key, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetMaxMessageSize(1024)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	select {
+	case <-broken:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client never dropped the connection")
+	}
+	return c, &dials
+}
+
+func TestReconnectBudgetStopsRetries(t *testing.T) {
+	c, dials := brokenRelay(t, relay.WithMaxRetries(10), relay.WithReconnectBudget(500*time.Millisecond))
+
+	start := time.Now()
+	err := c.SendRequest(relay.AuthRequest{ID: "req-1"})
+	if !errors.Is(err, relay.ErrNotConnected) || !strings.Contains(err.Error(), "reconnect budget") {
+		t.Fatalf("err = %v, want the reconnect budget exhausted", err)
+	}
+	// One retry, a second apart, fits the budget; ten would take ten seconds
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("gave up after %v with a 500ms budget", elapsed)
+	}
+	if n := dials.Load(); n < 2 || n > 3 {
+		t.Errorf("%d dials, want the budget to stop after one or two redials", n)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	c, dials := brokenRelay(t, relay.WithMaxRetries(0))
+
+	err := c.SendRequest(relay.AuthRequest{ID: "req-1"})
+	if !errors.Is(err, relay.ErrNotConnected) || !strings.Contains(err.Error(), "after 1 attempts") {
+		t.Fatalf("err = %v, want a failure without retrying", err)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("%d dials, want no redial", n)
+	}
+}
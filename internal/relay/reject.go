@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MaxTenantIDLength is the longest tenant ID the relay accepts, whatever its
+// configured pattern
+const MaxTenantIDLength = 128
+
+// Close codes for refused WebSocket connections, from the 4000-4999 range RFC
+// 6455 leaves to applications. The relay sends only CloseAtCapacity, the one
+// worth retrying, and CloseUnsupportedProtocol; 4001, 4002 and 4004 are
+// reserved and no longer sent, as those refusals are HTTP statuses.
+const (
+	// CloseInvalidTenant means the tenant ID doesn't match the relay's pattern
+	CloseInvalidTenant = 4001
+	// CloseTenantIDTooLong means the tenant ID exceeds MaxTenantIDLength
+	CloseTenantIDTooLong = 4002
+	// CloseAtCapacity means the relay is tracking as many tenants as it may
+	CloseAtCapacity = 4003
+	// CloseUnauthorized means the connection failed authentication
+	CloseUnauthorized = 4004
+	// CloseUnsupportedProtocol means the peer offered no subprotocol the relay speaks
+	CloseUnsupportedProtocol = 4005
+)
+
+// Rejection is a reason the relay refuses a connection. An invalid or
+// over-long tenant ID, or failed authentication, gets Status before the
+// upgrade. Capacity and unsupported-subprotocol rejections are upgraded and
+// then closed with Code and Reason, since browsers can't see the status of a
+// failed handshake.
+type Rejection struct {
+	Code   int
+	Status int
+	Reason string
+}
+
+// The relay's rejections
+var (
+	RejectInvalidTenant   = Rejection{Code: CloseInvalidTenant, Status: http.StatusBadRequest, Reason: "invalid tenant ID"}
+	RejectTenantIDTooLong = Rejection{Code: CloseTenantIDTooLong, Status: http.StatusRequestURITooLong, Reason: "tenant ID too long"}
+	RejectAtCapacity      = Rejection{Code: CloseAtCapacity, Status: http.StatusServiceUnavailable, Reason: "relay at tenant capacity"}
+	RejectUnauthorized    = Rejection{Code: CloseUnauthorized, Status: http.StatusUnauthorized, Reason: "unauthorized"}
+	RejectUnsupported     = Rejection{Code: CloseUnsupportedProtocol, Status: http.StatusBadRequest, Reason: "unsupported subprotocol"}
+)
+
+// Retryable reports whether reconnecting later may succeed
+func (r Rejection) Retryable() bool {
+	return RetryableClose(r.Code)
+}
+
+// RetryableClose reports whether a connection closed with code is worth
+// re-dialing. Every close code is, except the relay's permanent rejections.
+func RetryableClose(code int) bool {
+	switch code {
+	case CloseInvalidTenant, CloseTenantIDTooLong, CloseUnauthorized, CloseUnsupportedProtocol:
+		return false
+	}
+	return true
+}
+
+// RetryableStatus reports whether a handshake the relay answered with status
+// is worth re-dialing. Every status is, except the relay's permanent rejections.
+func RetryableStatus(status int) bool {
+	switch status {
+	case RejectInvalidTenant.Status, RejectUnauthorized.Status, RejectTenantIDTooLong.Status:
+		return false
+	}
+	return true
+}
+
+// RejectedError is returned by sends once the relay has permanently rejected
+// the client's connection, with a close code or, before the upgrade, an HTTP
+// status
+type RejectedError struct {
+	Code   int
+	Status int
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("relay rejected connection (HTTP %d): %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("relay rejected connection (%d): %s", e.Code, e.Reason)
+}
@@ -0,0 +1,73 @@
+package relay_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestRetryableClose(t *testing.T) {
+	tests := map[int]bool{
+		relay.CloseInvalidTenant:       false,
+		relay.CloseTenantIDTooLong:     false,
+		relay.CloseUnauthorized:        false,
+		relay.CloseUnsupportedProtocol: false,
+		relay.CloseAtCapacity:          true,
+		websocket.CloseGoingAway:       true,
+		websocket.CloseAbnormalClosure: true,
+		websocket.CloseNormalClosure:   true,
+	}
+	for code, want := range tests {
+		if got := relay.RetryableClose(code); got != want {
+			t.Errorf("RetryableClose(%d) = %v, want %v", code, got, want)
+		}
+	}
+	if relay.RejectUnauthorized.Retryable() || !relay.RejectAtCapacity.Retryable() {
+		t.Error("Rejection.Retryable disagrees with RetryableClose")
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	tests := map[int]bool{
+		http.StatusBadRequest:         false,
+		http.StatusUnauthorized:       false,
+		http.StatusRequestURITooLong:  false,
+		http.StatusServiceUnavailable: true,
+		http.StatusBadGateway:         true,
+	}
+	for status, want := range tests {
+		if got := relay.RetryableStatus(status); got != want {
+			t.Errorf("RetryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestConnectUnauthorizedRejection(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.AuthMode = server.AuthModeSecret
+	cfg.AuthSecret = "relay secret"
+	srv := relaytest.NewServerWithConfig(cfg)
+	defer srv.Close()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := relay.NewClient(srv.WSURL(), crypto.DeriveTenantID(key), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetAuthToken("guess")
+
+	var rejected *relay.RejectedError
+	if err := c.Connect(); !errors.As(err, &rejected) || rejected.Status != http.StatusUnauthorized {
+		t.Errorf("Connect: %v, want a 401 rejection", err)
+	}
+}
@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// RiskLevel is how much damage approving a request by mistake could do,
+// shown to the approver so risky prompts stand out
+type RiskLevel string
+
+const (
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+// AuthRequest is what the approval page shows for a request. It is the JSON
+// schema of the encrypted DATA payload the server sends; the browser answers
+// with {"requestId", "approved", "approver", "nonce"}.
+type AuthRequest struct {
+	// ID is echoed back in the decision
+	ID       string `json:"id"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	SourceIP string `json:"sourceIP,omitempty"`
+	// Headers is the subset of request headers worth showing; credentials are left out
+	Headers map[string]string `json:"headers,omitempty"`
+	// Summary is a one-line description of the request
+	Summary string `json:"summary"`
+	// Reason explains why approval is needed, if the gateway says
+	Reason    string    `json:"reason,omitempty"`
+	Risk      RiskLevel `json:"risk,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Nonce is set when the request is sent and must be echoed in the
+	// decision, so only a page that saw this request can answer it
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// newNonce returns a random per-request nonce
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sentNonce is the nonce of a request sent without waiting for its decision
+type sentNonce struct {
+	requestID string
+	nonce     string
+}
+
+// issueNonce returns the nonce a decision for requestID, sent without waiting,
+// must echo. A request sent again keeps its nonce, since the page shows it
+// only once; the oldest is forgotten once DefaultDedupeWindow newer ones were
+// sent.
+func (c *Client) issueNonce(requestID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nonce, sent := c.nonces[requestID]; sent {
+		return nonce, nil
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if len(c.nonceOrder) >= DefaultDedupeWindow {
+		oldest := c.nonceOrder[0]
+		c.nonceOrder = c.nonceOrder[1:]
+		if c.nonces[oldest.requestID] == oldest.nonce {
+			delete(c.nonces, oldest.requestID)
+		}
+	}
+	c.nonces[requestID] = nonce
+	c.nonceOrder = append(c.nonceOrder, sentNonce{requestID: requestID, nonce: nonce})
+	return nonce, nil
+}
+
+// verifyNonce reports whether requestID was sent by this client and the
+// decision echoes the nonce it was sent with
+func (c *Client) verifyNonce(requestID, nonce string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expected, sent := c.nonces[requestID]
+	return sent && subtle.ConstantTimeCompare([]byte(expected), []byte(nonce)) == 1
+}
+
+// SendAuthRequest sends req to the approval page without waiting for a
+// decision, with a nonce the decision must echo; SendRequest takes any payload
+func (c *Client) SendAuthRequest(req AuthRequest) error {
+	nonce, err := c.issueNonce(req.ID)
+	if err != nil {
+		return err
+	}
+	req.Nonce = nonce
+	return c.send(req.ID, req)
+}
@@ -0,0 +1,84 @@
+package relay_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func testAuthRequest() relay.AuthRequest {
+	return relay.AuthRequest{
+		ID:        "req-1",
+		Method:    "DELETE",
+		Path:      "/orders/42",
+		SourceIP:  "10.0.0.1",
+		Headers:   map[string]string{"user-agent": "curl/8.0"},
+		Summary:   "DELETE /orders/42 from 10.0.0.1",
+		Reason:    "destructive method",
+		Risk:      relay.RiskHigh,
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestAuthRequestEncryptRoundTrip(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testAuthRequest()
+	plaintext, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := crypto.Encrypt(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := crypto.Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got relay.AuthRequest
+	if err := json.Unmarshal(decrypted, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestSendAuthRequest(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	browser := dialBrowser(t, srv, key)
+
+	want := testAuthRequest()
+	if err := c.SendAuthRequest(want); err != nil {
+		t.Fatalf("SendAuthRequest: %v", err)
+	}
+	got, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if len(got.Nonce) != 32 {
+		t.Errorf("nonce = %q, want 16 bytes of hex", got.Nonce)
+	}
+	got.Nonce = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("page received %+v, want %+v", got, want)
+	}
+
+	// Sent through SendRequestAndWait, the request carries a nonce too
+	go c.SendRequestAndWait(context.Background(), "req-2", relay.AuthRequest{ID: "req-2"})
+	got, err = browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+	if err != nil || got.ID != "req-2" || len(got.Nonce) != 32 {
+		t.Errorf("Next = %+v, %v, want req-2 with a 16-byte hex nonce", got, err)
+	}
+}
@@ -0,0 +1,72 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+func TestResendOnReconnect(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithResendOnReconnect(true))
+	browser := dialBrowser(t, srv, key)
+
+	done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-1")
+	first, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if !srv.DropServer(crypto.DeriveTenantID(key)) {
+		t.Fatal("server not connected")
+	}
+
+	// The request arrives again over the new connection, unchanged
+	again, err := browser.Next(ctxWithTimeout(t, 4*time.Second), nil)
+	if err != nil {
+		t.Fatalf("request not re-delivered after reconnect: %v", err)
+	}
+	if again.ID != first.ID || again.Nonce != first.Nonce {
+		t.Errorf("resent request = %s/%s, want %s/%s", again.ID, again.Nonce, first.ID, first.Nonce)
+	}
+	browser.Decide(again, true)
+	if err := resolved(t, done); err != nil {
+		t.Errorf("after reconnect: %v, want approved", err)
+	}
+}
+
+func TestFailOnReconnect(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv, relay.WithReconnectPolicy(relay.ReconnectFail))
+	browser := dialBrowser(t, srv, key)
+
+	done := sendAsync(c, ctxWithTimeout(t, 5*time.Second), "req-1")
+	if _, err := browser.Next(ctxWithTimeout(t, 2*time.Second), nil); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	srv.DropServer(crypto.DeriveTenantID(key))
+	if err := resolved(t, done); !errors.Is(err, relay.ErrConnectionLost) {
+		t.Errorf("err = %v, want ErrConnectionLost", err)
+	}
+	// Nothing is resent
+	if req, err := browser.Next(ctxWithTimeout(t, 300*time.Millisecond), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next = %+v, %v, want no resend", req, err)
+	}
+}
+
+func TestParseReconnectPolicy(t *testing.T) {
+	for value, want := range map[string]relay.ReconnectPolicy{"wait": relay.ReconnectWait, "resend": relay.ReconnectResend, "fail": relay.ReconnectFail} {
+		if got, err := relay.ParseReconnectPolicy(value); err != nil || got != want {
+			t.Errorf("ParseReconnectPolicy(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := relay.ParseReconnectPolicy("retry"); err == nil {
+		t.Error("ParseReconnectPolicy accepted retry")
+	}
+}
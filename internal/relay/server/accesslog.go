@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statusRecorder captures the status written by a handler. A hijacked
+// connection, i.e. a completed WebSocket upgrade, is recorded as 101.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// closeCode is set when the relay refuses a connection after upgrading it
+	closeCode int
+}
+
+// recorderKey is the request context key for the request's statusRecorder
+type recorderKey struct{}
+
+// recordCloseCode notes on the access log entry for req that its WebSocket was
+// closed with code; it does nothing when access logging is off
+func recordCloseCode(req *http.Request, code int) {
+	if rec, ok := req.Context().Value(recorderKey{}).(*statusRecorder); ok {
+		rec.closeCode = code
+	}
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Hijack lets the WebSocket upgrader take over the connection
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// AccessLog logs every request with its status and duration. A WebSocket
+// connection the relay refuses after upgrading is logged as 101 with the close
+// code it was refused with.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), recorderKey{}, rec)))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []any{
+			"method", req.Method,
+			"path", req.URL.Path,
+			"remoteAddr", req.RemoteAddr,
+			"status", status,
+			"duration", time.Since(start),
+		}
+		if rec.closeCode != 0 {
+			attrs = append(attrs, "closeCode", rec.closeCode)
+		}
+		slog.Info("HTTP request", attrs...)
+	})
+}
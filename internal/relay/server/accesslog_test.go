@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	cfg := DefaultConfig()
+	cfg.AccessLog = true
+	_, srv := newTestRelay(t, cfg)
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(srv.URL + "/ws/server/not-a-tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	dial(t, srv, "server", testTenant, nil)
+	if conn := dialOffering(t, srv, "bogus.v0"); !rejectedWith(conn, relayproto.CloseUnsupportedProtocol) {
+		t.Fatal("unsupported subprotocol not refused")
+	}
+
+	tests := []struct {
+		path   string
+		status float64
+	}{
+		{"/healthz", http.StatusOK},
+		{"/ws/server/not-a-tenant", http.StatusBadRequest},
+		{"/ws/server/" + testTenant, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		// An upgrade is logged once its handler returns, after the dial
+		waitFor(t, "access log for "+tt.path, func() bool { return logs.findWith("HTTP request", "path", tt.path) != nil })
+		record := logs.findWith("HTTP request", "path", tt.path)
+		if record["status"] != tt.status || record["method"] != http.MethodGet || record["remoteAddr"] == "" || record["duration"] == nil {
+			t.Errorf("access log for %s = %v, want GET with status %v", tt.path, record, tt.status)
+		}
+	}
+
+	waitFor(t, "refused upgrade to be logged", func() bool {
+		return logs.findWith("HTTP request", "closeCode", float64(relayproto.CloseUnsupportedProtocol)) != nil
+	})
+	if record := logs.findWith("HTTP request", "closeCode", float64(relayproto.CloseUnsupportedProtocol)); record["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Errorf("refused upgrade status = %v, want 101", record["status"])
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	logs := captureLogs(t)
+	_, srv := newTestRelay(t, DefaultConfig())
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if record := logs.find("HTTP request"); record != nil {
+		t.Errorf("access log written while disabled: %v", record)
+	}
+}
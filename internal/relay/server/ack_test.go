@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// sendAndReadAck writes a DATA frame for requestID from server and returns the
+// relay's acknowledgement
+func sendAndReadAck(t *testing.T, server *websocket.Conn, requestID string) relayproto.ControlFrame {
+	t.Helper()
+	if err := server.WriteMessage(websocket.BinaryMessage, dataFrame(t, requestID, "ciphertext")); err != nil {
+		t.Fatal(err)
+	}
+	return readAck(t, server)
+}
+
+// readAck reads the relay's acknowledgement of the last message server sent
+func readAck(t *testing.T, server *websocket.Conn) relayproto.ControlFrame {
+	t.Helper()
+	frameType, payload := readFrame(t, server)
+	if frameType != relayproto.FrameAck {
+		t.Fatalf("got %s frame, want ACK", frameType)
+	}
+	var ack relayproto.ControlFrame
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Type != relayproto.ControlTypeAck {
+		t.Fatalf("ack type = %q", ack.Type)
+	}
+	return ack
+}
+
+func TestAckWithoutClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BufferSize = 0
+	_, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+
+	ack := sendAndReadAck(t, server, "req-1")
+	if ack.Clients != 0 || ack.Buffered || ack.RateLimited {
+		t.Errorf("ack = %+v, want no clients, not buffered or rate limited", ack)
+	}
+}
+
+func TestAckBufferedWithoutClient(t *testing.T) {
+	_, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+
+	ack := sendAndReadAck(t, server, "req-1")
+	if ack.Clients != 0 || !ack.Buffered {
+		t.Errorf("ack = %+v, want buffered for a later client", ack)
+	}
+}
+
+func TestAckWithClients(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	clients := []*websocket.Conn{
+		dial(t, srv, "client", testTenant, nil),
+		dial(t, srv, "client", testTenant, nil),
+	}
+	waitFor(t, "clients to attach", func() bool {
+		status := r.Snapshot()
+		return len(status) == 1 && status[0].Clients == 2
+	})
+
+	ack := sendAndReadAck(t, server, "req-1")
+	if ack.Clients != 2 || ack.Buffered || ack.RateLimited {
+		t.Errorf("ack = %+v, want 2 clients", ack)
+	}
+	for i, client := range clients {
+		if frameType, _ := readFrame(t, client); frameType != relayproto.FrameData {
+			t.Errorf("client %d got %s frame, want DATA", i, frameType)
+		}
+	}
+}
+
+func TestAckRateLimited(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = 0.001
+	cfg.RateBurst = 1
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool {
+		_, client := r.TenantStatus(testTenant)
+		return client
+	})
+
+	if ack := sendAndReadAck(t, server, "req-1"); ack.Clients != 1 || ack.RateLimited {
+		t.Fatalf("first ack = %+v, want delivered to 1 client", ack)
+	}
+	// A client is connected, but the relay must not report the dropped
+	// message as merely undelivered
+	ack := sendAndReadAck(t, server, "req-2")
+	if !ack.RateLimited || ack.Clients != 0 {
+		t.Errorf("second ack = %+v, want rate limited", ack)
+	}
+	status := relayproto.DeliveryStatus{Clients: ack.Clients, Buffered: ack.Buffered, RateLimited: ack.RateLimited}
+	if status.Delivered() {
+		t.Error("rate-limited status reports Delivered")
+	}
+}
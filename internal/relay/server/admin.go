@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	applog "github.com/yuval/extauth-match/internal/log"
+)
+
+// TenantStatus is the admin view of a tenant
+type TenantStatus struct {
+	TenantID        string    `json:"tenantId"`
+	ServerConnected bool      `json:"serverConnected"`
+	Clients         int       `json:"clients"`
+	LastActivity    time.Time `json:"lastActivity"`
+}
+
+// authorizeAdmin guards admin endpoints with the admin token, presented as a
+// bearer token. The admin API fails closed: without an admin token configured
+// every request is refused.
+func (r *Relay) authorizeAdmin(w http.ResponseWriter, req *http.Request) bool {
+	token := r.live.Load().adminToken
+	if token == "" {
+		slog.Warn("Rejected admin request, no admin token configured", "path", req.URL.Path)
+		http.Error(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	bearer, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if err := compareToken(bearer, token); err != nil {
+		slog.Warn("Rejected unauthenticated admin request", "path", req.URL.Path, "error", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleListTenants returns the status of every known tenant
+func (r *Relay) handleListTenants(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Snapshot())
+}
+
+// Snapshot returns the status of every tenant this relay tracks, ordered by
+// tenant ID. Connections on other relay instances aren't counted.
+func (r *Relay) Snapshot() []TenantStatus {
+	statuses := []TenantStatus{}
+	r.tenants.forEach(func(tenant *Tenant) {
+		statuses = append(statuses, tenant.status())
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].TenantID < statuses[j].TenantID
+	})
+	return statuses
+}
+
+// ActiveTenants returns how many tenants have a server or client connected to
+// this relay
+func (r *Relay) ActiveTenants() int {
+	active := 0
+	r.tenants.forEach(func(tenant *Tenant) {
+		if status := tenant.status(); status.ServerConnected || status.Clients > 0 {
+			active++
+		}
+	})
+	return active
+}
+
+// TenantStatus reports whether tenantID has a server and at least one client
+// connected to this relay
+func (r *Relay) TenantStatus(tenantID string) (server, client bool) {
+	tenant := r.tenants.lookup(tenantID)
+	if tenant == nil {
+		return false, false
+	}
+	status := tenant.status()
+	return status.ServerConnected, status.Clients > 0
+}
+
+// status returns the tenant's current status
+func (t *Tenant) status() TenantStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return TenantStatus{
+		TenantID:        t.tenantID,
+		ServerConnected: t.server != nil,
+		Clients:         len(t.clients),
+		LastActivity:    t.lastActivity,
+	}
+}
+
+// handleDeleteTenant closes a tenant's connections and removes it
+func (r *Relay) handleDeleteTenant(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req) {
+		return
+	}
+
+	tenantID := mux.Vars(req)["tenantID"]
+	if !r.disconnectTenant(tenantID) {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	slog.Info("Tenant disconnected by admin", "tenantID", tenantID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetLogLevel changes the log level at runtime, e.g. POST /admin/loglevel?level=debug
+func (r *Relay) handleSetLogLevel(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req) {
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.URL.Query().Get("level"))); err != nil {
+		http.Error(w, "invalid level: use debug, info, warn or error", http.StatusBadRequest)
+		return
+	}
+
+	applog.SetLevel(level)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"level": applog.Level().String(),
+	})
+}
+
+// handleRecentLogs returns the log lines retained in the relay's ring buffer,
+// oldest first
+func (r *Relay) handleRecentLogs(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req) {
+		return
+	}
+
+	lines := []string{}
+	if r.logs != nil {
+		lines = r.logs.Snapshot()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"lines": lines,
+	})
+}
+
+// DisconnectServer closes tenantID's server connection without a close
+// handshake, leaving its clients connected, and reports whether one was
+// connected. The server sees the connection drop as it would on a network
+// failure.
+func (r *Relay) DisconnectServer(tenantID string) bool {
+	tenant := r.tenants.lookup(tenantID)
+	if tenant == nil {
+		return false
+	}
+	tenant.mu.RLock()
+	server := tenant.server
+	tenant.mu.RUnlock()
+	if server == nil {
+		return false
+	}
+	server.close()
+	return true
+}
+
+// disconnectTenant closes all of a tenant's connections and removes it,
+// returning false if the tenant doesn't exist
+func (r *Relay) disconnectTenant(tenantID string) bool {
+	tenant := r.tenants.remove(tenantID)
+	if tenant == nil {
+		return false
+	}
+	tenant.close()
+
+	tenant.mu.Lock()
+	server := tenant.server
+	clients := tenant.snapshotClientsLocked()
+	tenant.mu.Unlock()
+
+	if server != nil {
+		server.close()
+	}
+	for _, client := range clients {
+		r.detachClient(tenant, client)
+	}
+	return true
+}
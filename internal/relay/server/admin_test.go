@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	applog "github.com/yuval/extauth-match/internal/log"
+)
+
+func adminRequest(t *testing.T, method, url, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	_, srv := newTestRelay(t, DefaultConfig())
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/admin/tenants"},
+		{http.MethodDelete, "/admin/tenants/" + testTenant},
+		{http.MethodPost, "/admin/loglevel?level=debug"},
+		{http.MethodGet, "/admin/logs"},
+	} {
+		if resp := adminRequest(t, tc.method, srv.URL+tc.path, "anything"); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s without an admin token configured: got %d, want 403", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminRejectsWrongToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuthMode = AuthModeSecret
+	cfg.AuthSecret = "browser-secret"
+	cfg.AdminToken = "admin-token"
+	_, srv := newTestRelay(t, cfg)
+
+	for _, token := range []string{"", "browser-secret", "wrong"} {
+		if resp := adminRequest(t, http.MethodGet, srv.URL+"/admin/tenants", token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d, want 401", token, resp.StatusCode)
+		}
+	}
+	// Query parameters end up in access logs, so the admin token is only read
+	// from the Authorization header
+	if resp := adminRequest(t, http.MethodGet, srv.URL+"/admin/tenants?token=admin-token", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("query token: got %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAdminListTenants(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin-token"
+	_, srv := newTestRelay(t, cfg)
+
+	dial(t, srv, "server", testTenant, nil)
+	dial(t, srv, "client", testTenant, nil)
+	dial(t, srv, "client", testTenant, nil)
+	dial(t, srv, "client", otherTenant, nil)
+
+	var tenants []TenantStatus
+	waitFor(t, "both tenants to be listed", func() bool {
+		resp := adminRequest(t, http.MethodGet, srv.URL+"/admin/tenants", "admin-token")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d, want 200", resp.StatusCode)
+		}
+		tenants = nil
+		if err := json.NewDecoder(resp.Body).Decode(&tenants); err != nil {
+			t.Fatal(err)
+		}
+		return len(tenants) == 2 && tenants[0].Clients == 2 && tenants[1].Clients == 1
+	})
+
+	if got := tenants[0]; got.TenantID != testTenant || !got.ServerConnected || got.LastActivity.IsZero() {
+		t.Errorf("first tenant = %+v, want %s with a server connected", got, testTenant)
+	}
+	if got := tenants[1]; got.TenantID != otherTenant || got.ServerConnected {
+		t.Errorf("second tenant = %+v, want %s without a server", got, otherTenant)
+	}
+}
+
+func TestAdminDeleteTenant(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin-token"
+	r, srv := newTestRelay(t, cfg)
+
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "tenant to connect", func() bool {
+		s, c := r.TenantStatus(testTenant)
+		return s && c
+	})
+
+	if resp := adminRequest(t, http.MethodDelete, srv.URL+"/admin/tenants/"+testTenant, "admin-token"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: got %d, want 204", resp.StatusCode)
+	}
+	for name, conn := range map[string]*websocket.Conn{"server": server, "client": client} {
+		if !closedWithin(conn, time.Second) {
+			t.Errorf("%s connection still open after delete", name)
+		}
+	}
+	if tenants := r.Snapshot(); len(tenants) != 0 {
+		t.Errorf("tenants after delete = %+v, want none", tenants)
+	}
+
+	if resp := adminRequest(t, http.MethodDelete, srv.URL+"/admin/tenants/"+testTenant, "admin-token"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete: got %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestParseConfigAdminTokenMustDifferFromAuthSecret(t *testing.T) {
+	if _, err := ParseConfig([]string{"--auth-mode", "secret", "--auth-secret", "same", "--admin-token", "same"}); err == nil {
+		t.Error("ParseConfig accepted an admin token equal to the auth secret")
+	}
+	if _, err := ParseConfig([]string{"--auth-mode", "secret", "--auth-secret", "browser", "--admin-token", "admin"}); err != nil {
+		t.Errorf("ParseConfig: %v", err)
+	}
+}
+
+func TestAdminSetLogLevel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin-token"
+	_, srv := newTestRelay(t, cfg)
+	previous := applog.Level()
+	t.Cleanup(func() { applog.SetLevel(previous) })
+
+	resp := adminRequest(t, http.MethodPost, srv.URL+"/admin/loglevel?level=debug", "admin-token")
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || body["level"] != "DEBUG" || applog.Level() != slog.LevelDebug {
+		t.Fatalf("set debug: %d %v, level now %v", resp.StatusCode, body, applog.Level())
+	}
+
+	for _, query := range []string{"?level=verbose", ""} {
+		if resp := adminRequest(t, http.MethodPost, srv.URL+"/admin/loglevel"+query, "admin-token"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("level %q: got %d, want 400", query, resp.StatusCode)
+		}
+	}
+	if applog.Level() != slog.LevelDebug {
+		t.Errorf("invalid level changed the level to %v", applog.Level())
+	}
+}
+
+func TestAdminRecentLogs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin-token"
+	r, srv := newTestRelay(t, cfg)
+
+	resp := adminRequest(t, http.MethodGet, srv.URL+"/admin/logs", "admin-token")
+	var body struct{ Lines []string }
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Lines == nil || len(body.Lines) != 0 {
+		t.Errorf("logs without a ring buffer = %v, %v, want an empty list", body.Lines, err)
+	}
+
+	logs := applog.NewRingBuffer(2)
+	logs.Write([]byte("first\nsecond\nthird\n"))
+	r.SetLogs(logs)
+	resp = adminRequest(t, http.MethodGet, srv.URL+"/admin/logs", "admin-token")
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Lines) != 2 || body.Lines[0] != "second" || body.Lines[1] != "third" {
+		t.Errorf("lines = %q, want the last two", body.Lines)
+	}
+}
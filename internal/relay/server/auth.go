@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+// Authentication modes for relay connections
+const (
+	AuthModeNone   = "none"
+	AuthModeSecret = "secret"
+	AuthModeHMAC   = "hmac"
+)
+
+var errMissingToken = errors.New("missing auth token")
+var errInvalidToken = errors.New("invalid auth token")
+
+// Authenticator decides whether a connection of role for a tenant may be upgraded
+type Authenticator interface {
+	Authenticate(req *http.Request, role Role, tenantID string) error
+}
+
+// SharedSecretAuthenticator accepts authz servers presenting the configured
+// secret, and browsers presenting the client token derived from it
+type SharedSecretAuthenticator struct {
+	Secret string
+}
+
+func (a SharedSecretAuthenticator) Authenticate(req *http.Request, role Role, tenantID string) error {
+	return compareToken(tokenFromRequest(req), roleToken(a.Secret, role, tenantID))
+}
+
+// HMACAuthenticator accepts authz servers presenting HMAC-SHA256(secret, tenantID),
+// and browsers presenting the client token derived from it
+type HMACAuthenticator struct {
+	Secret []byte
+}
+
+func (a HMACAuthenticator) Authenticate(req *http.Request, role Role, tenantID string) error {
+	return compareToken(tokenFromRequest(req), roleToken(crypto.TenantToken(a.Secret, tenantID), role, tenantID))
+}
+
+// roleToken returns the token a connection of role must present, given the
+// tenant's server token
+func roleToken(serverToken string, role Role, tenantID string) string {
+	if role == RoleClient {
+		return crypto.ClientToken(serverToken, tenantID)
+	}
+	return serverToken
+}
+
+// newAuthenticator builds the authenticator for the configured mode, or nil if auth is disabled
+func newAuthenticator(mode, secret string) Authenticator {
+	switch mode {
+	case AuthModeSecret:
+		return SharedSecretAuthenticator{Secret: secret}
+	case AuthModeHMAC:
+		return HMACAuthenticator{Secret: []byte(secret)}
+	default:
+		return nil
+	}
+}
+
+// tokenFromRequest reads a bearer token from the Authorization header, or the
+// token query parameter for browsers that can't set WebSocket headers
+func tokenFromRequest(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return token
+		}
+	}
+	return req.URL.Query().Get("token")
+}
+
+func compareToken(got, want string) error {
+	if got == "" {
+		return errMissingToken
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return errInvalidToken
+	}
+	return nil
+}
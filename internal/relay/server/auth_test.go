@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// bearer returns a header presenting token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// rejectedUnauthorized reports whether a dial that returned resp and err was
+// refused with 401 before the upgrade
+func rejectedUnauthorized(resp *http.Response, err error) bool {
+	return errors.Is(err, websocket.ErrBadHandshake) && resp != nil && resp.StatusCode == http.StatusUnauthorized
+}
+
+func TestSharedSecretAuthentication(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuthMode = AuthModeSecret
+	cfg.AuthSecret = "relay secret"
+	r, srv := newTestRelay(t, cfg)
+
+	dial(t, srv, "server", testTenant, bearer("relay secret"))
+	waitFor(t, "server to connect", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server
+	})
+
+	for name, header := range map[string]http.Header{
+		"wrong token":  bearer("guess"),
+		"no token":     nil,
+		"server token": bearer("relay secret"),
+	} {
+		_, resp, err := dialErr(srv, "client", testTenant, header)
+		if !rejectedUnauthorized(resp, err) {
+			t.Errorf("%s: connection not rejected as unauthorized (err %v)", name, err)
+		}
+	}
+}
+
+func TestHMACAuthentication(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuthMode = AuthModeHMAC
+	cfg.AuthSecret = "relay secret"
+	r, srv := newTestRelay(t, cfg)
+
+	// Browsers pass their token as a query parameter
+	token := crypto.ClientToken(crypto.TenantToken([]byte("relay secret"), testTenant), testTenant)
+	dialer := websocket.Dialer{Subprotocols: relayproto.Subprotocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/client/"+testTenant+"?token="+token, nil)
+	if err != nil {
+		t.Fatalf("dial with the tenant's token: %v", err)
+	}
+	defer conn.Close()
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	// Another tenant's token doesn't authenticate this one
+	_, resp, err := dialErr(srv, "client", otherTenant, bearer(token))
+	if !rejectedUnauthorized(resp, err) {
+		t.Errorf("token for another tenant accepted (err %v)", err)
+	}
+}
+
+func TestRolesHaveSeparateTokens(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuthMode = AuthModeHMAC
+	cfg.AuthSecret = "relay secret"
+	r, srv := newTestRelay(t, cfg)
+
+	serverToken := crypto.TenantToken([]byte("relay secret"), testTenant)
+	clientToken := crypto.ClientToken(serverToken, testTenant)
+
+	// A paired browser's token doesn't let it pose as the authz server
+	_, resp, err := dialErr(srv, "server", testTenant, bearer(clientToken))
+	if !rejectedUnauthorized(resp, err) {
+		t.Errorf("client token accepted for the server role (err %v)", err)
+	}
+	_, resp, err = dialErr(srv, "client", testTenant, bearer(serverToken))
+	if !rejectedUnauthorized(resp, err) {
+		t.Errorf("server token accepted for the client role (err %v)", err)
+	}
+
+	dial(t, srv, "server", testTenant, bearer(serverToken))
+	dial(t, srv, "client", testTenant, bearer(clientToken))
+	waitFor(t, "both roles to connect", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server && clients(r, testTenant) == 1
+	})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLargeBuffersForwardLargeMessage(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReadBufferSize = 64 << 10
+	cfg.WriteBufferSize = 64 << 10
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	// Several times the buffers, so each frame spans many reads and writes
+	frame := dataFrame(t, "req-1", strings.Repeat("approve ", 40<<10))
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, frame)
+	readAck(t, server)
+
+	reply := dataFrame(t, "req-1", strings.Repeat("decision ", 40<<10))
+	if err := client.WriteMessage(websocket.BinaryMessage, reply); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, server, reply)
+}
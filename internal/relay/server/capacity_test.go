@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestMaxTenantsEvictsOldestIdle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxTenants = 2
+	r, srv := newTestRelay(t, cfg)
+
+	for _, tenantID := range []string{testTenant, otherTenant} {
+		conn := dial(t, srv, "server", tenantID, nil)
+		conn.Close()
+		waitFor(t, "server to disconnect", func() bool {
+			server, _ := r.TenantStatus(tenantID)
+			return !server && r.tenants.lookup(tenantID) != nil
+		})
+		// Keep the two tenants' last activity apart
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	third := fmt.Sprintf("%024x", 3)
+	dial(t, srv, "server", third, nil)
+	waitFor(t, "third tenant to connect", func() bool {
+		server, _ := r.TenantStatus(third)
+		return server
+	})
+	if r.tenants.lookup(testTenant) != nil {
+		t.Error("least recently active idle tenant not evicted")
+	}
+	if r.tenants.lookup(otherTenant) == nil {
+		t.Error("more recently active idle tenant evicted")
+	}
+}
+
+// closeCode dials as a server for tenantID and returns the code the relay closes
+// the connection with
+func closeCode(t *testing.T, srv *httptest.Server, tenantID string) int {
+	t.Helper()
+	conn := dial(t, srv, "server", tenantID, nil)
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("read: %v, want a close frame", err)
+	}
+	return closeErr.Code
+}
+
+func TestMaxTenantsKeepsActiveTenants(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxTenants = 1
+	r, srv := newTestRelay(t, cfg)
+	active := dial(t, srv, "server", testTenant, nil)
+	waitFor(t, "tenant to connect", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server
+	})
+
+	if code := closeCode(t, srv, otherTenant); code != relayproto.CloseAtCapacity {
+		t.Errorf("new tenant at capacity closed with %d, want %d", code, relayproto.CloseAtCapacity)
+	}
+	if server, _ := r.TenantStatus(testTenant); !server || closedWithin(active, 50*time.Millisecond) {
+		t.Error("active tenant was disconnected to make room")
+	}
+}
+
+func TestMaxTenantsConcurrentConnects(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxTenants = 3
+	r, srv := newTestRelay(t, cfg)
+
+	// Every tenant stays connected, so none can be evicted and exactly the
+	// cap's worth are admitted however the connects interleave
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conns []*websocket.Conn
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := dialErr(srv, "server", fmt.Sprintf("%024x", i), nil)
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	rejected := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && closeErr.Code == relayproto.CloseAtCapacity {
+			rejected++
+		}
+	}
+	if n := r.tenants.len(); n != cfg.MaxTenants {
+		t.Errorf("tracking %d tenants, want %d", n, cfg.MaxTenants)
+	}
+	if rejected != 10-cfg.MaxTenants {
+		t.Errorf("%d connects rejected at capacity, want %d", rejected, 10-cfg.MaxTenants)
+	}
+}
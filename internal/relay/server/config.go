@@ -0,0 +1,296 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Buffer overflow policies for messages queued while no client is connected
+const (
+	BufferDropOldest = "drop-oldest"
+	BufferDropNewest = "drop-newest"
+)
+
+// Config holds the relay server settings
+type Config struct {
+	// Addr is the HTTP listen address
+	Addr string
+	// StaticDir overrides the embedded client page with index.html from disk
+	StaticDir string
+	// BufferSize is how many server messages are kept per tenant while no client is connected
+	BufferSize int
+	// BufferPolicy decides which message is dropped when the buffer is full
+	BufferPolicy string
+	// AllowedOrigins restricts browser WebSocket upgrades; empty allows all
+	AllowedOrigins []string
+	// AuthMode selects how connections authenticate: none, secret or hmac
+	AuthMode string
+	// AuthSecret is the shared secret (secret mode) or HMAC key (hmac mode)
+	AuthSecret string
+	// AdminToken is the bearer token the admin API requires; the admin API is
+	// disabled without one. It must differ from AuthSecret, which authz
+	// servers hold in secret mode.
+	AdminToken string
+	// TenantShards is how many partitions the tenant map is split into
+	TenantShards int
+	// MaxTenants caps how many tenants are tracked; 0 means no limit
+	MaxTenants int
+	// TenantTTL is how long a tenant with no connections is kept before being removed
+	TenantTTL time.Duration
+	// ReapInterval is how often idle tenants are checked for removal
+	ReapInterval time.Duration
+	// PingInterval is how often connections are pinged
+	PingInterval time.Duration
+	// PongTimeout is how long a connection may go without answering pings before it is closed
+	PongTimeout time.Duration
+	// HeartbeatTimeout closes a tenant's connections once neither peer has sent a
+	// frame for this long; 0 disables it
+	HeartbeatTimeout time.Duration
+	// WriteTimeout bounds each write to a peer; a peer that can't keep up is disconnected
+	WriteTimeout time.Duration
+	// MaxMessageSize is the largest frame accepted from either peer, in bytes
+	MaxMessageSize int64
+	// ReadBufferSize and WriteBufferSize size each connection's I/O buffers, in
+	// bytes. Larger buffers take fewer syscalls per large frame but cost memory
+	// for every open connection.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// RateLimit is the messages per second allowed per tenant in each direction; 0 disables
+	RateLimit float64
+	// RateBurst is how many messages may exceed RateLimit in a burst
+	RateBurst int
+	// TenantIDPattern is the regular expression tenant IDs must match
+	TenantIDPattern *regexp.Regexp
+	// AccessLog logs every HTTP request the relay serves
+	AccessLog bool
+	// Compression negotiates permessage-deflate with peers that offer it
+	Compression bool
+	// StrictSubprotocol rejects peers that don't offer a subprotocol; peers
+	// offering only unsupported ones are always rejected
+	StrictSubprotocol bool
+	// ConfigFile holds settings re-read on SIGHUP (see configFile)
+	ConfigFile string
+	// LogLevel is the level from the config file, applied when setLogLevel is set
+	LogLevel    slog.Level
+	setLogLevel bool
+	// TLSCert and TLSKey enable HTTPS/wss when both are set
+	TLSCert string
+	TLSKey  string
+}
+
+// LogLevelSet reports whether the config file sets LogLevel
+func (c Config) LogLevelSet() bool {
+	return c.setLogLevel
+}
+
+// TLSEnabled reports whether the relay should serve over TLS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// defaultTenantIDPattern matches the 24 hex characters DeriveTenantID produces
+var defaultTenantIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// DefaultConfig returns the relay defaults
+func DefaultConfig() Config {
+	addr := ":9090"
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		addr = ":" + envPort
+	}
+
+	return Config{
+		Addr:            addr,
+		BufferSize:      16,
+		BufferPolicy:    BufferDropOldest,
+		AuthMode:        AuthModeNone,
+		TenantShards:    16,
+		TenantTTL:       10 * time.Minute,
+		ReapInterval:    time.Minute,
+		PingInterval:    25 * time.Second,
+		PongTimeout:     60 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		MaxMessageSize:  1 << 20,
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		RateLimit:       10,
+		RateBurst:       20,
+		TenantIDPattern: defaultTenantIDPattern,
+	}
+}
+
+// ParseConfig reads relay settings from flags, falling back to environment variables
+func ParseConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", envString("RELAY_ADDR", cfg.Addr), "HTTP listen address")
+	fs.StringVar(&cfg.StaticDir, "static-dir", envString("RELAY_STATIC_DIR", cfg.StaticDir), "serve the client index.html from this directory instead of the embedded copy")
+	fs.IntVar(&cfg.BufferSize, "buffer-size", envInt("RELAY_BUFFER_SIZE", cfg.BufferSize), "server messages buffered per tenant until a client connects (0 disables)")
+	fs.StringVar(&cfg.BufferPolicy, "buffer-policy", envString("RELAY_BUFFER_POLICY", cfg.BufferPolicy), "buffer overflow policy: drop-oldest or drop-newest")
+	allowedOrigins := fs.String("allowed-origins", envString("RELAY_ALLOWED_ORIGINS", ""), "comma-separated list of allowed WebSocket origins (supports * wildcards)")
+	fs.StringVar(&cfg.AuthMode, "auth-mode", envString("RELAY_AUTH_MODE", cfg.AuthMode), "connection authentication: none, secret or hmac")
+	fs.StringVar(&cfg.AuthSecret, "auth-secret", envString("RELAY_AUTH_SECRET", ""), "shared secret for connection authentication")
+	fs.StringVar(&cfg.AdminToken, "admin-token", envString("RELAY_ADMIN_TOKEN", ""), "bearer token for the admin API, which is disabled without one")
+	fs.IntVar(&cfg.TenantShards, "tenant-shards", envInt("RELAY_TENANT_SHARDS", cfg.TenantShards), "number of partitions for the tenant map")
+	fs.IntVar(&cfg.MaxTenants, "max-tenants", envInt("RELAY_MAX_TENANTS", cfg.MaxTenants), "most tenants tracked at once, evicting the least recently active idle one (0 disables)")
+	fs.DurationVar(&cfg.TenantTTL, "tenant-ttl", envDuration("RELAY_TENANT_TTL", cfg.TenantTTL), "how long an idle tenant with no connections is kept")
+	fs.DurationVar(&cfg.ReapInterval, "reap-interval", envDuration("RELAY_REAP_INTERVAL", cfg.ReapInterval), "how often idle tenants are reaped")
+	fs.DurationVar(&cfg.PingInterval, "ping-interval", envDuration("RELAY_PING_INTERVAL", cfg.PingInterval), "how often connections are pinged")
+	fs.DurationVar(&cfg.PongTimeout, "pong-timeout", envDuration("RELAY_PONG_TIMEOUT", cfg.PongTimeout), "close connections that don't respond within this duration")
+	fs.DurationVar(&cfg.HeartbeatTimeout, "heartbeat-timeout", envDuration("RELAY_HEARTBEAT_TIMEOUT", cfg.HeartbeatTimeout), "close a tenant's connections when neither side sends a frame (PING frames count) for this long (0 disables)")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", envDuration("RELAY_WRITE_TIMEOUT", cfg.WriteTimeout), "disconnect peers that don't accept a write within this duration")
+	fs.Int64Var(&cfg.MaxMessageSize, "max-message-size", int64(envInt("RELAY_MAX_MESSAGE_SIZE", int(cfg.MaxMessageSize))), "largest WebSocket message accepted, in bytes")
+	fs.IntVar(&cfg.ReadBufferSize, "read-buffer-size", envInt("RELAY_READ_BUFFER_SIZE", cfg.ReadBufferSize), "per-connection read buffer size, in bytes")
+	fs.IntVar(&cfg.WriteBufferSize, "write-buffer-size", envInt("RELAY_WRITE_BUFFER_SIZE", cfg.WriteBufferSize), "per-connection write buffer size, in bytes")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", envFloat("RELAY_RATE_LIMIT", cfg.RateLimit), "messages per second allowed per tenant and direction (0 disables)")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", envInt("RELAY_RATE_BURST", cfg.RateBurst), "burst size for the per-tenant rate limit")
+	tenantIDPattern := fs.String("tenant-id-pattern", envString("RELAY_TENANT_ID_PATTERN", cfg.TenantIDPattern.String()), "regular expression tenant IDs must match")
+	fs.StringVar(&cfg.ConfigFile, "config-file", envString("RELAY_CONFIG_FILE", ""), "JSON file of settings reloaded on SIGHUP: allowedOrigins, rateLimit, rateBurst, authMode, authSecret, adminToken, logLevel")
+	fs.BoolVar(&cfg.Compression, "compression", envBool("RELAY_COMPRESSION", cfg.Compression), "negotiate permessage-deflate compression with peers that offer it")
+	fs.BoolVar(&cfg.StrictSubprotocol, "strict-subprotocol", envBool("RELAY_STRICT_SUBPROTOCOL", cfg.StrictSubprotocol), "reject WebSocket peers that don't offer a subprotocol (those offering only unsupported ones are always rejected)")
+	fs.BoolVar(&cfg.AccessLog, "access-log", envBool("RELAY_ACCESS_LOG", cfg.AccessLog), "log method, path, remote address, status and duration of every HTTP request")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", envString("RELAY_TLS_CERT", ""), "path to TLS certificate (enables wss)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", envString("RELAY_TLS_KEY", ""), "path to TLS private key (enables wss)")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	cfg.AllowedOrigins = splitList(*allowedOrigins)
+	pattern, err := compileTenantIDPattern(*tenantIDPattern)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TenantIDPattern = pattern
+	if cfg.ConfigFile != "" {
+		if err := applyConfigFile(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.BufferPolicy != BufferDropOldest && cfg.BufferPolicy != BufferDropNewest {
+		return cfg, fmt.Errorf("invalid buffer policy %q", cfg.BufferPolicy)
+	}
+	if cfg.StaticDir != "" {
+		if info, err := os.Stat(cfg.indexPath()); err != nil {
+			return cfg, fmt.Errorf("client page not found in static dir %q: %w", cfg.StaticDir, err)
+		} else if info.IsDir() {
+			return cfg, fmt.Errorf("client page %q is a directory", cfg.indexPath())
+		}
+	}
+	switch cfg.AuthMode {
+	case AuthModeNone:
+	case AuthModeSecret, AuthModeHMAC:
+		if cfg.AuthSecret == "" {
+			return cfg, fmt.Errorf("--auth-secret is required for auth mode %q", cfg.AuthMode)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid auth mode %q", cfg.AuthMode)
+	}
+	if cfg.AdminToken != "" && cfg.AdminToken == cfg.AuthSecret {
+		return cfg, fmt.Errorf("--admin-token must differ from --auth-secret")
+	}
+	if cfg.TenantShards < 1 {
+		return cfg, fmt.Errorf("tenant shards must be at least 1")
+	}
+	if cfg.MaxTenants < 0 {
+		return cfg, fmt.Errorf("max tenants must not be negative")
+	}
+	if cfg.ReapInterval <= 0 {
+		return cfg, fmt.Errorf("reap interval must be positive")
+	}
+	if cfg.PingInterval <= 0 || cfg.PongTimeout <= cfg.PingInterval {
+		return cfg, fmt.Errorf("ping interval must be positive and shorter than the pong timeout")
+	}
+	if cfg.ReadBufferSize < 1 || cfg.WriteBufferSize < 1 {
+		return cfg, fmt.Errorf("read and write buffer sizes must be positive")
+	}
+	if cfg.HeartbeatTimeout < 0 {
+		return cfg, fmt.Errorf("heartbeat timeout must not be negative")
+	}
+	if cfg.RateLimit < 0 {
+		return cfg, fmt.Errorf("rate limit must not be negative")
+	}
+	if cfg.MaxMessageSize <= 0 {
+		return cfg, fmt.Errorf("max message size must be positive")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("both --tls-cert and --tls-key must be set to enable TLS")
+	}
+	return cfg, nil
+}
+
+// compileTenantIDPattern compiles the regular expression tenant IDs must match
+func compileTenantIDPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID pattern: %w", err)
+	}
+	return re, nil
+}
+
+// indexPath returns the path of the client HTML page
+func (c Config) indexPath() string {
+	return filepath.Join(c.StaticDir, "index.html")
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func envBool(name string, def bool) bool {
+	if v := os.Getenv(name); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func envFloat(name string, def float64) float64 {
+	if v := os.Getenv(name); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
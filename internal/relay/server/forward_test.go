@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// readData reads the next frame from conn and fails unless it is the DATA
+// frame want
+func readData(t *testing.T, conn *websocket.Conn, want []byte) {
+	t.Helper()
+	frameType, payload := readFrame(t, conn)
+	if frameType != relayproto.FrameData {
+		t.Fatalf("got %s frame, want DATA", frameType)
+	}
+	if _, wantPayload, _ := relayproto.DecodeFrame(want); !bytes.Equal(payload, wantPayload) {
+		t.Fatal("forwarded frame differs from the one sent")
+	}
+}
+
+func TestBroadcastToAllClients(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	first := dial(t, srv, "client", testTenant, nil)
+	second := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "clients to attach", func() bool { return clients(r, testTenant) == 2 })
+
+	frame := dataFrame(t, "req-1", "ciphertext")
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, first, frame)
+	readData(t, second, frame)
+}
+
+// clients returns how many clients tenantID has attached
+func clients(r *Relay, tenantID string) int {
+	for _, status := range r.Snapshot() {
+		if status.TenantID == tenantID {
+			return status.Clients
+		}
+	}
+	return 0
+}
+
+func TestBufferedUntilClientConnects(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+
+	frames := [][]byte{dataFrame(t, "req-1", "first"), dataFrame(t, "req-2", "second")}
+	for _, frame := range frames {
+		if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		if ack := readAck(t, server); !ack.Buffered {
+			t.Fatalf("ack = %+v, want buffered", ack)
+		}
+	}
+
+	client := dial(t, srv, "client", testTenant, nil)
+	for _, frame := range frames {
+		readData(t, client, frame)
+	}
+	waitFor(t, "buffer to empty", func() bool {
+		tenant := r.tenants.lookup(testTenant)
+		tenant.mu.RLock()
+		defer tenant.mu.RUnlock()
+		return len(tenant.pending) == 0
+	})
+}
+
+func TestServerReconnectReplacesConnection(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	first := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	second := dial(t, srv, "server", testTenant, nil)
+	if !closedWithin(first, time.Second) {
+		t.Fatal("replaced server connection left open")
+	}
+	// The first connection's forward goroutine mustn't detach its replacement
+	if server, _ := r.TenantStatus(testTenant); !server {
+		t.Fatal("replacement server detached")
+	}
+
+	frame := dataFrame(t, "req-1", "ciphertext")
+	if err := second.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, frame)
+}
+
+func TestSlowClientDropped(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 50 * time.Millisecond
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	// The stalled client never reads, so its socket buffers fill up
+	dial(t, srv, "client", testTenant, nil)
+	reading := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "clients to attach", func() bool { return clients(r, testTenant) == 2 })
+	go func() {
+		for {
+			if _, _, err := reading.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	frame := dataFrame(t, "req-1", strings.Repeat("x", 256<<10))
+	deadline := time.Now().Add(5 * time.Second)
+	server.SetWriteDeadline(deadline)
+	for clients(r, testTenant) == 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client never dropped")
+		}
+		if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatalf("server write blocked behind the stalled client: %v", err)
+		}
+	}
+
+	if server, _ := r.TenantStatus(testTenant); !server {
+		t.Error("server dropped along with the stalled client")
+	}
+	if n := clients(r, testTenant); n != 1 {
+		t.Errorf("%d clients attached, want the reading one", n)
+	}
+}
+
+func TestStalledClientDroppedDuringFlush(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 50 * time.Millisecond
+	cfg.BufferSize = 32
+	cfg.RateLimit = 0
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+
+	// More backlog than the socket buffers hold, so the flush to a client
+	// that never reads stalls
+	frame := dataFrame(t, "req-1", strings.Repeat("x", 768<<10))
+	for range cfg.BufferSize {
+		if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		if ack := readAck(t, server); !ack.Buffered {
+			t.Fatalf("ack = %+v, want buffered", ack)
+		}
+	}
+	// The client never reads; sending to the tenant still completes, and the
+	// client is dropped
+	dial(t, srv, "client", testTenant, nil)
+	if err := server.WriteMessage(websocket.BinaryMessage, dataFrame(t, "req-2", "after")); err != nil {
+		t.Fatal(err)
+	}
+	readAck(t, server)
+	waitFor(t, "stalled client to detach", func() bool { return clients(r, testTenant) == 0 })
+	if server, _ := r.TenantStatus(testTenant); !server {
+		t.Error("server dropped along with the stalled client")
+	}
+}
+
+func TestCancelDropsBufferedRequest(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+
+	if ack := sendAndReadAck(t, server, "req-1"); !ack.Buffered {
+		t.Fatalf("ack = %+v, want buffered", ack)
+	}
+	cancel, err := json.Marshal(relayproto.ControlFrame{Type: relayproto.ControlTypeCancel, RequestID: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteMessage(websocket.BinaryMessage, relayproto.EncodeFrame(relayproto.FrameControl, cancel)); err != nil {
+		t.Fatal(err)
+	}
+	kept := dataFrame(t, "req-2", "ciphertext")
+	if err := server.WriteMessage(websocket.BinaryMessage, kept); err != nil {
+		t.Fatal(err)
+	}
+	readAck(t, server)
+
+	// The cancelled request never reaches a client that connects afterwards
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+	readData(t, client, kept)
+}
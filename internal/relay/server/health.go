@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleHealthz reports liveness; it succeeds whenever the process can serve HTTP
+func (r *Relay) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports readiness; it fails before the listener is up and once shutdown begins
+func (r *Relay) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	if !r.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready\n"))
+}
+
+// SetReady marks the relay as ready or not ready to receive traffic
+func (r *Relay) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
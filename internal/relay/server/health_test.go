@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// status returns the status code of a GET for url
+func status(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	if code := status(t, srv.URL+"/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	if code := status(t, srv.URL+"/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d, want 200", code)
+	}
+
+	// Shutting down: still alive, no longer ready
+	r.SetReady(false)
+	if code := status(t, srv.URL+"/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz while shutting down = %d, want 200", code)
+	}
+	if code := status(t, srv.URL+"/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while shutting down = %d, want 503", code)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestHeartbeatDropsSilentTenant(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HeartbeatTimeout = 100 * time.Millisecond
+	r, srv := newTestRelay(t, cfg)
+	runRelay(t, r)
+
+	silentServer := dial(t, srv, "server", testTenant, nil)
+	silentClient := dial(t, srv, "client", testTenant, nil)
+	pingingServer := dial(t, srv, "server", otherTenant, nil)
+	dial(t, srv, "client", otherTenant, nil)
+	waitFor(t, "clients to attach", func() bool { return clients(r, testTenant) == 1 && clients(r, otherTenant) == 1 })
+
+	// One peer sending PING frames keeps its tenant alive
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(cfg.HeartbeatTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if pingingServer.WriteMessage(websocket.BinaryMessage, relayproto.EncodeFrame(relayproto.FramePing, nil)) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	if !closedWithin(silentServer, time.Second) {
+		t.Error("silent tenant's server not closed")
+	}
+	if !closedWithin(silentClient, time.Second) {
+		t.Error("silent tenant's client not closed")
+	}
+	time.Sleep(2 * cfg.HeartbeatTimeout)
+	if clients(r, otherTenant) != 1 {
+		t.Error("pinging tenant's client dropped")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveClosesUnresponsivePeer(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PingInterval = 20 * time.Millisecond
+	cfg.PongTimeout = 60 * time.Millisecond
+	r, srv := newTestRelay(t, cfg)
+
+	// gorilla answers pings from inside a read, so a peer that keeps reading
+	// pongs and one that never reads doesn't
+	responsive := dial(t, srv, "client", testTenant, nil)
+	go func() {
+		for {
+			if _, _, err := responsive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	dial(t, srv, "server", testTenant, nil)
+	waitFor(t, "peers to connect", func() bool {
+		server, client := r.TenantStatus(testTenant)
+		return server && client
+	})
+
+	start := time.Now()
+	waitFor(t, "silent server to be dropped", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return !server
+	})
+	if elapsed := time.Since(start); elapsed > 5*cfg.PongTimeout {
+		t.Errorf("dropped after %v, want within about %v", elapsed, cfg.PongTimeout)
+	}
+	if _, client := r.TenantStatus(testTenant); !client {
+		t.Error("client answering pings was dropped")
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOversizedMessageClosesConnection(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxMessageSize = 1024
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	// A frame within the limit is forwarded
+	frame := dataFrame(t, "req-1", "small")
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, frame)
+	readAck(t, server)
+
+	if err := client.WriteMessage(websocket.BinaryMessage, dataFrame(t, "req-1", strings.Repeat("x", 2048))); err != nil {
+		t.Fatal(err)
+	}
+	if !closedWithin(client, time.Second) {
+		t.Error("client sending an over-limit frame wasn't disconnected")
+	}
+}
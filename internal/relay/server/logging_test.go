@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// logRecords captures the default logger's records as JSON until the test ends
+type logRecords struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logRecords) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func captureLogs(t *testing.T) *logRecords {
+	logs := &logRecords{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return logs
+}
+
+// find returns the first record with message msg, or nil
+func (l *logRecords) find(msg string) map[string]any {
+	return l.findWith(msg, "", nil)
+}
+
+// findWith returns the first record with message msg whose attribute key is
+// value, or nil. An empty key matches any record with message msg.
+func (l *logRecords) findWith(msg, key string, value any) map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range bytes.Split(l.buf.Bytes(), []byte("\n")) {
+		var record map[string]any
+		if json.Unmarshal(line, &record) == nil && record["msg"] == msg && (key == "" || record[key] == value) {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestLogsCarryTenantID(t *testing.T) {
+	logs := captureLogs(t)
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+	if err := server.WriteMessage(websocket.BinaryMessage, dataFrame(t, "req-1", "ciphertext")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "forward to be logged", func() bool { return logs.find("Forwarded message") != nil })
+
+	for _, msg := range []string{"Authz server connected", "Browser client connected", "Forwarded message"} {
+		record := logs.find(msg)
+		if record == nil || record["tenantID"] != testTenant {
+			t.Errorf("%q record = %v, want tenantID %s", msg, record, testTenant)
+		}
+	}
+	if direction := logs.find("Forwarded message")["direction"]; direction != "server->client" {
+		t.Errorf("direction = %v, want server->client", direction)
+	}
+}
+
+func TestLogsCarryRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	request := dataFrame(t, "req-42", "ciphertext")
+	if err := server.WriteMessage(websocket.BinaryMessage, request); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, request)
+	decision := dataFrame(t, "req-42", "decision")
+	if err := client.WriteMessage(websocket.BinaryMessage, decision); err != nil {
+		t.Fatal(err)
+	}
+	readAck(t, server)
+	readData(t, server, decision)
+
+	for _, direction := range []string{"server->client", "client->server"} {
+		waitFor(t, direction+" forward to be logged", func() bool {
+			return logs.findWith("Forwarded message", "direction", direction) != nil
+		})
+		if record := logs.findWith("Forwarded message", "direction", direction); record["requestID"] != "req-42" {
+			t.Errorf("%s requestID = %v, want req-42", direction, record["requestID"])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the histogram upper bounds, in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram is a lock-free latency histogram rendered in the Prometheus text format
+type histogram struct {
+	buckets []atomic.Uint64 // non-cumulative counts per bucket, plus +Inf
+	sumNs   atomic.Int64
+	count   atomic.Uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]atomic.Uint64, len(latencyBuckets)+1)}
+}
+
+// observe records one duration
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(latencyBuckets) && seconds > latencyBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.sumNs.Add(int64(d))
+	h.count.Add(1)
+}
+
+// write renders the histogram's series with the given label set
+func (h *histogram) write(w http.ResponseWriter, name, labels string) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.buckets[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.buckets[len(latencyBuckets)].Load()
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, time.Duration(h.sumNs.Load()).Seconds())
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count.Load())
+}
+
+// relayMetrics holds the relay's instrumentation
+type relayMetrics struct {
+	// Forward latency, from a message being read to it being written, per direction
+	serverToClient *histogram
+	clientToServer *histogram
+}
+
+func newRelayMetrics() *relayMetrics {
+	return &relayMetrics{
+		serverToClient: newHistogram(),
+		clientToServer: newHistogram(),
+	}
+}
+
+// handleMetrics serves the relay metrics in the Prometheus text format
+func (r *Relay) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	const name = "relay_forward_latency_seconds"
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP %s Time from reading a message from one peer to writing it to the other.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	r.metrics.serverToClient.write(w, name, `direction="server->client"`)
+	r.metrics.clientToServer.write(w, name, `direction="client->server"`)
+}
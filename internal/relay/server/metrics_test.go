@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// forwardCount scrapes /metrics and returns the forward latency histogram's
+// count for direction
+func forwardCount(t *testing.T, url, direction string) int {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf("relay_forward_latency_seconds_count{direction=%q} ", direction)
+	for _, line := range strings.Split(string(body), "\n") {
+		if rest, ok := strings.CutPrefix(line, prefix); ok {
+			var n int
+			if _, err := fmt.Sscan(rest, &n); err != nil {
+				t.Fatalf("bad count line %q: %v", line, err)
+			}
+			return n
+		}
+	}
+	t.Fatalf("no %s series in:\n%s", prefix, body)
+	return 0
+}
+
+func TestForwardLatencyHistogram(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = 0
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	if n := forwardCount(t, srv.URL, "server->client"); n != 0 {
+		t.Fatalf("server->client count = %d before forwarding, want 0", n)
+	}
+	for i := range 3 {
+		frame := dataFrame(t, fmt.Sprintf("req-%d", i), "ciphertext")
+		if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		readAck(t, server)
+		readData(t, client, frame)
+	}
+	for i := range 2 {
+		frame := dataFrame(t, fmt.Sprintf("req-%d", i), "decision")
+		if err := client.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		readData(t, server, frame)
+	}
+
+	waitFor(t, "observations", func() bool {
+		return forwardCount(t, srv.URL, "server->client") == 3 && forwardCount(t, srv.URL, "client->server") == 2
+	})
+}
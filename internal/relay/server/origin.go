@@ -0,0 +1,48 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// originAllowed reports whether origin matches the allowlist. An entry of "*"
+// matches any origin, and an entry like "https://*.example.com" matches any
+// subdomain of example.com over https.
+func originAllowed(origin string, allowlist []string) bool {
+	for _, allowed := range allowlist {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOrigin returns a CheckOrigin function for the websocket upgrader. An
+// empty allowlist accepts every origin.
+func checkOrigin(allowlist []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		if len(allowlist) == 0 {
+			return true
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Non-browser clients (such as the authz server) don't send an Origin
+			return true
+		}
+
+		if !originAllowed(origin, allowlist) {
+			slog.Warn("Rejected WebSocket upgrade from disallowed origin", "origin", origin, "path", r.URL.Path)
+			return false
+		}
+		return true
+	}
+}
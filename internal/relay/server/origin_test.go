@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowlist := []string{"https://approve.example.com", "https://*.example.org"}
+	for _, tc := range []struct {
+		origin string
+		want   bool
+	}{
+		{"https://approve.example.com", true},
+		{"HTTPS://Approve.Example.com", true},
+		{"https://evil.example.com", false},
+		{"http://approve.example.com", false},
+		{"https://phone.example.org", true},
+		{"https://.example.org", false},
+		{"https://example.org.evil.com", false},
+	} {
+		if got := originAllowed(tc.origin, allowlist); got != tc.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+	if !originAllowed("https://anything.test", []string{"*"}) {
+		t.Error("wildcard entry rejected an origin")
+	}
+}
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowedOrigins = []string{"https://approve.example.com"}
+	_, srv := newTestRelay(t, cfg)
+
+	for _, tc := range []struct {
+		origin string
+		want   bool
+	}{
+		{"https://approve.example.com", true},
+		{"https://evil.example.com", false},
+		// The authz server sends no Origin
+		{"", true},
+	} {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		conn, resp, err := dialErr(srv, "client", testTenant, header)
+		if got := err == nil; got != tc.want {
+			t.Errorf("origin %q: upgraded = %v, want %v", tc.origin, got, tc.want)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		if !tc.want && resp != nil && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %q: status = %d, want 403", tc.origin, resp.StatusCode)
+		}
+	}
+
+	cfg.AllowedOrigins = []string{"*"}
+	_, srv = newTestRelay(t, cfg)
+	conn, _, err := dialErr(srv, "client", testTenant, http.Header{"Origin": {"https://evil.example.com"}})
+	if err != nil {
+		t.Fatalf("wildcard allowlist rejected an origin: %v", err)
+	}
+	conn.Close()
+}
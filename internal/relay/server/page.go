@@ -0,0 +1,86 @@
+package server
+
+import (
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/yuval/extauth-match/web"
+)
+
+// pageData is rendered into the client page template. html/template escapes
+// each field for the context it appears in, so values from the request can't
+// inject markup or script.
+type pageData struct {
+	TenantID string
+	// Key is the base64 key when passed as a query parameter; the URL fragment
+	// remains the preferred way to deliver it since fragments never reach the relay
+	Key string
+}
+
+// clientPage renders the browser client, from the embedded assets or from
+// StaticDir on disk when one is configured
+type clientPage struct {
+	staticDir string
+	embedded  *template.Template
+}
+
+func newClientPage(staticDir string) (*clientPage, error) {
+	page := &clientPage{staticDir: staticDir}
+	if staticDir != "" {
+		return page, nil
+	}
+
+	static, err := fs.Sub(web.Static, "static")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.ParseFS(static, "index.html")
+	if err != nil {
+		return nil, err
+	}
+	page.embedded = tmpl
+	return page, nil
+}
+
+// template returns the page template, re-reading it from disk on every call
+// in development so edits show up without a restart
+func (p *clientPage) template() (*template.Template, error) {
+	if p.embedded != nil {
+		return p.embedded, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(p.staticDir, "index.html"))
+	if err != nil {
+		return nil, err
+	}
+	return template.New("index.html").Parse(string(content))
+}
+
+// handleClientPage serves /s/{tenantID} with the tenant ID and optional key injected
+func (r *Relay) handleClientPage(w http.ResponseWriter, req *http.Request) {
+	tenantID := mux.Vars(req)["tenantID"]
+	if !r.validateTenantID(w, req, tenantID) {
+		return
+	}
+
+	tmpl, err := r.page.template()
+	if err != nil {
+		slog.Error("Failed to load client page", "error", err)
+		http.Error(w, "client page unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := pageData{
+		TenantID: tenantID,
+		Key:      req.URL.Query().Get("key"),
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.Error("Failed to render client page", "tenantID", tenantID, "error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fetchPage returns the client page served at url
+func fetchPage(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET client page: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("client page status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	return string(body)
+}
+
+func TestEmbeddedClientPage(t *testing.T) {
+	_, srv := newTestRelay(t, DefaultConfig())
+
+	page := fetchPage(t, srv.URL+"/s/"+testTenant)
+	if !strings.Contains(page, `const injectedTenantID = "`+testTenant+`";`) {
+		t.Errorf("embedded page doesn't inject the tenant ID:\n%s", page)
+	}
+
+	resp, err := http.Get(srv.URL + "/s/not-a-tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("page for an invalid tenant ID: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestClientPageEscapesInjectedValues(t *testing.T) {
+	// A permissive pattern lets markup reach the template through the tenant ID
+	cfg := DefaultConfig()
+	cfg.TenantIDPattern = regexp.MustCompile(`^[^/]+$`)
+	_, srv := newTestRelay(t, cfg)
+
+	hostile := `</script><script>alert(1)</script>`
+	page := fetchPage(t, srv.URL+"/s/"+url.PathEscape(`a"b`)+"?key="+url.QueryEscape(hostile))
+	if strings.Contains(page, hostile) {
+		t.Fatal("key injected into the page unescaped")
+	}
+	if !strings.Contains(page, `const injectedTenantID = "a\"b";`) {
+		t.Errorf("tenant ID not escaped as a JS string:\n%s", page)
+	}
+	if !strings.Contains(page, `const injectedKey = "\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e";`) {
+		t.Errorf("key not escaped as a JS string:\n%s", page)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token-bucket rate limiter. The rate and burst are
+// passed on every call, so a reloaded limit applies to the next event.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// newTokenBucket returns a limiter that starts full
+func newTokenBucket() *tokenBucket {
+	return &tokenBucket{}
+}
+
+// allow consumes a token if one is available, refilling at rate tokens per
+// second up to burst. A rate of zero or less always allows.
+func (b *tokenBucket) allow(rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	capacity := float64(max(burst, 1))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket()
+	for i := range 3 {
+		if !b.allow(1, 3) {
+			t.Fatalf("event %d within the burst refused", i)
+		}
+	}
+	if b.allow(1, 3) {
+		t.Error("event past the burst allowed")
+	}
+	if !newTokenBucket().allow(0, 0) {
+		t.Error("zero rate limited an event")
+	}
+}
+
+func TestRateLimitDropsClientMessages(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = 0.001
+	cfg.RateBurst = 2
+	r, srv := newTestRelay(t, cfg)
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	for _, id := range []string{"req-1", "req-2", "req-3", "req-4"} {
+		if err := client.WriteMessage(websocket.BinaryMessage, dataFrame(t, id, "decision")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"req-1", "req-2"} {
+		readData(t, server, dataFrame(t, id, "decision"))
+	}
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := server.ReadMessage(); err == nil {
+		t.Error("message past the burst was forwarded")
+	}
+	tenant := r.tenants.lookup(testTenant)
+	if dropped := tenant.clientDropped.Load(); dropped != 2 {
+		t.Errorf("dropped = %d, want 2", dropped)
+	}
+}
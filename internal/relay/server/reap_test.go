@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// runRelay runs r's background tasks until the test ends
+func runRelay(t *testing.T, r *Relay) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go r.Run(ctx)
+}
+
+func TestIdleTenantReaped(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TenantTTL = 50 * time.Millisecond
+	cfg.ReapInterval = 10 * time.Millisecond
+	r, srv := newTestRelay(t, cfg)
+	runRelay(t, r)
+
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	dial(t, srv, "server", otherTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	// A connected tenant outlives the TTL
+	time.Sleep(2 * cfg.TenantTTL)
+	if r.tenants.lookup(testTenant) == nil {
+		t.Fatal("connected tenant reaped")
+	}
+
+	server.Close()
+	client.Close()
+	waitFor(t, "idle tenant to be reaped", func() bool { return r.tenants.lookup(testTenant) == nil })
+	if r.tenants.lookup(otherTenant) == nil {
+		t.Error("tenant with a connected server reaped")
+	}
+}
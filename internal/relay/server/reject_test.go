@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+func TestRejectionStatusWithoutUpgrade(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuthMode = AuthModeSecret
+	cfg.AuthSecret = "relay secret"
+	_, srv := newTestRelay(t, cfg)
+
+	tests := []struct {
+		name      string
+		tenantID  string
+		rejection relayproto.Rejection
+	}{
+		{"invalid tenant", "not-a-tenant", relayproto.RejectInvalidTenant},
+		{"tenant ID too long", strings.Repeat("a", relayproto.MaxTenantIDLength+1), relayproto.RejectTenantIDTooLong},
+		{"unauthorized", testTenant, relayproto.RejectUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/ws/server/" + tt.tenantID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.rejection.Status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.rejection.Status)
+			}
+		})
+	}
+}
+
+func TestUnsupportedSubprotocolCloseCode(t *testing.T) {
+	_, srv := newTestRelay(t, DefaultConfig())
+	dialer := websocket.Dialer{Subprotocols: []string{"bogus.v0"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/server/"+testTenant, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != relayproto.CloseUnsupportedProtocol {
+		t.Errorf("read: %v, want close code %d", err, relayproto.CloseUnsupportedProtocol)
+	}
+}
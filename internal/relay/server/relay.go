@@ -0,0 +1,1119 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	applog "github.com/yuval/extauth-match/internal/log"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// peerConn is a server or browser connection attached to a tenant
+type peerConn struct {
+	conn         *websocket.Conn
+	writeMu      sync.Mutex // Protects writes to conn
+	writeTimeout time.Duration
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func newPeerConn(conn *websocket.Conn, writeTimeout time.Duration) *peerConn {
+	return &peerConn{
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		done:         make(chan struct{}),
+	}
+}
+
+// write sends a message, failing if the peer doesn't accept it within the
+// write timeout so a slow consumer can't stall the forwarding goroutine
+func (p *peerConn) write(messageType int, data []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.writeLocked(messageType, data)
+}
+
+// writeLocked is write for a caller already holding writeMu
+func (p *peerConn) writeLocked(messageType int, data []byte) error {
+	if p.writeTimeout > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+	}
+	return p.conn.WriteMessage(messageType, data)
+}
+
+// close closes the underlying connection and stops its keepalive
+func (p *peerConn) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+// bufferedMessage is a server message waiting for a client to connect
+type bufferedMessage struct {
+	messageType int
+	data        []byte
+}
+
+type Tenant struct {
+	tenantID string
+	server   *peerConn
+	clients  map[*peerConn]struct{}
+	pending  []bufferedMessage
+	// lastActivity is when the tenant last connected or forwarded a message
+	lastActivity time.Time
+	mu           sync.RWMutex
+	// lastHeard is when a peer last connected or sent any frame, in Unix nanoseconds
+	lastHeard atomic.Int64
+
+	// Per-direction rate limiters, at the relay's current limits, and counts of
+	// messages they dropped
+	serverLimiter *tokenBucket
+	clientLimiter *tokenBucket
+	serverDropped atomic.Uint64
+	clientDropped atomic.Uint64
+
+	// unsubscribe stops bus delivery for this tenant once it is removed
+	unsubscribe func()
+}
+
+// idleLocked reports whether the tenant has no connections and has been idle
+// for at least ttl; t.mu must be held
+func (t *Tenant) idleLocked(now time.Time, ttl time.Duration) bool {
+	return t.server == nil && len(t.clients) == 0 && now.Sub(t.lastActivity) >= ttl
+}
+
+// heard records that a peer connected or sent a frame
+func (t *Tenant) heard(now time.Time) {
+	t.lastHeard.Store(now.UnixNano())
+}
+
+// silentLocked reports whether the tenant has connections but none of its peers
+// has sent a frame for at least timeout; t.mu must be held
+func (t *Tenant) silentLocked(now time.Time, timeout time.Duration) bool {
+	if t.server == nil && len(t.clients) == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, t.lastHeard.Load())) >= timeout
+}
+
+// close releases resources held for a removed tenant
+func (t *Tenant) close() {
+	if t.unsubscribe != nil {
+		t.unsubscribe()
+	}
+}
+
+// snapshotClientsLocked returns the currently attached clients; t.mu must be held
+func (t *Tenant) snapshotClientsLocked() []*peerConn {
+	clients := make([]*peerConn, 0, len(t.clients))
+	for c := range t.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// removeClient detaches a client, returning false if it was already removed
+func (t *Tenant) removeClient(c *peerConn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, exists := t.clients[c]
+	delete(t.clients, c)
+	t.lastActivity = time.Now()
+	return exists
+}
+
+// clientsOrBuffer returns the attached clients, or buffers the message if
+// there are none and reports whether it was buffered
+func (t *Tenant) clientsOrBuffer(cfg Config, messageType int, data []byte) ([]*peerConn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastActivity = time.Now()
+
+	if len(t.clients) > 0 {
+		return t.snapshotClientsLocked(), false
+	}
+
+	if cfg.BufferSize <= 0 {
+		return nil, false
+	}
+
+	if len(t.pending) >= cfg.BufferSize {
+		if cfg.BufferPolicy == BufferDropNewest {
+			slog.Warn("Client buffer full, dropping message", "tenantID", t.tenantID, "bytes", len(data))
+			return nil, false
+		}
+		slog.Warn("Client buffer full, dropping oldest message", "tenantID", t.tenantID, "bytes", len(t.pending[0].data))
+		t.pending = t.pending[1:]
+	}
+	t.pending = append(t.pending, bufferedMessage{messageType: messageType, data: data})
+	slog.Debug("Buffered message until a client connects", "tenantID", t.tenantID, "buffered", len(t.pending))
+	return nil, true
+}
+
+// dropBuffered removes the buffered DATA frame carrying requestID, reporting
+// whether there was one
+func (t *Tenant) dropBuffered(requestID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.pending)
+	t.pending = slices.DeleteFunc(t.pending, func(msg bufferedMessage) bool {
+		return requestIDOf(msg.data) == requestID
+	})
+	return len(t.pending) < n
+}
+
+type Relay struct {
+	cfg      Config
+	upgrader websocket.Upgrader
+	// live holds the settings Reload can swap while the relay runs
+	live atomic.Pointer[liveConfig]
+	page *clientPage
+	// logs retains recent log lines for the admin API; nil if not kept
+	logs    *applog.RingBuffer
+	tenants tenantShards
+	// admitMu serializes creating tenants while MaxTenants is set
+	admitMu  sync.Mutex
+	ready    atomic.Bool
+	forwards sync.WaitGroup // Tracks running forward goroutines
+	// draining is cancelled by Shutdown; forward goroutines then finish the
+	// message in hand and stop reading
+	draining    context.Context
+	stopForward context.CancelFunc
+	metrics     *relayMetrics
+
+	// instanceID identifies this relay to the store and bus when running several instances
+	instanceID string
+	store      Store
+	bus        Bus
+}
+
+// NewRelay creates a relay that keeps all tenant state in memory. It fails if
+// cfg has no tenant ID pattern.
+func NewRelay(cfg Config) (*Relay, error) {
+	return NewRelayWithBackend(cfg, NewMemoryStore(), NewMemoryBus())
+}
+
+// NewRelayWithBackend creates a relay sharing tenant presence and messages
+// with other instances through store and bus
+func NewRelayWithBackend(cfg Config, store Store, bus Bus) (*Relay, error) {
+	if cfg.TenantIDPattern == nil {
+		return nil, fmt.Errorf("tenant ID pattern is required")
+	}
+	r := &Relay{
+		instanceID: newInstanceID(),
+		store:      store,
+		bus:        bus,
+		cfg:        cfg,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    cfg.ReadBufferSize,
+			WriteBufferSize:   cfg.WriteBufferSize,
+			Subprotocols:      relayproto.Subprotocols,
+			EnableCompression: cfg.Compression,
+		},
+		tenants: newTenantShards(cfg.TenantShards),
+		metrics: newRelayMetrics(),
+	}
+	r.draining, r.stopForward = context.WithCancel(context.Background())
+	r.upgrader.CheckOrigin = r.checkOrigin
+	r.upgrader.Error = upgradeFailed
+	r.live.Store(newLiveConfig(cfg))
+	return r, nil
+}
+
+// upgradeFailed is the upgrader's Error func, called when a handshake fails
+// before the connection is taken over. It replies with the status and the
+// reason, which the upgrader's default reply leaves out, and logs the failure
+// as the peer's fault for a 4xx status and the relay's for a 5xx.
+func upgradeFailed(w http.ResponseWriter, req *http.Request, status int, reason error) {
+	tenantID := mux.Vars(req)["tenantID"]
+	if status >= http.StatusInternalServerError {
+		slog.Error("WebSocket upgrade failed", "tenantID", tenantID, "remoteAddr", req.RemoteAddr, "status", status, "error", reason)
+	} else {
+		slog.Warn("Rejected invalid WebSocket upgrade", "tenantID", tenantID, "remoteAddr", req.RemoteAddr, "status", status, "error", reason)
+	}
+	w.Header().Set("Sec-Websocket-Version", "13")
+	http.Error(w, reason.Error(), status)
+}
+
+// upgradeAborted logs an upgrade that failed after the connection was taken
+// over, when no HTTP reply can be sent; handshake failures were already
+// answered and logged by upgradeFailed
+func upgradeAborted(peer, tenantID string, req *http.Request, err error) {
+	var handshake websocket.HandshakeError
+	if errors.As(err, &handshake) {
+		return
+	}
+	slog.Warn("WebSocket upgrade aborted", "peer", peer, "tenantID", tenantID, "remoteAddr", req.RemoteAddr, "error", err)
+}
+
+// refuse answers a request that failed validation or authentication with the
+// rejection's HTTP status, before any WebSocket upgrade, so an unauthenticated
+// peer never gets a connection
+func refuse(w http.ResponseWriter, rejection relayproto.Rejection) {
+	http.Error(w, rejection.Reason, rejection.Status)
+}
+
+// reject refuses an authenticated connection. A WebSocket handshake is
+// completed so the peer can read the rejection's close code; other requests
+// get its HTTP status.
+func (r *Relay) reject(w http.ResponseWriter, req *http.Request, rejection relayproto.Rejection) {
+	if !websocket.IsWebSocketUpgrade(req) {
+		http.Error(w, rejection.Reason, rejection.Status)
+		return
+	}
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		upgradeAborted("rejected", mux.Vars(req)["tenantID"], req, err)
+		return
+	}
+	closeRejected(conn, req, rejection)
+}
+
+// closeRejected sends rejection's close code on an upgraded connection and
+// closes it
+func closeRejected(conn *websocket.Conn, req *http.Request, rejection relayproto.Rejection) {
+	defer conn.Close()
+	recordCloseCode(req, rejection.Code)
+	closeMsg := websocket.FormatCloseMessage(rejection.Code, rejection.Reason)
+	conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+}
+
+// negotiateSubprotocol rejects peers offering only subprotocols the relay
+// doesn't speak and, in strict mode, peers offering none. The upgrader selects
+// the supported one for the rest.
+func (r *Relay) negotiateSubprotocol(w http.ResponseWriter, req *http.Request) bool {
+	offered := websocket.Subprotocols(req)
+	if len(offered) == 0 && !r.cfg.StrictSubprotocol {
+		return true
+	}
+	for _, protocol := range offered {
+		if slices.Contains(relayproto.Subprotocols, protocol) {
+			return true
+		}
+	}
+	slog.Warn("Rejected unsupported subprotocol", "path", req.URL.Path, "offered", offered)
+	r.reject(w, req, relayproto.RejectUnsupported)
+	return false
+}
+
+// validateTenantID answers requests whose tenant ID is too long or doesn't
+// match the configured pattern with 414 or 400
+func (r *Relay) validateTenantID(w http.ResponseWriter, req *http.Request, tenantID string) bool {
+	if len(tenantID) > relayproto.MaxTenantIDLength {
+		slog.Warn("Rejected oversized tenant ID", "length", len(tenantID))
+		refuse(w, relayproto.RejectTenantIDTooLong)
+		return false
+	}
+	if tenantID == "" || !r.cfg.TenantIDPattern.MatchString(tenantID) {
+		slog.Warn("Rejected invalid tenant ID", "path", req.URL.Path, "length", len(tenantID))
+		refuse(w, relayproto.RejectInvalidTenant)
+		return false
+	}
+	return true
+}
+
+// authenticate checks the request against the configured Authenticator,
+// answering 401 if it fails
+func (r *Relay) authenticate(w http.ResponseWriter, req *http.Request, role Role, tenantID string) bool {
+	auth := r.live.Load().auth
+	if auth == nil {
+		return true
+	}
+	if err := auth.Authenticate(req, role, tenantID); err != nil {
+		slog.Warn("Rejected unauthenticated connection", "role", role, "tenantID", tenantID, "path", req.URL.Path, "error", err)
+		refuse(w, relayproto.RejectUnauthorized)
+		return false
+	}
+	return true
+}
+
+// admitTenant returns the tenant for tenantID with its mutex held, like
+// lockTenant, while enforcing MaxTenants. A new tenant over the cap evicts the
+// least recently active tenant with no connections; if every tenant is
+// connected it returns nil. The cap check, eviction and insert all happen
+// under admitMu, so concurrent connects for new tenants can't overshoot the cap.
+func (r *Relay) admitTenant(tenantID string) *Tenant {
+	if r.cfg.MaxTenants == 0 {
+		return r.lockTenant(tenantID)
+	}
+	r.admitMu.Lock()
+	defer r.admitMu.Unlock()
+
+	if r.tenants.lookup(tenantID) == nil {
+		for r.tenants.len() >= r.cfg.MaxTenants {
+			if !r.evictIdleTenant() {
+				return nil
+			}
+		}
+	}
+	return r.lockTenant(tenantID)
+}
+
+// rejectAtCapacity closes an upgraded connection that admitTenant turned away
+// with the retryable at-capacity close code
+func (r *Relay) rejectAtCapacity(conn *websocket.Conn, req *http.Request, tenantID string) {
+	slog.Warn("Rejected connection, relay at tenant capacity", "tenantID", tenantID, "path", req.URL.Path, "maxTenants", r.cfg.MaxTenants)
+	closeRejected(conn, req, relayproto.RejectAtCapacity)
+}
+
+// evictIdleTenant removes the least recently active tenant that has no
+// connections, reporting whether one was found
+func (r *Relay) evictIdleTenant() bool {
+	var oldest *Tenant
+	var oldestActivity time.Time
+	r.tenants.forEach(func(tenant *Tenant) {
+		tenant.mu.RLock()
+		defer tenant.mu.RUnlock()
+		if tenant.server == nil && len(tenant.clients) == 0 && (oldest == nil || tenant.lastActivity.Before(oldestActivity)) {
+			oldest, oldestActivity = tenant, tenant.lastActivity
+		}
+	})
+	if oldest == nil {
+		return false
+	}
+
+	shard := r.tenants.shardFor(oldest.tenantID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// The tenant may have been reaped or reconnected since the scan; the caller retries
+	if shard.tenants[oldest.tenantID] != oldest {
+		return true
+	}
+	oldest.mu.RLock()
+	idle := oldest.idleLocked(time.Now(), 0)
+	oldest.mu.RUnlock()
+	if !idle {
+		return true
+	}
+
+	delete(shard.tenants, oldest.tenantID)
+	oldest.close()
+	slog.Info("Evicted least recently active tenant", "tenantID", oldest.tenantID, "lastActivity", oldestActivity)
+	return true
+}
+
+// lockTenant returns the tenant for tenantID, creating it if needed, with its
+// mutex held. Locking under the shard lock keeps the reaper from removing the
+// tenant between lookup and attaching a connection.
+func (r *Relay) lockTenant(tenantID string) *Tenant {
+	shard := r.tenants.shardFor(tenantID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	tenant, exists := shard.tenants[tenantID]
+	if !exists {
+		tenant = &Tenant{
+			tenantID:      tenantID,
+			clients:       make(map[*peerConn]struct{}),
+			serverLimiter: newTokenBucket(),
+			clientLimiter: newTokenBucket(),
+		}
+		unsubscribe, err := r.bus.Subscribe(tenantID, r.deliverFromBus)
+		if err != nil {
+			slog.Error("Failed to subscribe tenant to relay bus", "tenantID", tenantID, "error", err)
+		} else {
+			tenant.unsubscribe = unsubscribe
+		}
+		shard.tenants[tenantID] = tenant
+	}
+
+	tenant.mu.Lock()
+	tenant.lastActivity = time.Now()
+	tenant.heard(tenant.lastActivity)
+	return tenant
+}
+
+// reapIdleTenants periodically removes tenants that have had no connections for the configured TTL
+func (r *Relay) reapIdleTenants(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.reapIdle(now)
+		}
+	}
+}
+
+func (r *Relay) reapIdle(now time.Time) {
+	for _, shard := range r.tenants {
+		shard.mu.Lock()
+		for tenantID, tenant := range shard.tenants {
+			tenant.mu.RLock()
+			idle := tenant.idleLocked(now, r.cfg.TenantTTL)
+			tenant.mu.RUnlock()
+
+			if idle {
+				delete(shard.tenants, tenantID)
+				tenant.close()
+				slog.Debug("Reaped idle tenant", "tenantID", tenantID)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// dropSilentTenants periodically closes the connections of tenants whose peers
+// have all gone quiet for the heartbeat timeout
+func (r *Relay) dropSilentTenants(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.HeartbeatTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.dropSilent(now)
+		}
+	}
+}
+
+// dropSilent closes every connection of tenants silent for the heartbeat
+// timeout; their forward goroutines detach them once their reads fail
+func (r *Relay) dropSilent(now time.Time) {
+	r.tenants.forEach(func(tenant *Tenant) {
+		tenant.mu.RLock()
+		if !tenant.silentLocked(now, r.cfg.HeartbeatTimeout) {
+			tenant.mu.RUnlock()
+			return
+		}
+		server := tenant.server
+		clients := tenant.snapshotClientsLocked()
+		tenant.mu.RUnlock()
+
+		slog.Info("Tenant silent past heartbeat timeout, closing its connections", "tenantID", tenant.tenantID, "timeout", r.cfg.HeartbeatTimeout, "clients", len(clients), "server", server != nil)
+		if server != nil {
+			server.close()
+		}
+		for _, client := range clients {
+			client.close()
+		}
+	})
+}
+
+func (r *Relay) handleServerConnect(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	tenantID := vars["tenantID"]
+
+	if !r.validateTenantID(w, req, tenantID) || !r.authenticate(w, req, RoleServer, tenantID) || !r.negotiateSubprotocol(w, req) {
+		return
+	}
+
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		upgradeAborted("server", tenantID, req, err)
+		return
+	}
+
+	tenant := r.admitTenant(tenantID)
+	if tenant == nil {
+		r.rejectAtCapacity(conn, req, tenantID)
+		return
+	}
+	conn.SetReadLimit(r.cfg.MaxMessageSize)
+	server := newPeerConn(conn, r.cfg.WriteTimeout)
+	r.startKeepalive(server, "server", tenantID)
+
+	previous := tenant.server
+	tenant.server = server
+	tenant.mu.Unlock()
+	r.join(tenantID, RoleServer)
+
+	// Disconnect the replaced server; its forward goroutine exits on the read error
+	if previous != nil {
+		slog.Info("Existing authz server found, disconnecting", "tenantID", tenantID)
+		previous.close()
+	}
+
+	slog.Info("Authz server connected", "tenantID", tenantID)
+
+	// Read from server and forward to client
+	r.forwards.Add(1)
+	go r.forwardServerToClient(r.draining, tenant, server)
+	r.closeIfDraining(server)
+}
+
+func (r *Relay) handleClientConnect(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	tenantID := vars["tenantID"]
+
+	if !r.validateTenantID(w, req, tenantID) || !r.authenticate(w, req, RoleClient, tenantID) || !r.negotiateSubprotocol(w, req) {
+		return
+	}
+
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		upgradeAborted("client", tenantID, req, err)
+		return
+	}
+
+	tenant := r.admitTenant(tenantID)
+	if tenant == nil {
+		r.rejectAtCapacity(conn, req, tenantID)
+		return
+	}
+	conn.SetReadLimit(r.cfg.MaxMessageSize)
+	client := newPeerConn(conn, r.cfg.WriteTimeout)
+
+	// Hold the client's write lock until buffered messages are flushed so
+	// concurrent broadcasts can't overtake them
+	client.writeMu.Lock()
+
+	tenant.clients[client] = struct{}{}
+	numClients := len(tenant.clients)
+	pending := tenant.pending
+	tenant.pending = nil
+	tenant.mu.Unlock()
+	r.join(tenantID, RoleClient)
+
+	slog.Info("Browser client connected", "tenantID", tenantID, "clients", numClients)
+
+	for _, msg := range pending {
+		// A client that doesn't read its backlog is dropped, as a slow client
+		// is on broadcast, rather than holding up writes to it indefinitely
+		if err := client.writeLocked(msg.messageType, msg.data); err != nil {
+			slog.Error("Failed to flush buffered message to client, removing it", "tenantID", tenantID, "error", err)
+			client.writeMu.Unlock()
+			r.detachClient(tenant, client)
+			return
+		}
+	}
+	if len(pending) > 0 {
+		slog.Info("Flushed buffered messages to client", "tenantID", tenantID, "messages", len(pending))
+	}
+	client.writeMu.Unlock()
+
+	r.startKeepalive(client, "client", tenantID)
+
+	// Read from client and forward to server
+	r.forwards.Add(1)
+	go r.forwardClientToServer(r.draining, tenant, client)
+	r.closeIfDraining(client)
+}
+
+// forwardServerToClient forwards a tenant server's messages until its
+// connection fails or ctx is cancelled. A message already read when ctx is
+// cancelled is still delivered; the connection is then left for Shutdown to
+// close, so the other direction can finish its writes to it.
+func (r *Relay) forwardServerToClient(ctx context.Context, tenant *Tenant, server *peerConn) {
+	defer r.forwards.Done()
+	defer func() {
+		if ctx.Err() == nil {
+			server.close()
+		}
+
+		// Only detach if a newer server hasn't already replaced this one
+		tenant.mu.Lock()
+		if tenant.server == server {
+			tenant.server = nil
+		}
+		tenant.lastActivity = time.Now()
+		tenant.mu.Unlock()
+		r.leave(tenant.tenantID, RoleServer)
+		slog.Info("Authz server disconnected", "tenantID", tenant.tenantID)
+	}()
+
+	for ctx.Err() == nil {
+		messageType, message, err := server.conn.ReadMessage()
+		readAt := time.Now()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				slog.Warn("Server message exceeds size limit, closing connection", "tenantID", tenant.tenantID, "limit", r.cfg.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Error("Server read error", "tenantID", tenant.tenantID, "error", err)
+			}
+			return
+		}
+
+		frameType, err := frameTypeOf(messageType, message)
+		if err != nil {
+			slog.Warn("Dropping malformed server frame", "tenantID", tenant.tenantID, "error", err)
+			continue
+		}
+		tenant.heard(readAt)
+		if frameType == relayproto.FrameControl {
+			r.forwardCancel(tenant, messageType, message)
+			continue
+		}
+		if !frameType.Forwarded() {
+			// Only DATA and CHUNK frames are forwarded; the rest are for the relay itself
+			slog.Debug("Handled server frame locally", "tenantID", tenant.tenantID, "frame", frameType)
+			continue
+		}
+		acked := completesMessage(message)
+
+		if live := r.live.Load(); !tenant.serverLimiter.allow(live.rateLimit, live.rateBurst) {
+			dropped := tenant.serverDropped.Add(1)
+			slog.Warn("Rate limit exceeded, dropping server message", "tenantID", tenant.tenantID, "requestID", requestIDOf(message), "direction", "server->client", "bytes", len(message), "dropped", dropped)
+			if acked {
+				r.sendAck(tenant, server, relayproto.ControlFrame{RateLimited: true})
+			}
+			continue
+		}
+
+		// Clients attached to other relay instances are reached through the bus
+		remote := r.publishToRemote(tenant.tenantID, RoleClient, messageType, message)
+
+		delivered, buffered := r.broadcastToClients(tenant, messageType, message, remote == 0)
+		if delivered > 0 {
+			r.metrics.serverToClient.observe(time.Since(readAt))
+		}
+		if acked {
+			r.sendAck(tenant, server, relayproto.ControlFrame{Clients: delivered + remote, Buffered: buffered})
+		}
+	}
+}
+
+// forwardCancel passes a server's cancel on to the tenant's clients so they
+// dismiss the prompt, and drops the request from the buffer if it never reached
+// one. Cancels aren't acknowledged; other server CONTROL frames are ignored.
+func (r *Relay) forwardCancel(tenant *Tenant, messageType int, message []byte) {
+	_, payload, _ := relayproto.DecodeFrame(message)
+	var control relayproto.ControlFrame
+	if err := json.Unmarshal(payload, &control); err != nil || control.Type != relayproto.ControlTypeCancel || control.RequestID == "" {
+		slog.Debug("Ignoring server control frame", "tenantID", tenant.tenantID, "type", control.Type)
+		return
+	}
+	if live := r.live.Load(); !tenant.serverLimiter.allow(live.rateLimit, live.rateBurst) {
+		slog.Warn("Rate limit exceeded, dropping server cancel", "tenantID", tenant.tenantID, "requestID", control.RequestID)
+		return
+	}
+
+	if tenant.dropBuffered(control.RequestID) {
+		slog.Info("Dropped cancelled request from buffer", "tenantID", tenant.tenantID, "requestID", control.RequestID)
+	}
+	r.publishToRemote(tenant.tenantID, RoleClient, messageType, message)
+	r.broadcastToClients(tenant, messageType, message, false)
+}
+
+// broadcastToClients writes a server message to every local client, pruning
+// any that fail. With no local clients the message is buffered if allowed.
+func (r *Relay) broadcastToClients(tenant *Tenant, messageType int, message []byte, allowBuffer bool) (int, bool) {
+	var clients []*peerConn
+	buffered := false
+	if allowBuffer {
+		clients, buffered = tenant.clientsOrBuffer(r.cfg, messageType, message)
+	} else {
+		tenant.mu.Lock()
+		tenant.lastActivity = time.Now()
+		clients = tenant.snapshotClientsLocked()
+		tenant.mu.Unlock()
+	}
+
+	requestID := requestIDOf(message)
+
+	delivered := 0
+	for _, client := range clients {
+		if err := client.write(messageType, message); err != nil {
+			slog.Error("Failed to forward to client, removing it", "tenantID", tenant.tenantID, "requestID", requestID, "direction", "server->client", "error", err)
+			r.detachClient(tenant, client)
+			continue
+		}
+		delivered++
+		slog.Info("Forwarded message", "tenantID", tenant.tenantID, "requestID", requestID, "direction", "server->client", "bytes", len(message))
+	}
+	return delivered, buffered
+}
+
+// sendAck tells the server what became of its message: how many clients
+// received it, or that it was buffered or dropped by the rate limit
+func (r *Relay) sendAck(tenant *Tenant, server *peerConn, ack relayproto.ControlFrame) {
+	ack.Type = relayproto.ControlTypeAck
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		slog.Error("Failed to marshal ack", "tenantID", tenant.tenantID, "error", err)
+		return
+	}
+	if err := server.write(websocket.BinaryMessage, relayproto.EncodeFrame(relayproto.FrameAck, payload)); err != nil {
+		slog.Warn("Failed to send ack to server", "tenantID", tenant.tenantID, "error", err)
+	}
+}
+
+// frameTypeOf returns the type of a frame read from a peer; every frame must be
+// a binary message
+func frameTypeOf(messageType int, message []byte) (relayproto.FrameType, error) {
+	if messageType != websocket.BinaryMessage {
+		return 0, fmt.Errorf("unexpected WebSocket message type %d", messageType)
+	}
+	frameType, _, err := relayproto.DecodeFrame(message)
+	return frameType, err
+}
+
+// completesMessage reports whether a forwarded frame is the last of its message,
+// which the server expects one ack for: a DATA frame or the final CHUNK
+func completesMessage(message []byte) bool {
+	frameType, payload, err := relayproto.DecodeFrame(message)
+	if err != nil {
+		return false
+	}
+	return frameType == relayproto.FrameData ||
+		frameType == relayproto.FrameChunk && relayproto.IsFinalChunk(payload)
+}
+
+// requestIDOf returns the request ID from a DATA frame's routing header, or ""
+// if it has none. The header is unverified, so it's only fit for logging.
+func requestIDOf(message []byte) string {
+	frameType, payload, err := relayproto.DecodeFrame(message)
+	if err != nil || frameType != relayproto.FrameData {
+		return ""
+	}
+	header, err := relayproto.PeekRoutingHeader(payload)
+	if err != nil {
+		return ""
+	}
+	return header.RequestID
+}
+
+// forwardClientToServer forwards a browser client's messages until its
+// connection fails or ctx is cancelled, finishing the message in hand as
+// forwardServerToClient does
+func (r *Relay) forwardClientToServer(ctx context.Context, tenant *Tenant, client *peerConn) {
+	defer r.forwards.Done()
+	defer func() {
+		if ctx.Err() == nil {
+			r.detachClient(tenant, client)
+		} else if tenant.removeClient(client) {
+			r.leave(tenant.tenantID, RoleClient)
+		}
+		slog.Info("Browser client disconnected", "tenantID", tenant.tenantID)
+	}()
+
+	for ctx.Err() == nil {
+		messageType, message, err := client.conn.ReadMessage()
+		readAt := time.Now()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				slog.Warn("Client message exceeds size limit, closing connection", "tenantID", tenant.tenantID, "limit", r.cfg.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Error("Client read error", "tenantID", tenant.tenantID, "error", err)
+			}
+			return
+		}
+
+		frameType, err := frameTypeOf(messageType, message)
+		if err != nil {
+			slog.Warn("Dropping malformed client frame", "tenantID", tenant.tenantID, "error", err)
+			continue
+		}
+		tenant.heard(readAt)
+		if !frameType.Forwarded() {
+			slog.Debug("Handled client frame locally", "tenantID", tenant.tenantID, "frame", frameType)
+			continue
+		}
+
+		if live := r.live.Load(); !tenant.clientLimiter.allow(live.rateLimit, live.rateBurst) {
+			dropped := tenant.clientDropped.Add(1)
+			slog.Warn("Rate limit exceeded, dropping client message", "tenantID", tenant.tenantID, "requestID", requestIDOf(message), "direction", "client->server", "bytes", len(message), "dropped", dropped)
+			continue
+		}
+
+		if r.forwardToServer(tenant, messageType, message) {
+			r.metrics.clientToServer.observe(time.Since(readAt))
+		}
+	}
+}
+
+// forwardToServer writes a client message to the tenant's server, or publishes
+// it on the bus if the server is attached to another relay instance. It reports
+// whether the message was written to a local server.
+func (r *Relay) forwardToServer(tenant *Tenant, messageType int, message []byte) bool {
+	tenant.mu.Lock()
+	server := tenant.server
+	tenant.lastActivity = time.Now()
+	tenant.mu.Unlock()
+
+	if server == nil {
+		r.publishToRemote(tenant.tenantID, RoleServer, messageType, message)
+		return false
+	}
+
+	requestID := requestIDOf(message)
+	if err := server.write(messageType, message); err != nil {
+		// Treat a failed or timed-out write as a disconnect; the server's
+		// forward goroutine cleans up once its read fails
+		slog.Error("Failed to forward to server, closing it", "tenantID", tenant.tenantID, "requestID", requestID, "direction", "client->server", "error", err)
+		server.close()
+		return false
+	}
+	slog.Info("Forwarded message", "tenantID", tenant.tenantID, "requestID", requestID, "direction", "client->server", "bytes", len(message))
+	return true
+}
+
+// join records a local connection in the tenant store
+func (r *Relay) join(tenantID string, role Role) {
+	if err := r.store.Join(tenantID, role, r.instanceID); err != nil {
+		slog.Error("Failed to record connection in tenant store", "tenantID", tenantID, "role", role, "error", err)
+	}
+}
+
+// leave removes a local connection from the tenant store
+func (r *Relay) leave(tenantID string, role Role) {
+	if err := r.store.Leave(tenantID, role, r.instanceID); err != nil {
+		slog.Error("Failed to remove connection from tenant store", "tenantID", tenantID, "role", role, "error", err)
+	}
+}
+
+// detachClient removes a client from its tenant and the tenant store and
+// closes it
+func (r *Relay) detachClient(tenant *Tenant, client *peerConn) {
+	if tenant.removeClient(client) {
+		r.leave(tenant.tenantID, RoleClient)
+	}
+	client.close()
+}
+
+// publishToRemote publishes a message for role on the bus if any other relay
+// instance holds such a connection, returning how many instances it targeted
+func (r *Relay) publishToRemote(tenantID string, to Role, messageType int, message []byte) int {
+	instances, err := r.store.Instances(tenantID, to)
+	if err != nil {
+		slog.Error("Failed to look up tenant in store", "tenantID", tenantID, "role", to, "error", err)
+		return 0
+	}
+
+	remote := 0
+	for _, instanceID := range instances {
+		if instanceID != r.instanceID {
+			remote++
+		}
+	}
+	if remote == 0 {
+		return 0
+	}
+
+	err = r.bus.Publish(BusMessage{
+		TenantID:    tenantID,
+		From:        r.instanceID,
+		To:          to,
+		MessageType: messageType,
+		Data:        message,
+	})
+	if err != nil {
+		slog.Error("Failed to publish to relay bus", "tenantID", tenantID, "role", to, "error", err)
+		return 0
+	}
+	return remote
+}
+
+// deliverFromBus writes a message published by another instance to local connections
+func (r *Relay) deliverFromBus(msg BusMessage) {
+	if msg.From == r.instanceID {
+		return
+	}
+
+	tenant := r.tenants.lookup(msg.TenantID)
+	if tenant == nil {
+		return
+	}
+
+	switch msg.To {
+	case RoleClient:
+		r.broadcastToClients(tenant, msg.MessageType, msg.Data, false)
+	case RoleServer:
+		tenant.mu.RLock()
+		server := tenant.server
+		tenant.mu.RUnlock()
+
+		if server != nil {
+			if err := server.write(msg.MessageType, msg.Data); err != nil {
+				slog.Error("Failed to deliver bus message to server", "tenantID", msg.TenantID, "error", err)
+			}
+		}
+	}
+}
+
+// newInstanceID returns a random identifier for this relay process
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("relay-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Shutdown drains the relay: it tells every connection the relay is going
+// away, stops the forward goroutines reading while letting each finish the
+// message it already read, so an approval in transit still reaches the other
+// side, and then sends close frames and closes the connections. Whatever hasn't
+// finished when ctx expires is closed anyway.
+func (r *Relay) Shutdown(ctx context.Context) {
+	// Connections registered after this close themselves
+	r.stopForward()
+
+	var peers []*peerConn
+
+	r.tenants.forEach(func(tenant *Tenant) {
+		tenant.mu.RLock()
+		if tenant.server != nil {
+			peers = append(peers, tenant.server)
+		}
+		peers = append(peers, tenant.snapshotClientsLocked()...)
+		tenant.mu.RUnlock()
+	})
+
+	r.sendDrainNotice(ctx, peers)
+
+	// Expiring the read deadline wakes forward goroutines blocked in a read;
+	// one busy forwarding a message sees the cancellation once it's written
+	for _, peer := range peers {
+		peer.conn.SetReadDeadline(time.Now())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.forwards.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("Finished forwarding in-flight messages")
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for in-flight messages, closing connections", "error", ctx.Err())
+	}
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "relay shutting down")
+	deadline := time.Now().Add(time.Second)
+	for _, peer := range peers {
+		peer.conn.WriteControl(websocket.CloseMessage, closeMsg, deadline)
+		peer.close()
+	}
+	slog.Info("Closed connections", "connections", len(peers))
+}
+
+// closeIfDraining closes a connection that arrived while the relay is shutting
+// down, which Shutdown may not have seen
+func (r *Relay) closeIfDraining(peer *peerConn) {
+	if r.draining.Err() != nil {
+		peer.close()
+	}
+}
+
+// drainRetryAfter is how long peers are asked to wait before reconnecting after
+// a drain notice, giving a replacement instance time to come up
+const drainRetryAfter = 10 * time.Second
+
+// sendDrainNotice tells peers the relay is shutting down so they back off
+// instead of reconnecting straight away. Peers are written to in parallel so a
+// slow one can't hold up the rest.
+func (r *Relay) sendDrainNotice(ctx context.Context, peers []*peerConn) {
+	notice, err := json.Marshal(relayproto.ControlFrame{
+		Type:       relayproto.ControlTypeDrain,
+		RetryAfter: int(drainRetryAfter / time.Second),
+	})
+	if err != nil {
+		slog.Error("Failed to marshal drain notice", "error", err)
+		return
+	}
+	frame := relayproto.EncodeFrame(relayproto.FrameControl, notice)
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := peer.write(websocket.BinaryMessage, frame); err != nil {
+				slog.Debug("Failed to send drain notice", "error", err)
+			}
+		}()
+	}
+	sent := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-ctx.Done():
+	}
+	slog.Info("Sent drain notice to connections", "connections", len(peers), "retryAfter", drainRetryAfter)
+}
+
+// startKeepalive arms the read deadline and sends periodic pings, closing the
+// connection if the peer stops answering with pongs
+func (r *Relay) startKeepalive(peer *peerConn, role, tenantID string) {
+	peer.conn.SetReadDeadline(time.Now().Add(r.cfg.PongTimeout))
+	peer.conn.SetPongHandler(func(string) error {
+		// A draining relay has expired the deadline to stop reads
+		if r.draining.Err() == nil {
+			peer.conn.SetReadDeadline(time.Now().Add(r.cfg.PongTimeout))
+		}
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(r.cfg.PingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-peer.done:
+				return
+			case <-ticker.C:
+				if err := peer.write(websocket.PingMessage, nil); err != nil {
+					slog.Warn("Failed to send ping, closing connection", "tenantID", tenantID, "role", role, "error", err)
+					peer.close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// SetLogs has the admin API serve the recent log lines retained in logs
+func (r *Relay) SetLogs(logs *applog.RingBuffer) {
+	r.logs = logs
+}
+
+// RegisterRoutes loads the client page and registers the relay's health,
+// metrics, admin, WebSocket and client page routes on router
+func (r *Relay) RegisterRoutes(router *mux.Router) error {
+	page, err := newClientPage(r.cfg.StaticDir)
+	if err != nil {
+		return fmt.Errorf("failed to load client page: %w", err)
+	}
+	r.page = page
+
+	router.HandleFunc("/healthz", r.handleHealthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", r.handleReadyz).Methods(http.MethodGet)
+	router.HandleFunc("/metrics", r.handleMetrics).Methods(http.MethodGet)
+	router.HandleFunc("/admin/tenants", r.handleListTenants).Methods(http.MethodGet)
+	router.HandleFunc("/admin/tenants/{tenantID}", r.handleDeleteTenant).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/loglevel", r.handleSetLogLevel).Methods(http.MethodPost)
+	router.HandleFunc("/admin/logs", r.handleRecentLogs).Methods(http.MethodGet)
+	router.HandleFunc("/ws/server/{tenantID}", r.handleServerConnect)
+	router.HandleFunc("/ws/client/{tenantID}", r.handleClientConnect)
+
+	// Serve the client page with the tenant injected
+	router.HandleFunc("/s/{tenantID}", r.handleClientPage)
+	return nil
+}
+
+// Run reaps idle tenants and, with a heartbeat timeout, drops silent ones
+// until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	if r.cfg.HeartbeatTimeout > 0 {
+		go r.dropSilentTenants(ctx)
+	}
+	r.reapIdleTenants(ctx)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// testTenant and otherTenant match the default tenant ID pattern
+const (
+	testTenant  = "0123456789abcdef01234567"
+	otherTenant = "76543210fedcba9876543210"
+)
+
+// newTestRelay serves a relay configured by cfg from an httptest.Server,
+// shutting both down when the test ends
+func newTestRelay(t *testing.T, cfg Config) (*Relay, *httptest.Server) {
+	t.Helper()
+	r, err := NewRelay(cfg)
+	if err != nil {
+		t.Fatalf("NewRelay: %v", err)
+	}
+	return r, serveRelay(t, r)
+}
+
+// serveRelay serves r from an httptest.Server, with the access log if r's
+// config enables it, shutting both down when the test ends
+func serveRelay(t *testing.T, r *Relay) *httptest.Server {
+	t.Helper()
+	router := mux.NewRouter()
+	if err := r.RegisterRoutes(router); err != nil {
+		t.Fatalf("RegisterRoutes: %v", err)
+	}
+	r.SetReady(true)
+	var handler http.Handler = router
+	if r.cfg.AccessLog {
+		handler = AccessLog(router)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		r.Shutdown(ctx)
+		srv.Close()
+	})
+	return srv
+}
+
+// dial connects to the relay as role ("server" or "client") for tenantID
+func dial(t *testing.T, srv *httptest.Server, role, tenantID string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := dialErr(srv, role, tenantID, header)
+	if err != nil {
+		t.Fatalf("dial %s for %s: %v", role, tenantID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialErr connects to the relay as role for tenantID, returning the error
+func dialErr(srv *httptest.Server, role, tenantID string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{Subprotocols: relayproto.Subprotocols}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + role + "/" + tenantID
+	return dialer.Dial(url, header)
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readFrame reads the next frame from conn, failing the test after a second
+func readFrame(t *testing.T, conn *websocket.Conn) (relayproto.FrameType, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	frameType, payload, err := relayproto.DecodeFrame(message)
+	if err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	return frameType, payload
+}
+
+// dataFrame returns a DATA frame for requestID; the relay doesn't check its MAC
+func dataFrame(t *testing.T, requestID string, body string) []byte {
+	t.Helper()
+	frame, err := relayproto.EncodeData([]byte("test mac key"), relayproto.RoutingHeader{RequestID: requestID}, []byte(body))
+	if err != nil {
+		t.Fatalf("encode data: %v", err)
+	}
+	return frame
+}
+
+// closedWithin reports whether conn is closed by the relay within d, reading
+// and discarding any frames still in flight
+func closedWithin(conn *websocket.Conn, d time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(d))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var netErr interface{ Timeout() bool }
+			return !(errors.As(err, &netErr) && netErr.Timeout())
+		}
+	}
+}
+
+func TestLockTenant(t *testing.T) {
+	r, err := NewRelay(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := r.lockTenant(testTenant)
+	if tenant.tenantID != testTenant || tenant.clients == nil {
+		t.Fatalf("lockTenant = %+v", tenant)
+	}
+	if tenant.mu.TryLock() {
+		t.Fatal("lockTenant returned the tenant unlocked")
+	}
+	tenant.mu.Unlock()
+
+	if again := r.lockTenant(testTenant); again != tenant {
+		t.Error("lockTenant created a second tenant for the same ID")
+	} else {
+		again.mu.Unlock()
+	}
+	other := r.lockTenant(otherTenant)
+	other.mu.Unlock()
+	if other == tenant || r.tenants.len() != 2 {
+		t.Errorf("tenants = %d after locking two IDs", r.tenants.len())
+	}
+}
+
+func TestRegisterRoutes(t *testing.T) {
+	r, err := NewRelay(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	if err := r.RegisterRoutes(router); err != nil {
+		t.Fatalf("RegisterRoutes: %v", err)
+	}
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/healthz"},
+		{http.MethodGet, "/readyz"},
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/admin/tenants"},
+		{http.MethodDelete, "/admin/tenants/" + testTenant},
+		{http.MethodPost, "/admin/loglevel"},
+		{http.MethodGet, "/admin/logs"},
+		{http.MethodGet, "/ws/server/" + testTenant},
+		{http.MethodGet, "/ws/client/" + testTenant},
+		{http.MethodGet, "/s/" + testTenant},
+	} {
+		var match mux.RouteMatch
+		if !router.Match(httptest.NewRequest(route.method, route.path, nil), &match) || match.MatchErr != nil {
+			t.Errorf("%s %s not routed", route.method, route.path)
+		}
+	}
+	var match mux.RouteMatch
+	if router.Match(httptest.NewRequest(http.MethodPost, "/healthz", nil), &match) && match.MatchErr == nil {
+		t.Error("POST /healthz routed")
+	}
+}
+
+func TestForwardRoundTrip(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	request := dataFrame(t, "req-1", "request")
+	if err := server.WriteMessage(websocket.BinaryMessage, request); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, request)
+	readAck(t, server)
+
+	decision := dataFrame(t, "req-1", "decision")
+	if err := client.WriteMessage(websocket.BinaryMessage, decision); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, server, decision)
+}
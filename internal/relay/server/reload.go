@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	applog "github.com/yuval/extauth-match/internal/log"
+)
+
+// configFile is the JSON format of --config-file. It holds the settings that
+// can be reloaded on SIGHUP; omitted fields keep their flag or environment value.
+type configFile struct {
+	AllowedOrigins *[]string `json:"allowedOrigins"`
+	RateLimit      *float64  `json:"rateLimit"`
+	RateBurst      *int      `json:"rateBurst"`
+	AuthMode       *string   `json:"authMode"`
+	AuthSecret     *string   `json:"authSecret"`
+	AdminToken     *string   `json:"adminToken"`
+	LogLevel       string    `json:"logLevel"`
+}
+
+// applyConfigFile overrides cfg with the settings in cfg.ConfigFile
+func applyConfigFile(cfg *Config) error {
+	data, err := os.ReadFile(cfg.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %w", cfg.ConfigFile, err)
+	}
+
+	if file.AllowedOrigins != nil {
+		cfg.AllowedOrigins = *file.AllowedOrigins
+	}
+	if file.RateLimit != nil {
+		cfg.RateLimit = *file.RateLimit
+	}
+	if file.RateBurst != nil {
+		cfg.RateBurst = *file.RateBurst
+	}
+	if file.AuthMode != nil {
+		cfg.AuthMode = *file.AuthMode
+	}
+	if file.AuthSecret != nil {
+		cfg.AuthSecret = *file.AuthSecret
+	}
+	if file.AdminToken != nil {
+		cfg.AdminToken = *file.AdminToken
+	}
+	if file.LogLevel != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(file.LogLevel)); err != nil {
+			return fmt.Errorf("invalid log level %q in config file", file.LogLevel)
+		}
+		cfg.setLogLevel = true
+	}
+	return nil
+}
+
+// liveConfig is the part of the relay configuration that can be reloaded
+// without a restart. Handlers load it once per request or message, so a reload
+// applies to new connections and to new messages on existing ones.
+type liveConfig struct {
+	allowedOrigins []string
+	rateLimit      float64
+	rateBurst      int
+	adminToken     string
+	auth           Authenticator
+}
+
+func newLiveConfig(cfg Config) *liveConfig {
+	return &liveConfig{
+		allowedOrigins: cfg.AllowedOrigins,
+		rateLimit:      cfg.RateLimit,
+		rateBurst:      cfg.RateBurst,
+		adminToken:     cfg.AdminToken,
+		auth:           newAuthenticator(cfg.AuthMode, cfg.AuthSecret),
+	}
+}
+
+// Reload swaps in the reloadable settings from cfg: allowed origins, rate
+// limits, auth, the admin token and log level. Other settings need a restart.
+func (r *Relay) Reload(cfg Config) {
+	r.live.Store(newLiveConfig(cfg))
+	if cfg.setLogLevel {
+		applog.SetLevel(cfg.LogLevel)
+	}
+	slog.Info("Reloaded relay configuration", "allowedOrigins", len(cfg.AllowedOrigins), "rateLimit", cfg.RateLimit, "rateBurst", cfg.RateBurst, "authMode", cfg.AuthMode)
+}
+
+// checkOrigin applies the current origin allowlist to a WebSocket upgrade
+func (r *Relay) checkOrigin(req *http.Request) bool {
+	return checkOrigin(r.live.Load().allowedOrigins)(req)
+}
+
+// ReloadOnSIGHUP parses the configuration again from args, the environment and
+// the config file on every SIGHUP and applies the reloadable settings. An
+// invalid configuration is logged and the current one kept.
+func (r *Relay) ReloadOnSIGHUP(args []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := ParseConfig(args)
+		if err != nil {
+			slog.Error("Invalid relay configuration, keeping the current one", "error", err)
+			continue
+		}
+		r.Reload(cfg)
+	}
+}
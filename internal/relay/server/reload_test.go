@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	// Catch SIGHUP ourselves too, so one arriving before the relay listens
+	// doesn't kill the test binary
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(hup) })
+
+	path := filepath.Join(t.TempDir(), "relay.json")
+	writeConfig := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"authMode": "secret", "authSecret": "old secret", "rateLimit": 0}`)
+	args := []string{"--config-file", path}
+	cfg, err := ParseConfig(args)
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	r, srv := newTestRelay(t, cfg)
+	go r.ReloadOnSIGHUP(args)
+
+	server := dial(t, srv, "server", testTenant, bearer("old secret"))
+	client := dial(t, srv, "client", testTenant, bearer(crypto.ClientToken("old secret", testTenant)))
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	writeConfig(`{"authMode": "secret", "authSecret": "new secret", "rateLimit": 0}`)
+	probe := httptest.NewRequest(http.MethodGet, "/ws/server/"+testTenant, nil)
+	probe.Header = bearer("new secret")
+	deadline := time.Now().Add(2 * time.Second)
+	for r.live.Load().auth.Authenticate(probe, RoleServer, testTenant) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("SIGHUP never reloaded the config")
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, resp, err := dialErr(srv, "server", otherTenant, bearer("old secret")); !rejectedUnauthorized(resp, err) {
+		t.Error("old secret still accepted after reload")
+	}
+	dial(t, srv, "server", otherTenant, bearer("new secret"))
+
+	// Connections made before the reload keep forwarding
+	frame := dataFrame(t, "req-1", "ciphertext")
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, frame)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// tenantShard is one partition of the relay's tenant map
+type tenantShard struct {
+	tenants map[string]*Tenant
+	mu      sync.RWMutex
+}
+
+// tenantShards spreads tenants over several maps so connects for different
+// tenants don't contend on a single lock
+type tenantShards []*tenantShard
+
+func newTenantShards(n int) tenantShards {
+	if n < 1 {
+		n = 1
+	}
+	shards := make(tenantShards, n)
+	for i := range shards {
+		shards[i] = &tenantShard{tenants: make(map[string]*Tenant)}
+	}
+	return shards
+}
+
+// shardFor returns the shard owning tenantID
+func (s tenantShards) shardFor(tenantID string) *tenantShard {
+	h := fnv.New32a()
+	h.Write([]byte(tenantID))
+	return s[h.Sum32()%uint32(len(s))]
+}
+
+// lookup returns the tenant for tenantID, or nil if there is none
+func (s tenantShards) lookup(tenantID string) *Tenant {
+	shard := s.shardFor(tenantID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.tenants[tenantID]
+}
+
+// remove deletes and returns the tenant for tenantID, or nil if there is none
+func (s tenantShards) remove(tenantID string) *Tenant {
+	shard := s.shardFor(tenantID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	tenant := shard.tenants[tenantID]
+	delete(shard.tenants, tenantID)
+	return tenant
+}
+
+// forEach calls fn for every tenant, holding each shard's read lock in turn
+func (s tenantShards) forEach(fn func(*Tenant)) {
+	for _, shard := range s {
+		shard.mu.RLock()
+		for _, tenant := range shard.tenants {
+			fn(tenant)
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// len returns the number of tenants across all shards
+func (s tenantShards) len() int {
+	n := 0
+	for _, shard := range s {
+		shard.mu.RLock()
+		n += len(shard.tenants)
+		shard.mu.RUnlock()
+	}
+	return n
+}
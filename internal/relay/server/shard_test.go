@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchTenantIDs returns n distinct tenant IDs matching the default pattern
+func benchTenantIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%024x", i)
+	}
+	return ids
+}
+
+func TestTenantShards(t *testing.T) {
+	shards := newTenantShards(4)
+	ids := benchTenantIDs(64)
+	for _, id := range ids {
+		if shards.shardFor(id) != shards.shardFor(id) {
+			t.Fatalf("tenant %s maps to different shards", id)
+		}
+		shard := shards.shardFor(id)
+		shard.tenants[id] = &Tenant{tenantID: id}
+	}
+
+	used := 0
+	for _, shard := range shards {
+		if len(shard.tenants) > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("64 tenants landed in %d shards, want them spread out", used)
+	}
+	if n := shards.len(); n != len(ids) {
+		t.Errorf("len = %d, want %d", n, len(ids))
+	}
+
+	seen := 0
+	shards.forEach(func(*Tenant) { seen++ })
+	if seen != len(ids) {
+		t.Errorf("forEach visited %d tenants, want %d", seen, len(ids))
+	}
+
+	if tenant := shards.remove(ids[0]); tenant == nil || tenant.tenantID != ids[0] {
+		t.Errorf("remove returned %v", tenant)
+	}
+	if shards.lookup(ids[0]) != nil || shards.remove(ids[0]) != nil {
+		t.Error("removed tenant still present")
+	}
+	if shards.lookup(ids[1]) == nil {
+		t.Error("lookup missed a stored tenant")
+	}
+}
+
+// BenchmarkLockTenant compares a single tenant map with a sharded one under
+// concurrent lookups of existing tenants
+func BenchmarkLockTenant(b *testing.B) {
+	ids := benchTenantIDs(1024)
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.TenantShards = shards
+			r, err := NewRelay(cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, id := range ids {
+				r.lockTenant(id).mu.Unlock()
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					r.lockTenant(ids[i%len(ids)]).mu.Unlock()
+					i += 7
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// closeFrame reads from conn until the relay closes it and returns the close
+// error, or nil if the connection ended without a close frame
+func closeFrame(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return closeErr
+			}
+			return nil
+		}
+	}
+}
+
+func TestShutdownSendsCloseFrames(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	done := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r.Shutdown(ctx)
+		close(done)
+	}()
+
+	for role, conn := range map[string]*websocket.Conn{"server": server, "client": client} {
+		closeErr := closeFrame(t, conn)
+		if closeErr == nil || closeErr.Code != websocket.CloseGoingAway {
+			t.Errorf("%s: close = %v, want %d", role, closeErr, websocket.CloseGoingAway)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown didn't return once connections closed")
+	}
+}
+
+func TestShutdownSendsDrainNotice(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r.Shutdown(ctx)
+	}()
+
+	for role, conn := range map[string]*websocket.Conn{"server": server, "client": client} {
+		frameType, payload := readFrame(t, conn)
+		var notice relayproto.ControlFrame
+		if frameType != relayproto.FrameControl || json.Unmarshal(payload, &notice) != nil || notice.Type != relayproto.ControlTypeDrain {
+			t.Fatalf("%s got %s frame %q, want a drain notice", role, frameType, payload)
+		}
+		if notice.RetryAfter != int(drainRetryAfter/time.Second) {
+			t.Errorf("%s retryAfter = %d, want %d", role, notice.RetryAfter, int(drainRetryAfter/time.Second))
+		}
+		if closeErr := closeFrame(t, conn); closeErr == nil || closeErr.Code != websocket.CloseGoingAway {
+			t.Errorf("%s: close after notice = %v, want %d", role, closeErr, websocket.CloseGoingAway)
+		}
+	}
+}
+
+func TestShutdownFinishesInFlightForward(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	server := dial(t, srv, "server", testTenant, nil)
+	client := dial(t, srv, "client", testTenant, nil)
+	waitFor(t, "client to attach", func() bool { return clients(r, testTenant) == 1 })
+	tenant := r.tenants.lookup(testTenant)
+
+	// Hold the tenant so the forward goroutine stalls between reading the
+	// message and writing it to the client
+	tenant.mu.Lock()
+	heard := tenant.lastHeard.Load()
+	frame := dataFrame(t, "req-1", "approve me")
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		tenant.mu.Unlock()
+		t.Fatal(err)
+	}
+	waitFor(t, "server message to be read", func() bool { return tenant.lastHeard.Load() != heard })
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r.Shutdown(ctx)
+	}()
+	waitFor(t, "shutdown to start", func() bool { return r.draining.Err() != nil })
+	tenant.mu.Unlock()
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, message, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection ended without the in-flight message: %v", err)
+		}
+		if frameType, payload, _ := relayproto.DecodeFrame(message); frameType == relayproto.FrameData {
+			if _, want, _ := relayproto.DecodeFrame(frame); !bytes.Equal(payload, want) {
+				t.Error("forwarded frame differs from the one sent")
+			}
+			break
+		}
+	}
+	if closeErr := closeFrame(t, client); closeErr == nil || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("close after forward = %v, want %d", closeErr, websocket.CloseGoingAway)
+	}
+}
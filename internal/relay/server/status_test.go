@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStatusTracksConnections(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	if n := r.ActiveTenants(); n != 0 {
+		t.Fatalf("ActiveTenants = %d before any connection", n)
+	}
+
+	server := dial(t, srv, "server", testTenant, nil)
+	first := dial(t, srv, "client", testTenant, nil)
+	dial(t, srv, "client", testTenant, nil)
+	other := dial(t, srv, "client", otherTenant, nil)
+	waitFor(t, "connections to register", func() bool {
+		return r.ActiveTenants() == 2 && clients(r, testTenant) == 2 && clients(r, otherTenant) == 1
+	})
+	if s, c := r.TenantStatus(testTenant); !s || !c {
+		t.Errorf("TenantStatus(%s) = %v, %v, want server and client", testTenant, s, c)
+	}
+	if s, c := r.TenantStatus(otherTenant); s || !c {
+		t.Errorf("TenantStatus(%s) = %v, %v, want client only", otherTenant, s, c)
+	}
+	if s, c := r.TenantStatus("unknown"); s || c {
+		t.Errorf("TenantStatus(unknown) = %v, %v", s, c)
+	}
+	snapshot := r.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].TenantID != testTenant || !snapshot[0].ServerConnected || snapshot[0].Clients != 2 {
+		t.Errorf("Snapshot = %+v", snapshot)
+	}
+
+	first.Close()
+	waitFor(t, "client to detach", func() bool { return clients(r, testTenant) == 1 })
+	server.Close()
+	waitFor(t, "server to detach", func() bool {
+		s, _ := r.TenantStatus(testTenant)
+		return !s
+	})
+	other.Close()
+	waitFor(t, "other tenant to go idle", func() bool { return r.ActiveTenants() == 1 })
+}
+
+func TestStatusDuringConnects(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, _, err := dialErr(srv, "client", testTenant, nil); err == nil {
+				conn.Close()
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			r.ActiveTenants()
+			r.TenantStatus(testTenant)
+			r.Snapshot()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+	waitFor(t, "clients to detach", func() bool { return r.ActiveTenants() == 0 })
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sync"
+)
+
+// Role identifies which side of a tenant a connection belongs to
+type Role string
+
+const (
+	RoleServer Role = "server"
+	RoleClient Role = "client"
+)
+
+// Store tracks which relay instances hold connections for each tenant, so an
+// instance can tell whether a peer it lacks locally is attached elsewhere.
+type Store interface {
+	// Join records one more connection of role for tenantID on instanceID
+	Join(tenantID string, role Role, instanceID string) error
+	// Leave records that one connection of role for tenantID on instanceID went away
+	Leave(tenantID string, role Role, instanceID string) error
+	// Instances returns the instances holding at least one connection of role for tenantID
+	Instances(tenantID string, role Role) ([]string, error)
+}
+
+// BusMessage is a frame forwarded between relay instances
+type BusMessage struct {
+	TenantID    string
+	From        string // Publishing instance ID
+	To          Role   // Which side of the tenant should receive it
+	MessageType int
+	Data        []byte
+}
+
+// Bus delivers frames between relay instances, keyed by tenant
+type Bus interface {
+	Publish(msg BusMessage) error
+	// Subscribe calls handler for every message published for tenantID until
+	// the returned function is called
+	Subscribe(tenantID string, handler func(BusMessage)) (unsubscribe func(), err error)
+}
+
+// MemoryStore is a Store for a single relay instance
+type MemoryStore struct {
+	counts map[string]map[Role]map[string]int
+	mu     sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counts: make(map[string]map[Role]map[string]int),
+	}
+}
+
+func (s *MemoryStore) Join(tenantID string, role Role, instanceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roles, exists := s.counts[tenantID]
+	if !exists {
+		roles = make(map[Role]map[string]int)
+		s.counts[tenantID] = roles
+	}
+	if roles[role] == nil {
+		roles[role] = make(map[string]int)
+	}
+	roles[role][instanceID]++
+	return nil
+}
+
+func (s *MemoryStore) Leave(tenantID string, role Role, instanceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances := s.counts[tenantID][role]
+	if instances == nil {
+		return nil
+	}
+
+	instances[instanceID]--
+	if instances[instanceID] <= 0 {
+		delete(instances, instanceID)
+	}
+	if len(instances) == 0 {
+		delete(s.counts[tenantID], role)
+	}
+	if len(s.counts[tenantID]) == 0 {
+		delete(s.counts, tenantID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Instances(tenantID string, role Role) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var instances []string
+	for instanceID := range s.counts[tenantID][role] {
+		instances = append(instances, instanceID)
+	}
+	return instances, nil
+}
+
+// MemoryBus is a Bus that delivers in-process
+type MemoryBus struct {
+	subs   map[string]map[int]func(BusMessage)
+	nextID int
+	mu     sync.RWMutex
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subs: make(map[string]map[int]func(BusMessage)),
+	}
+}
+
+func (b *MemoryBus) Publish(msg BusMessage) error {
+	b.mu.RLock()
+	handlers := make([]func(BusMessage), 0, len(b.subs[msg.TenantID]))
+	for _, handler := range b.subs[msg.TenantID] {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(tenantID string, handler func(BusMessage)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	if b.subs[tenantID] == nil {
+		b.subs[tenantID] = make(map[int]func(BusMessage))
+	}
+	b.subs[tenantID][id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[tenantID], id)
+		if len(b.subs[tenantID]) == 0 {
+			delete(b.subs, tenantID)
+		}
+	}, nil
+}
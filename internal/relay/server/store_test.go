@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMemoryStoreCountsConnections(t *testing.T) {
+	s := NewMemoryStore()
+	s.Join(testTenant, RoleServer, "a")
+	s.Join(testTenant, RoleClient, "a")
+	s.Join(testTenant, RoleClient, "b")
+	s.Join(testTenant, RoleClient, "b")
+
+	instances, _ := s.Instances(testTenant, RoleClient)
+	slices.Sort(instances)
+	if !slices.Equal(instances, []string{"a", "b"}) {
+		t.Fatalf("client instances = %v, want [a b]", instances)
+	}
+
+	// An instance stays listed until its last connection leaves
+	s.Leave(testTenant, RoleClient, "b")
+	if instances, _ := s.Instances(testTenant, RoleClient); len(instances) != 2 {
+		t.Errorf("client instances after one leave = %v, want both", instances)
+	}
+	s.Leave(testTenant, RoleClient, "b")
+	if instances, _ := s.Instances(testTenant, RoleClient); !slices.Equal(instances, []string{"a"}) {
+		t.Errorf("client instances = %v, want [a]", instances)
+	}
+
+	// Leaving what never joined is a no-op
+	s.Leave(otherTenant, RoleServer, "a")
+	if instances, _ := s.Instances(testTenant, RoleServer); !slices.Equal(instances, []string{"a"}) {
+		t.Errorf("server instances = %v, want [a]", instances)
+	}
+
+	s.Leave(testTenant, RoleServer, "a")
+	s.Leave(testTenant, RoleClient, "a")
+	if len(s.counts) != 0 {
+		t.Errorf("store still holds %v after every connection left", s.counts)
+	}
+}
+
+func TestMemoryBusDeliversByTenant(t *testing.T) {
+	b := NewMemoryBus()
+	var got, other []BusMessage
+	unsubscribe, err := b.Subscribe(testTenant, func(msg BusMessage) { got = append(got, msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Subscribe(otherTenant, func(msg BusMessage) { other = append(other, msg) })
+
+	b.Publish(BusMessage{TenantID: testTenant, From: "a", To: RoleClient, Data: []byte("first")})
+	unsubscribe()
+	b.Publish(BusMessage{TenantID: testTenant, From: "a", To: RoleClient, Data: []byte("second")})
+
+	if len(got) != 1 || string(got[0].Data) != "first" || got[0].To != RoleClient {
+		t.Errorf("subscriber got %v, want only the message published before unsubscribing", got)
+	}
+	if len(other) != 0 {
+		t.Errorf("other tenant's subscriber got %v", other)
+	}
+	if len(b.subs) != 1 {
+		t.Errorf("bus has %d tenants subscribed, want 1", len(b.subs))
+	}
+}
+
+func TestForwardAcrossInstances(t *testing.T) {
+	store, bus := NewMemoryStore(), NewMemoryBus()
+	var srvs []*httptest.Server
+	for range 2 {
+		r, err := NewRelayWithBackend(DefaultConfig(), store, bus)
+		if err != nil {
+			t.Fatalf("NewRelayWithBackend: %v", err)
+		}
+		srvs = append(srvs, serveRelay(t, r))
+	}
+
+	server := dial(t, srvs[0], "server", testTenant, nil)
+	client := dial(t, srvs[1], "client", testTenant, nil)
+	waitFor(t, "client to join the store", func() bool {
+		instances, _ := store.Instances(testTenant, RoleClient)
+		return len(instances) == 1
+	})
+
+	frame := dataFrame(t, "req-1", "ciphertext")
+	if err := server.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, client, frame)
+	if ack := readAck(t, server); ack.Clients != 1 || ack.Buffered {
+		t.Errorf("ack = %+v, want one client reached through the bus", ack)
+	}
+
+	reply := dataFrame(t, "req-1", "decision")
+	if err := client.WriteMessage(websocket.BinaryMessage, reply); err != nil {
+		t.Fatal(err)
+	}
+	readData(t, server, reply)
+}
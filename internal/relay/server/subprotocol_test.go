@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// dialOffering connects as a server for testTenant offering protocols
+func dialOffering(t *testing.T, srv *httptest.Server, protocols ...string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: protocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/server/"+testTenant, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// rejectedWith reports whether the relay closed conn with code
+func rejectedWith(conn *websocket.Conn, code int) bool {
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr) && closeErr.Code == code
+}
+
+func TestSubprotocolNegotiated(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	conn := dialOffering(t, srv, "extauthz.v0", relayproto.Subprotocol)
+	if got := conn.Subprotocol(); got != relayproto.Subprotocol {
+		t.Errorf("selected %q, want %q", got, relayproto.Subprotocol)
+	}
+	waitFor(t, "server to register", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server
+	})
+}
+
+func TestStrictSubprotocol(t *testing.T) {
+	// Peers offering nothing are let in unless strict
+	r, srv := newTestRelay(t, DefaultConfig())
+	if conn := dialOffering(t, srv); conn.Subprotocol() != "" {
+		t.Errorf("selected %q for a peer offering none", conn.Subprotocol())
+	}
+	waitFor(t, "server to register", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server
+	})
+
+	cfg := DefaultConfig()
+	cfg.StrictSubprotocol = true
+	_, srv = newTestRelay(t, cfg)
+	if conn := dialOffering(t, srv); !rejectedWith(conn, relayproto.CloseUnsupportedProtocol) {
+		t.Error("strict relay accepted a peer offering no subprotocol")
+	}
+	dialOffering(t, srv, relayproto.Subprotocol)
+}
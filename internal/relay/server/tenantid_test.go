@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	relayproto "github.com/yuval/extauth-match/internal/relay"
+)
+
+// handshakeStatus dials as a server for tenantID and returns the HTTP status
+// the relay refuses the handshake with
+func handshakeStatus(t *testing.T, srv *httptest.Server, tenantID string) int {
+	t.Helper()
+	_, resp, err := dialErr(srv, "server", tenantID, nil)
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp == nil {
+		t.Fatalf("dial: %v, want a refused handshake", err)
+	}
+	return resp.StatusCode
+}
+
+func TestValidTenantIDAccepted(t *testing.T) {
+	r, srv := newTestRelay(t, DefaultConfig())
+	dial(t, srv, "server", testTenant, nil)
+	waitFor(t, "tenant to register", func() bool {
+		server, _ := r.TenantStatus(testTenant)
+		return server
+	})
+}
+
+func TestEmptyTenantIDRejected(t *testing.T) {
+	r, _ := newTestRelay(t, DefaultConfig())
+	w := httptest.NewRecorder()
+	if r.validateTenantID(w, httptest.NewRequest(http.MethodGet, "/ws/server/", nil), "") {
+		t.Fatal("empty tenant ID accepted")
+	}
+	if w.Code != relayproto.RejectInvalidTenant.Status {
+		t.Errorf("status = %d, want %d", w.Code, relayproto.RejectInvalidTenant.Status)
+	}
+}
+
+func TestTenantIDRejections(t *testing.T) {
+	_, srv := newTestRelay(t, DefaultConfig())
+	if status := handshakeStatus(t, srv, "not-a-tenant"); status != relayproto.RejectInvalidTenant.Status {
+		t.Errorf("mismatched tenant ID refused with %d, want %d", status, relayproto.RejectInvalidTenant.Status)
+	}
+	long := strings.Repeat("a", relayproto.MaxTenantIDLength+1)
+	if status := handshakeStatus(t, srv, long); status != relayproto.RejectTenantIDTooLong.Status {
+		t.Errorf("over-long tenant ID refused with %d, want %d", status, relayproto.RejectTenantIDTooLong.Status)
+	}
+}
+
+func TestInvalidTenantIDPattern(t *testing.T) {
+	if _, err := ParseConfig([]string{"--tenant-id-pattern", "["}); err == nil {
+		t.Error("ParseConfig accepted an invalid tenant ID pattern")
+	}
+	cfg := DefaultConfig()
+	cfg.TenantIDPattern = nil
+	if _, err := NewRelay(cfg); err == nil {
+		t.Error("NewRelay accepted a config without a tenant ID pattern")
+	}
+}
+
+func TestTenantIDPatternFlag(t *testing.T) {
+	cfg, err := ParseConfig([]string{"--tenant-id-pattern", "^t-[0-9]+$"})
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if !cfg.TenantIDPattern.MatchString("t-42") || cfg.TenantIDPattern.MatchString(testTenant) {
+		t.Errorf("TenantIDPattern = %v, want the flag's pattern", cfg.TenantIDPattern)
+	}
+}
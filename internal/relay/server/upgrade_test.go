@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeFailureResponse(t *testing.T) {
+	logs := captureLogs(t)
+	_, srv := newTestRelay(t, DefaultConfig())
+
+	tests := []struct {
+		name   string
+		header http.Header
+		reason string
+	}{
+		{"not a WebSocket request", nil, "'upgrade' token not found"},
+		{"unsupported version", http.Header{
+			"Connection":            {"Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"8"},
+			"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+		}, "version"},
+	}
+	for _, role := range []string{"server", "client"} {
+		for _, tt := range tests {
+			t.Run(role+"/"+tt.name, func(t *testing.T) {
+				req, err := http.NewRequest(http.MethodGet, srv.URL+"/ws/"+role+"/"+testTenant, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for key, values := range tt.header {
+					req.Header[key] = values
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), tt.reason) {
+					t.Errorf("got %d %q, want 400 with the reason %q", resp.StatusCode, body, tt.reason)
+				}
+				if resp.Header.Get("Sec-Websocket-Version") != "13" {
+					t.Error("reply doesn't name the supported WebSocket version")
+				}
+			})
+		}
+	}
+
+	record := logs.findWith("Rejected invalid WebSocket upgrade", "tenantID", testTenant)
+	if addr, _ := record["remoteAddr"].(string); record["level"] != "WARN" || addr == "" || record["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("log record = %v, want a warning with the tenant, remote address and status", record)
+	}
+}
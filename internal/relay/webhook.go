@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WebhookEvent is the JSON payload posted when a decision arrives
+type WebhookEvent struct {
+	RequestID string    `json:"requestId"`
+	Approved  bool      `json:"approved"`
+	TenantID  string    `json:"tenantId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Webhook posts decisions to an external URL such as a chat or SIEM endpoint
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// NewWebhook creates a webhook posting to url with a 5 second timeout and 3 retries
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+		MaxRetries: 3,
+		RetryDelay: time.Second,
+	}
+}
+
+// Send posts event, retrying failures and non-2xx responses
+func (w *Webhook) Send(event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.MaxRetries {
+			return fmt.Errorf("webhook failed after %d attempts: %w", attempt+1, err)
+		}
+		slog.Warn("Webhook delivery failed, retrying", "attempt", attempt+1, "error", err)
+		time.Sleep(w.RetryDelay)
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.HTTPClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package relay_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// decideNext has browser answer its next request with approved
+func decideNext(browser *relaytest.Browser, approved bool) {
+	go func() {
+		req, err := browser.Next(context.Background(), nil)
+		if err == nil {
+			browser.Decide(req, approved)
+		}
+	}()
+}
+
+func TestWebhookPayload(t *testing.T) {
+	payloads := make(chan map[string]any, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payloads <- payload
+	}))
+	defer hook.Close()
+
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	webhook := relay.NewWebhook(hook.URL)
+	webhook.HTTPClient = hook.Client()
+	c.SetWebhook(webhook)
+	browser := dialBrowser(t, srv, key)
+
+	decideNext(browser, true)
+	if approved, err := c.SendRequestAndWait(ctxWithTimeout(t, 2*time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil || !approved {
+		t.Fatalf("approved=%v err=%v", approved, err)
+	}
+
+	select {
+	case payload := <-payloads:
+		if len(payload) != 4 || payload["requestId"] != "req-1" || payload["approved"] != true || payload["tenantId"] != crypto.DeriveTenantID(key) {
+			t.Errorf("payload = %v", payload)
+		}
+		if ts, _ := payload["timestamp"].(string); ts == "" {
+			t.Errorf("payload timestamp = %v", payload["timestamp"])
+		} else if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+			t.Errorf("payload timestamp: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var attempts, failures atomic.Int32
+	failures.Store(1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+
+	webhook := relay.NewWebhook(hook.URL)
+	webhook.RetryDelay = time.Millisecond
+	if err := webhook.Send(relay.WebhookEvent{RequestID: "req-1"}); err != nil || attempts.Load() != 2 {
+		t.Errorf("Send after one failure: err %v after %d attempts, want success on the second", err, attempts.Load())
+	}
+
+	attempts.Store(0)
+	failures.Store(100)
+	if err := webhook.Send(relay.WebhookEvent{RequestID: "req-2"}); err == nil || attempts.Load() != int32(webhook.MaxRetries+1) {
+		t.Errorf("Send to a failing endpoint: err %v after %d attempts, want an error after %d", err, attempts.Load(), webhook.MaxRetries+1)
+	}
+}
+
+func TestWebhookDoesNotBlockDecision(t *testing.T) {
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hook.Close()
+	defer close(release)
+
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key := newClient(t, srv)
+	c.SetWebhook(relay.NewWebhook(hook.URL))
+	browser := dialBrowser(t, srv, key)
+
+	decideNext(browser, false)
+	if approved, err := c.SendRequestAndWait(ctxWithTimeout(t, time.Second), "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil || approved {
+		t.Fatalf("approved=%v err=%v, want a prompt denial despite the hung webhook", approved, err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package relaytest runs the relay in process for end-to-end tests of the relay
+// Client and browser code, without starting the relay binary on a real port.
+// Server serves a relay server.Relay from an httptest.Server, and Browser is a
+// fake approval page that reads requests and answers them.
+package relaytest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relay/server"
+)
+
+// Server is an in-process relay
+type Server struct {
+	*httptest.Server
+	Relay *server.Relay
+}
+
+// NewServer starts an in-process relay with the default relay configuration;
+// call Close when done
+func NewServer() *Server {
+	return NewServerWithConfig(server.DefaultConfig())
+}
+
+// NewServerWithConfig starts an in-process relay with cfg, e.g. to change the
+// buffer size or rate limit. It panics if cfg is invalid or the relay's routes
+// can't be set up.
+func NewServerWithConfig(cfg server.Config) *Server {
+	r, err := server.NewRelay(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("relaytest: %v", err))
+	}
+	router := mux.NewRouter()
+	if err := r.RegisterRoutes(router); err != nil {
+		panic(fmt.Sprintf("relaytest: %v", err))
+	}
+	r.SetReady(true)
+	return &Server{Server: httptest.NewServer(router), Relay: r}
+}
+
+// WSURL is the relay URL to give relay.NewClient
+func (s *Server) WSURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Clients returns how many browser clients tenantID has connected
+func (s *Server) Clients(tenantID string) int {
+	for _, status := range s.Relay.Snapshot() {
+		if status.TenantID == tenantID {
+			return status.Clients
+		}
+	}
+	return 0
+}
+
+// DropServer closes tenantID's server connection without a close handshake, as
+// a network failure would, and reports whether one was connected
+func (s *Server) DropServer(tenantID string) bool {
+	return s.Relay.DisconnectServer(tenantID)
+}
+
+// Close shuts the relay down, then the HTTP server
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Relay.Shutdown(ctx)
+	s.Server.Close()
+}
+
+// Browser is a fake approval page connected to a Server
+type Browser struct {
+	ws      *websocket.Conn
+	encKey  []byte
+	macKey  []byte
+	chunks  *relay.Reassembler
+	writeMu sync.Mutex
+
+	// Approver identifies the browser in its decisions, for quorums
+	Approver string
+}
+
+// DialBrowser connects a fake approval page for tenantID holding key
+func (s *Server) DialBrowser(tenantID string, key []byte) (*Browser, error) {
+	encKey, err := crypto.DeriveSubkey(key, relay.EncSubkeyPurpose, crypto.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := crypto.DeriveSubkey(key, relay.MACSubkeyPurpose, 32)
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{Subprotocols: relay.Subprotocols}
+	ws, _, err := dialer.Dial(fmt.Sprintf("%s/ws/client/%s", s.WSURL(), tenantID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Browser{
+		ws:       ws,
+		encKey:   encKey,
+		macKey:   macKey,
+		chunks:   relay.NewReassembler(),
+		Approver: "relaytest",
+	}, nil
+}
+
+// Next returns the next request the browser is sent, skipping other frames.
+// Cancelled request IDs are reported through cancelled, if not nil.
+func (b *Browser) Next(ctx context.Context, cancelled func(requestID string)) (relay.AuthRequest, error) {
+	stop := context.AfterFunc(ctx, func() { b.ws.Close() })
+	defer stop()
+
+	for {
+		_, message, err := b.ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return relay.AuthRequest{}, ctx.Err()
+			}
+			return relay.AuthRequest{}, err
+		}
+		frameType, payload, err := relay.DecodeFrame(message)
+		if err != nil {
+			continue
+		}
+		if frameType == relay.FrameChunk {
+			frame, err := b.chunks.Add(payload)
+			if err != nil || frame == nil {
+				continue
+			}
+			if frameType, payload, err = relay.DecodeFrame(frame); err != nil {
+				continue
+			}
+		}
+
+		switch frameType {
+		case relay.FrameControl:
+			var control relay.ControlFrame
+			if json.Unmarshal(payload, &control) == nil && control.Type == relay.ControlTypeCancel && cancelled != nil {
+				cancelled(control.RequestID)
+			}
+		case relay.FrameData:
+			_, ciphertext, err := relay.DecodeData(b.macKey, payload)
+			if err != nil {
+				return relay.AuthRequest{}, fmt.Errorf("%w: %w", relay.ErrDecrypt, err)
+			}
+			plaintext, err := crypto.OpenEnvelope(b.encKey, ciphertext)
+			if err != nil {
+				return relay.AuthRequest{}, fmt.Errorf("%w: %w", relay.ErrDecrypt, err)
+			}
+			var req relay.AuthRequest
+			if err := json.Unmarshal(plaintext, &req); err != nil {
+				return relay.AuthRequest{}, err
+			}
+			return req, nil
+		}
+	}
+}
+
+// Decide answers req the way the approval page does, echoing its nonce
+func (b *Browser) Decide(req relay.AuthRequest, approved bool) error {
+	return b.send(req.ID, map[string]any{
+		"requestId": req.ID,
+		"approved":  approved,
+		"approver":  b.Approver,
+		"nonce":     req.Nonce,
+	})
+}
+
+// ApproveWithHeaders approves req, asking for headers to be added to the
+// approved request's response
+func (b *Browser) ApproveWithHeaders(req relay.AuthRequest, headers map[string]string) error {
+	return b.send(req.ID, map[string]any{
+		"requestId": req.ID,
+		"approved":  true,
+		"approver":  b.Approver,
+		"nonce":     req.Nonce,
+		"headers":   headers,
+	})
+}
+
+// DecideBatch answers every request in reqs with one batched decision,
+// approving reqs[i] if approved[i]
+func (b *Browser) DecideBatch(reqs []relay.AuthRequest, approved []bool) error {
+	if len(reqs) != len(approved) {
+		return fmt.Errorf("%d requests but %d decisions", len(reqs), len(approved))
+	}
+	decisions := make([]map[string]any, len(reqs))
+	for i, req := range reqs {
+		decisions[i] = map[string]any{
+			"requestId": req.ID,
+			"approved":  approved[i],
+			"nonce":     req.Nonce,
+		}
+	}
+	return b.send("", map[string]any{
+		"approver":  b.Approver,
+		"decisions": decisions,
+	})
+}
+
+// send encrypts a decision message and sends it with requestID in its routing header
+func (b *Browser) send(requestID string, msg map[string]any) error {
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := crypto.SealEnvelope(crypto.AlgAES256GCM, b.encKey, plaintext)
+	if err != nil {
+		return err
+	}
+	frame, err := relay.EncodeData(b.macKey, relay.RoutingHeader{RequestID: requestID}, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	return b.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// Close disconnects the browser
+func (b *Browser) Close() error {
+	return b.ws.Close()
+}
@@ -0,0 +1,104 @@
+package relaytest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yuval/extauth-match/internal/crypto"
+	"github.com/yuval/extauth-match/internal/relay"
+	"github.com/yuval/extauth-match/internal/relaytest"
+)
+
+// pair connects a Client and returns it with its key and tenant ID
+func pair(t *testing.T, srv *relaytest.Server) (*relay.Client, []byte, string) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantID := crypto.DeriveTenantID(key)
+	c, err := relay.NewClient(srv.WSURL(), tenantID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return c, key, tenantID
+}
+
+// browser connects a fake approval page and waits for the relay to attach it
+func browser(t *testing.T, srv *relaytest.Server, tenantID string, key []byte) *relaytest.Browser {
+	t.Helper()
+	before := srv.Clients(tenantID)
+	b, err := srv.DialBrowser(tenantID, key)
+	if err != nil {
+		t.Fatalf("DialBrowser: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Clients(tenantID) <= before {
+		if time.Now().After(deadline) {
+			t.Fatal("browser never attached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key, tenantID := pair(t, srv)
+	b := browser(t, srv, tenantID, key)
+
+	go func() {
+		req, err := b.Next(context.Background(), nil)
+		if err == nil {
+			b.Decide(req, true)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	approved, err := c.SendRequestAndWait(ctx, "req-1", relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/admin"})
+	if err != nil || !approved {
+		t.Errorf("SendRequestAndWait = %v, %v, want approved", approved, err)
+	}
+}
+
+func TestEveryBrowserSeesRequest(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key, tenantID := pair(t, srv)
+	phone, laptop := browser(t, srv, tenantID, key), browser(t, srv, tenantID, key)
+
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string]*relaytest.Browser{"phone": phone, "laptop": laptop} {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		req, err := b.Next(ctx, nil)
+		cancel()
+		if err != nil || req.ID != "req-1" {
+			t.Errorf("%s: Next = %+v, %v, want req-1", name, req, err)
+		}
+	}
+}
+
+func TestBufferedUntilBrowserConnects(t *testing.T) {
+	srv := relaytest.NewServer()
+	defer srv.Close()
+	c, key, tenantID := pair(t, srv)
+
+	if err := c.SendAuthRequest(relay.AuthRequest{ID: "req-1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	b := browser(t, srv, tenantID, key)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if req, err := b.Next(ctx, nil); err != nil || req.ID != "req-1" {
+		t.Errorf("Next = %+v, %v, want the buffered req-1", req, err)
+	}
+}
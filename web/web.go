@@ -0,0 +1,9 @@
+// Package web holds the browser client assets served by the relay
+package web
+
+import "embed"
+
+// Static contains the files under static/, rooted at "static"
+//
+//go:embed static
+var Static embed.FS